@@ -9,11 +9,17 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/olekukonko/tablewriter"
 
+	"github.com/mobmob912/takuhai/master/api"
 	"github.com/mobmob912/takuhai/master/master"
+	// worker()と名前がぶつかるので別名にする
+	masterworker "github.com/mobmob912/takuhai/master/worker"
 
 	"github.com/mobmob912/takuhai/domain"
 
@@ -50,6 +56,8 @@ func run() error {
 		return worker(args)
 	case "workflow":
 		return workflow(args)
+	case "run":
+		return runCmd(args)
 	}
 	return errors.New("no commands matched")
 }
@@ -60,6 +68,12 @@ func worker(args []string) error {
 	switch cmd {
 	case "list":
 		return workerList(args)
+	case "cordon":
+		return cordonWorker(args, "cordon")
+	case "uncordon":
+		return cordonWorker(args, "uncordon")
+	case "drain":
+		return drainWorker(args)
 	}
 	return nil
 }
@@ -82,6 +96,104 @@ func workerList(args []string) error {
 	return nil
 }
 
+// ワーカーの名前かIDからIDを引く
+func findWorkerID(nameOrID string) (string, error) {
+	ws, err := listWorkers()
+	if err != nil {
+		return "", err
+	}
+	for _, w := range ws {
+		if w.Name == nameOrID || w.ID == nameOrID {
+			return w.ID, nil
+		}
+	}
+	return "", fmt.Errorf("worker %s is not found", nameOrID)
+}
+
+func listWorkers() ([]*api.WorkerInfoResponse, error) {
+	res, err := http.Get(URL + "/workers")
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return nil, errors.New(string(body))
+	}
+	var ws []*api.WorkerInfoResponse
+	if err := json.NewDecoder(res.Body).Decode(&ws); err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+func postWorkerAction(id, action string) error {
+	res, err := http.Post(fmt.Sprintf("%s/workers/%s/%s", URL, id, action), "application/json", nil)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= 400 {
+		body, _ := ioutil.ReadAll(res.Body)
+		return fmt.Errorf("%s failed: %s", action, string(body))
+	}
+	return nil
+}
+
+// actionはcordonかuncordon
+func cordonWorker(args []string, action string) error {
+	if len(args) < 4 {
+		return errors.New("worker name is missing")
+	}
+	id, err := findWorkerID(args[3])
+	if err != nil {
+		return err
+	}
+	if err := postWorkerAction(id, action); err != nil {
+		return err
+	}
+	log.Printf("%s %sed", args[3], action)
+	return nil
+}
+
+// 実行中のジョブが終わってアンデプロイされるまで待つ
+func drainWorker(args []string) error {
+	if len(args) < 4 {
+		return errors.New("worker name is missing")
+	}
+	id, err := findWorkerID(args[3])
+	if err != nil {
+		return err
+	}
+	if err := postWorkerAction(id, "drain"); err != nil {
+		return err
+	}
+	log.Printf("draining %s", args[3])
+	for {
+		time.Sleep(time.Second)
+		ws, err := listWorkers()
+		if err != nil {
+			return err
+		}
+		var drain masterworker.DrainState
+		found := false
+		for _, w := range ws {
+			if w.ID == id {
+				drain, found = w.Drain, true
+			}
+		}
+		if !found {
+			return fmt.Errorf("worker %s is deleted while draining", args[3])
+		}
+		switch drain {
+		case masterworker.DrainStateDrained:
+			log.Printf("%s drained", args[3])
+			return nil
+		case masterworker.DrainStateDraining:
+			continue
+		}
+		return fmt.Errorf("drain of %s is canceled", args[3])
+	}
+}
+
 func workflow(args []string) error {
 	cmd := args[2]
 
@@ -90,49 +202,106 @@ func workflow(args []string) error {
 		return addWorkflow(args)
 	case "status":
 		return workflowStatus(args)
+	case "validate":
+		return validateWorkflow(args)
+	case "update":
+		return updateWorkflow(args)
+	case "versions":
+		return workflowVersions(args)
+	case "rollback":
+		return rollbackWorkflow(args)
+	case "schedule":
+		return workflowSchedule(args)
+	case "runs":
+		return workflowRuns(args)
+	case "telemetry":
+		return workflowTelemetry(args)
+	case "explain":
+		return explainPlacement(args)
 	}
 	return nil
 }
 
-func addWorkflow(args []string) error {
-
-	path := args[3]
-	ss := strings.Split(path, string(os.PathSeparator))
-	workDir := strings.Join(ss[:len(ss)-1], string(os.PathSeparator))
-
+func readWorkflowFile(path string) (*domain.Workflow, error) {
 	file, err := os.Open(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer file.Close()
 
 	var wf domain.Workflow
 	if err := yaml.NewDecoder(file).Decode(&wf); err != nil {
+		return nil, err
+	}
+	return &wf, nil
+}
+
+func printValidationErrors(errs domain.ValidationErrors) {
+	for _, e := range errs {
+		log.Printf("  %s: %s", e.Path, e.Message)
+	}
+}
+
+// masterに問い合わせずにワークフローを検証する
+func validateWorkflow(args []string) error {
+	if len(args) < 4 {
+		return errors.New("workflow file path is missing")
+	}
+	wf, err := readWorkflowFile(args[3])
+	if err != nil {
 		return err
 	}
+	if errs := wf.Validate(nil); len(errs) != 0 {
+		log.Printf("%d problems found", len(errs))
+		printValidationErrors(errs)
+		return errors.New("invalid workflow")
+	}
+	log.Println("ok")
+	return nil
+}
+
+// ワークフローのyamlを読み込んで、shellのジョブはファイルの中身に置き換える
+func loadWorkflow(path string) (*domain.Workflow, error) {
+	ss := strings.Split(path, string(os.PathSeparator))
+	workDir := strings.Join(ss[:len(ss)-1], string(os.PathSeparator))
+
+	wf, err := readWorkflowFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if errs := wf.Validate(nil); len(errs) != 0 {
+		log.Printf("%d problems found", len(errs))
+		printValidationErrors(errs)
+		return nil, errors.New("invalid workflow")
+	}
 
 	for jI, j := range wf.Jobs {
 		for imgI, img := range j.Images {
 			if img.Type == domain.ImageTypeShell {
 				shellFile, err := os.Open(fmt.Sprintf("%s/%s", workDir, img.Image))
 				if err != nil {
-					return err
+					return nil, err
 				}
 				shell, err := ioutil.ReadAll(shellFile)
 				if err != nil {
-					return err
+					return nil, err
 				}
 				// TODO いい感じにパース
 				wf.Jobs[jI].Images[imgI].Image = string(shell)
 			}
 		}
 	}
+	return wf, nil
+}
+
+func sendWorkflow(method, u string, wf *domain.Workflow) error {
 	body, err := json.Marshal(wf)
 	if err != nil {
 		return err
 	}
 	log.Println(string(body))
 	client := http.DefaultClient
-	req, err := http.NewRequest("POST", URL+"/workflows", bytes.NewReader(body))
+	req, err := http.NewRequest(method, u, bytes.NewReader(body))
 	if err != nil {
 		return err
 	}
@@ -144,10 +313,318 @@ func addWorkflow(args []string) error {
 	if err != nil {
 		return err
 	}
+	if res.StatusCode == http.StatusBadRequest {
+		var verr api.ValidationErrorResponse
+		if err := json.Unmarshal(resBody, &verr); err == nil && len(verr.Errors) != 0 {
+			log.Printf("%d problems found", len(verr.Errors))
+			printValidationErrors(verr.Errors)
+			return errors.New("invalid workflow")
+		}
+	}
 	log.Println(string(resBody))
 	return nil
 }
 
+func addWorkflow(args []string) error {
+	wf, err := loadWorkflow(args[3])
+	if err != nil {
+		return err
+	}
+	return sendWorkflow(http.MethodPost, URL+"/workflows", wf)
+}
+
+func updateWorkflow(args []string) error {
+	if len(args) < 4 {
+		return errors.New("workflow file path is missing")
+	}
+	wf, err := loadWorkflow(args[3])
+	if err != nil {
+		return err
+	}
+	return sendWorkflow(http.MethodPut, fmt.Sprintf("%s/workflows/%s", URL, wf.Name), wf)
+}
+
+func workflowVersions(args []string) error {
+	if len(args) < 4 {
+		return errors.New("workflow name is missing")
+	}
+	res, err := http.Get(fmt.Sprintf("%s/workflows/%s/versions", URL, args[3]))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return errors.New(string(body))
+	}
+	var wfs []*domain.Workflow
+	if err := json.NewDecoder(res.Body).Decode(&wfs); err != nil {
+		return err
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"VERSION", "STEPS"})
+	for _, wf := range wfs {
+		names := make([]string, len(wf.Steps))
+		for i, s := range wf.Steps {
+			names[i] = s.Name
+		}
+		table.Append([]string{strconv.Itoa(wf.Version), strings.Join(names, ", ")})
+	}
+	table.Render()
+	return nil
+}
+
+func rollbackWorkflow(args []string) error {
+	if len(args) < 5 {
+		return errors.New("workflow name and version are required")
+	}
+	version, err := strconv.Atoi(args[4])
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(&api.RollbackWorkflowRequest{Version: version})
+	if err != nil {
+		return err
+	}
+	res, err := http.Post(fmt.Sprintf("%s/workflows/%s/rollback", URL, args[3]), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return errors.New(string(resBody))
+	}
+	log.Println(string(resBody))
+	return nil
+}
+
+// cronトリガーの次回以降の実行時刻を表示する
+func workflowSchedule(args []string) error {
+	if len(args) < 4 {
+		return errors.New("workflow name is missing")
+	}
+	res, err := http.Get(fmt.Sprintf("%s/workflows/%s/schedule", URL, args[3]))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return errors.New(string(body))
+	}
+	var fires master.UpcomingFires
+	if err := json.NewDecoder(res.Body).Decode(&fires); err != nil {
+		return err
+	}
+	log.Printf("schedule: %s", fires.Schedule)
+	for _, t := range fires.Times {
+		log.Println(t.Local().Format(time.RFC3339))
+	}
+	return nil
+}
+
+func runCmd(args []string) error {
+	cmd := args[2]
+
+	switch cmd {
+	case "show":
+		return showRun(args)
+	}
+	return nil
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.RFC3339)
+}
+
+// ワークフローの実行履歴を新しい順に表示する
+func workflowRuns(args []string) error {
+	if len(args) < 4 {
+		return errors.New("workflow name is missing")
+	}
+	res, err := http.Get(fmt.Sprintf("%s/workflows/%s/runs", URL, args[3]))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return errors.New(string(body))
+	}
+	var runs []*domain.Run
+	if err := json.NewDecoder(res.Body).Decode(&runs); err != nil {
+		return err
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"RUN ID", "VERSION", "STATUS", "STARTED AT", "FINISHED AT"})
+	for _, r := range runs {
+		table.Append([]string{r.ID, strconv.Itoa(r.WorkflowVersion), string(r.Status), formatTime(r.StartedAt), formatTime(r.FinishedAt)})
+	}
+	table.Render()
+	return nil
+}
+
+// ステップ毎の実行時間と転送時間のパーセンタイルを表示する
+func workflowTelemetry(args []string) error {
+	if len(args) < 4 {
+		return errors.New("workflow name is missing")
+	}
+	res, err := http.Get(fmt.Sprintf("%s/workflows/%s/telemetry", URL, args[3]))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return errors.New(string(body))
+	}
+	var t domain.WorkflowTelemetry
+	if err := json.NewDecoder(res.Body).Decode(&t); err != nil {
+		return err
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"STEP", "", "COUNT", "P50", "P90", "P99", "MAX"})
+	for _, s := range t.Steps {
+		for _, row := range []struct {
+			name string
+			p    domain.DurationPercentiles
+		}{{"runtime", s.Runtime}, {"transfer", s.TransferTime}} {
+			table.Append([]string{s.StepName, row.name, strconv.Itoa(row.p.Count), row.p.P50.String(), row.p.P90.String(), row.p.P99.String(), row.p.Max.String()})
+		}
+	}
+	table.Render()
+	return nil
+}
+
+// ステップがどのワーカーに配置されるかと、ワーカー毎の判断の内訳を表示する
+// $ takuhai workflow explain <workflow name> <step name> [previous worker id]
+func explainPlacement(args []string) error {
+	if len(args) < 5 {
+		return errors.New("workflow name and step name are required")
+	}
+	wf, err := getWorkflowByName(args[3])
+	if err != nil {
+		return err
+	}
+	var stepID string
+	for _, s := range wf.Steps {
+		if s.Name == args[4] {
+			stepID = s.ID
+		}
+		if s.Failure != nil && s.Failure.Name == args[4] {
+			stepID = s.Failure.ID
+		}
+	}
+	if stepID == "" {
+		return fmt.Errorf("step %s is not found in workflow %s", args[4], wf.Name)
+	}
+	u := fmt.Sprintf("%s/workflows/%s/steps/%s/placement?explain=true", URL, wf.ID, stepID)
+	if len(args) >= 6 {
+		u += "&previousJobWorkerID=" + args[5]
+	}
+	res, err := http.Get(u)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return errors.New(string(body))
+	}
+	var e master.PlacementExplanation
+	if err := json.NewDecoder(res.Body).Decode(&e); err != nil {
+		return err
+	}
+	if e.WorkerID == "" {
+		log.Printf("step %s: no worker matched", e.StepName)
+	} else {
+		log.Printf("step %s: %s (decided by %s)", e.StepName, e.WorkerName, e.DecidedBy)
+	}
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"WORKER", "REJECTED BY", "TOTAL", "SCORES", "PREDICTION"})
+	for _, w := range e.Workers {
+		if w.RejectedBy != "" {
+			table.Append([]string{w.WorkerName, w.RejectedBy, "-", "-", "-"})
+			continue
+		}
+		names := make([]string, 0, len(w.Scores))
+		for name := range w.Scores {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		scores := make([]string, len(names))
+		for i, name := range names {
+			scores[i] = fmt.Sprintf("%s=%.2f", name, w.Scores[name])
+		}
+		prediction := "-"
+		if w.Prediction != nil {
+			prediction = fmt.Sprintf("%s (run %s + transfer %s)", w.Prediction.Total, w.Prediction.Runtime, w.Prediction.Transfer)
+		}
+		table.Append([]string{w.WorkerName, "-", fmt.Sprintf("%.2f", w.Total), strings.Join(scores, " "), prediction})
+	}
+	table.Render()
+	return nil
+}
+
+func getWorkflowByName(name string) (*domain.Workflow, error) {
+	res, err := http.Get(URL + "/workflows")
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return nil, errors.New(string(body))
+	}
+	var wfs []*domain.Workflow
+	if err := json.NewDecoder(res.Body).Decode(&wfs); err != nil {
+		return nil, err
+	}
+	for _, wf := range wfs {
+		if wf.Name == name {
+			return wf, nil
+		}
+	}
+	return nil, fmt.Errorf("workflow %s is not found", name)
+}
+
+// runのステップ毎の実行結果を表示する
+func showRun(args []string) error {
+	if len(args) < 4 {
+		return errors.New("run id is missing")
+	}
+	res, err := http.Get(fmt.Sprintf("%s/runs/%s", URL, args[3]))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return errors.New(string(body))
+	}
+	var r domain.Run
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return err
+	}
+	log.Printf("run %s (version %d): %s", r.ID, r.WorkflowVersion, r.Status)
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"STEP", "ATTEMPT", "WORKER", "STATUS", "STARTED AT", "DURATION", "MESSAGE"})
+	for _, sr := range r.Steps {
+		table.Append([]string{sr.StepName, strconv.Itoa(sr.Attempt), sr.WorkerID, string(sr.Status), formatTime(sr.StartedAt), sr.Duration.String(), sr.Message})
+	}
+	table.Render()
+	if len(r.Placements) == 0 {
+		return nil
+	}
+	log.Println("placements by placement policy")
+	table = tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"STEP", "WORKER", "PLACE", "FALLBACK", "WAITED", "TIME"})
+	for _, p := range r.Placements {
+		table.Append([]string{p.StepName, p.WorkerID, string(p.Place), strconv.FormatBool(p.Fallback), p.Waited.String(), formatTime(p.Time)})
+	}
+	table.Render()
+	return nil
+}
+
 func workflowStatus(args []string) error {
 	log.Println("called")
 	workflowName := args[3]
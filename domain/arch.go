@@ -0,0 +1,137 @@
+package domain
+
+import (
+	"strconv"
+	"strings"
+)
+
+// GOARCHの名前にそろえたアーキテクチャ. 32bitのarmはバリアント付き (ex: arm/v7)
+// イメージのarchはカンマ区切りで複数書ける (ex: amd64,arm64)
+type ArchType string
+
+const (
+	ArchTypeAMD64 ArchType = "amd64"
+	ArchType386   ArchType = "386"
+	ArchTypeARM64 ArchType = "arm64"
+	// バリアントの分からない32bitのarm
+	ArchTypeARM   ArchType = "arm"
+	ArchTypeARMv6 ArchType = "arm/v6"
+	ArchTypeARMv7 ArchType = "arm/v7"
+
+	// Deprecated: ArchTypeAMD64の別名
+	ArchTypeAMD ArchType = "amd"
+)
+
+// k=別名, v=正式な名前
+var archAliases = map[string]ArchType{
+	"amd":      ArchTypeAMD64,
+	"x86_64":   ArchTypeAMD64,
+	"x86-64":   ArchTypeAMD64,
+	"x64":      ArchTypeAMD64,
+	"i386":     ArchType386,
+	"i686":     ArchType386,
+	"x86":      ArchType386,
+	"aarch64":  ArchTypeARM64,
+	"armv8":    ArchTypeARM64,
+	"arm/v8":   ArchTypeARM64,
+	"arm64/v8": ArchTypeARM64,
+	"armv7":    ArchTypeARMv7,
+	"armv7l":   ArchTypeARMv7,
+	"armhf":    ArchTypeARMv7,
+	"arm32v7":  ArchTypeARMv7,
+	"armv6":    ArchTypeARMv6,
+	"armv6l":   ArchTypeARMv6,
+	"armel":    ArchTypeARMv6,
+	"arm32v6":  ArchTypeARMv6,
+}
+
+// 別名を正式な名前にする. 知らない名前は小文字にしただけで返す
+// linux/arm/v7のようなDockerのplatformの書き方も受け付ける
+func (t ArchType) Canonical() ArchType {
+	s := strings.ToLower(strings.TrimSpace(string(t)))
+	s = strings.TrimPrefix(s, "linux/")
+	if a, ok := archAliases[s]; ok {
+		return a
+	}
+	return ArchType(s)
+}
+
+// armならバリアントの数字. 無ければ0
+func (t ArchType) split() (string, int) {
+	kv := strings.SplitN(string(t), "/v", 2)
+	if len(kv) == 1 {
+		return kv[0], 0
+	}
+	variant, err := strconv.Atoi(kv[1])
+	if err != nil {
+		return string(t), 0
+	}
+	return kv[0], variant
+}
+
+// 1つのイメージのarchが、ワーカーのarchでどれだけ具体的に合うか. 実行できなければ0
+// 完全に一致すれば3、古いバリアント向けなら2、バリアントを書いていなければ1
+func archMatchLevel(image, worker ArchType) int {
+	image, worker = image.Canonical(), worker.Canonical()
+	if image == worker {
+		return 3
+	}
+	family, variant := image.split()
+	workerFamily, workerVariant := worker.split()
+	if family != workerFamily {
+		return 0
+	}
+	switch {
+	case variant == 0:
+		return 1
+	case workerVariant == 0:
+		// バリアントの分からないarmのワーカーでも、v6向けならどのarmでも動く
+		if variant == 6 {
+			return 1
+		}
+		return 0
+	case variant < workerVariant:
+		return 2
+	}
+	return 0
+}
+
+// カンマ区切りの中で最も合うものの度合い
+func (t ArchType) matchLevel(at ArchType) int {
+	level := 0
+	for _, tt := range strings.Split(string(t), ",") {
+		for _, att := range strings.Split(string(at), ",") {
+			if l := archMatchLevel(ArchType(tt), ArchType(att)); l > level {
+				level = l
+			}
+		}
+	}
+	return level
+}
+
+// tのイメージがatのワーカーで実行できるかどうか
+func (t ArchType) Satisfy(at ArchType) bool {
+	return t.matchLevel(at) > 0
+}
+
+// ワーカーで実行できるイメージのうち、archが最も具体的に合うもの. 無ければnil
+// masterの配置とワーカーのデプロイで同じ判定を使う
+// 同じ度合いなら、archを1つだけ書いたイメージ、先に書いたイメージの順に選ぶ
+func (j *Job) ImageFor(typ ImageType, arch ArchType) *Image {
+	var best *Image
+	bestLevel, bestArchs := 0, 0
+	for _, img := range j.Images {
+		if !img.Type.Satisfy(typ) {
+			continue
+		}
+		level := img.Arch.matchLevel(arch)
+		if level == 0 {
+			continue
+		}
+		archs := len(strings.Split(string(img.Arch), ","))
+		if best == nil || level > bestLevel || (level == bestLevel && archs < bestArchs) {
+			best, bestLevel, bestArchs = img, level, archs
+		}
+	}
+	return best
+}
@@ -0,0 +1,468 @@
+package domain
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ステップのwhenの式
+// ex: payload.temperature > 30 && outputs.sensor.status == "ok"
+// payloadは直前のステップの出力(合流するステップでは親ステップ名をキーにしたオブジェクト)、
+// outputsは名前付きの出力を表す。JSONとして読めない出力は文字列として扱う
+type Condition struct {
+	root condNode
+}
+
+func ParseCondition(expr string) (*Condition, error) {
+	tokens, err := tokenizeCondition(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &condParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("invalid condition. unexpected %s", p.peek().text)
+	}
+	return &Condition{root: root}, nil
+}
+
+func (c *Condition) Eval(p *Payload) (bool, error) {
+	env := map[string]interface{}{
+		"payload": decodeConditionValue(p.Body),
+	}
+	outputs := make(map[string]interface{}, len(p.Outputs))
+	for name, body := range p.Outputs {
+		outputs[name] = decodeConditionValue(body)
+	}
+	env["outputs"] = outputs
+	v, err := c.root.eval(env)
+	if err != nil {
+		return false, err
+	}
+	return truthy(v), nil
+}
+
+// whenが無いか、式が真の時にステップを実行する
+func (s *Step) ShouldRun(p *Payload) (bool, error) {
+	if s.When == "" {
+		return true, nil
+	}
+	c, err := ParseCondition(s.When)
+	if err != nil {
+		return false, err
+	}
+	return c.Eval(p)
+}
+
+func decodeConditionValue(body []byte) interface{} {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		return string(body)
+	}
+	return v
+}
+
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != ""
+	}
+	return true
+}
+
+type condNode interface {
+	eval(env map[string]interface{}) (interface{}, error)
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n *literalNode) eval(env map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+// payload.items[0].name のような参照. 存在しなければnull
+type pathNode struct {
+	keys []interface{}
+}
+
+func (n *pathNode) eval(env map[string]interface{}) (interface{}, error) {
+	var v interface{} = env
+	for _, k := range n.keys {
+		switch k := k.(type) {
+		case string:
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, nil
+			}
+			v = m[k]
+		case int:
+			a, ok := v.([]interface{})
+			if !ok || k < 0 || k >= len(a) {
+				return nil, nil
+			}
+			v = a[k]
+		}
+	}
+	return v, nil
+}
+
+type notNode struct {
+	x condNode
+}
+
+func (n *notNode) eval(env map[string]interface{}) (interface{}, error) {
+	v, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return !truthy(v), nil
+}
+
+type logicalNode struct {
+	op   string
+	l, r condNode
+}
+
+func (n *logicalNode) eval(env map[string]interface{}) (interface{}, error) {
+	l, err := n.l.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "&&" && !truthy(l) {
+		return false, nil
+	}
+	if n.op == "||" && truthy(l) {
+		return true, nil
+	}
+	r, err := n.r.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return truthy(r), nil
+}
+
+type compareNode struct {
+	op   string
+	l, r condNode
+}
+
+func (n *compareNode) eval(env map[string]interface{}) (interface{}, error) {
+	l, err := n.l.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.r.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==":
+		return equalValues(l, r), nil
+	case "!=":
+		return !equalValues(l, r), nil
+	}
+	// 大小比較は数値同士か文字列同士のみ. 片方が無い時は偽
+	if l == nil || r == nil {
+		return false, nil
+	}
+	var c int
+	switch lv := l.(type) {
+	case float64:
+		rv, ok := r.(float64)
+		if !ok {
+			return nil, fmt.Errorf("can not compare number with %v", r)
+		}
+		switch {
+		case lv < rv:
+			c = -1
+		case lv > rv:
+			c = 1
+		}
+	case string:
+		rv, ok := r.(string)
+		if !ok {
+			return nil, fmt.Errorf("can not compare string with %v", r)
+		}
+		c = strings.Compare(lv, rv)
+	default:
+		return nil, fmt.Errorf("can not compare %v", l)
+	}
+	switch n.op {
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	}
+	return nil, fmt.Errorf("unknown operator %s", n.op)
+}
+
+func equalValues(l, r interface{}) bool {
+	switch lv := l.(type) {
+	case nil:
+		return r == nil
+	case bool:
+		rv, ok := r.(bool)
+		return ok && lv == rv
+	case float64:
+		rv, ok := r.(float64)
+		return ok && lv == rv
+	case string:
+		rv, ok := r.(string)
+		return ok && lv == rv
+	}
+	// オブジェクトや配列はJSONとして比較する
+	lb, lerr := json.Marshal(l)
+	rb, rerr := json.Marshal(r)
+	return lerr == nil && rerr == nil && string(lb) == string(rb)
+}
+
+type condTokenKind int
+
+const (
+	condTokenIdent condTokenKind = iota
+	condTokenNumber
+	condTokenString
+	condTokenSymbol
+)
+
+type condToken struct {
+	kind condTokenKind
+	text string
+}
+
+func tokenizeCondition(expr string) ([]*condToken, error) {
+	rs := []rune(expr)
+	tokens := make([]*condToken, 0)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'':
+			j := i + 1
+			var sb strings.Builder
+			for ; j < len(rs) && rs[j] != r; j++ {
+				if rs[j] == '\\' && j+1 < len(rs) {
+					j++
+				}
+				sb.WriteRune(rs[j])
+			}
+			if j >= len(rs) {
+				return nil, fmt.Errorf("invalid condition. unterminated string")
+			}
+			tokens = append(tokens, &condToken{kind: condTokenString, text: sb.String()})
+			i = j + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])):
+			j := i + 1
+			for j < len(rs) && (unicode.IsDigit(rs[j]) || rs[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, &condToken{kind: condTokenNumber, text: string(rs[i:j])})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i + 1
+			for j < len(rs) && (unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j]) || rs[j] == '_' || rs[j] == '-') {
+				j++
+			}
+			tokens = append(tokens, &condToken{kind: condTokenIdent, text: string(rs[i:j])})
+			i = j
+		default:
+			if i+1 < len(rs) {
+				switch two := string(rs[i : i+2]); two {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					tokens = append(tokens, &condToken{kind: condTokenSymbol, text: two})
+					i += 2
+					continue
+				}
+			}
+			switch r {
+			case '<', '>', '!', '(', ')', '.', '[', ']':
+				tokens = append(tokens, &condToken{kind: condTokenSymbol, text: string(r)})
+				i++
+			default:
+				return nil, fmt.Errorf("invalid condition. unexpected character %q", r)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+type condParser struct {
+	tokens []*condToken
+	pos    int
+}
+
+func (p *condParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *condParser) peek() *condToken {
+	if p.done() {
+		return &condToken{text: "end of expression"}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *condParser) acceptSymbol(text string) bool {
+	t := p.peek()
+	if p.done() || t.kind != condTokenSymbol || t.text != text {
+		return false
+	}
+	p.pos++
+	return true
+}
+
+func (p *condParser) parseOr() (condNode, error) {
+	l, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptSymbol("||") {
+		r, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l = &logicalNode{op: "||", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *condParser) parseAnd() (condNode, error) {
+	l, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptSymbol("&&") {
+		r, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l = &logicalNode{op: "&&", l: l, r: r}
+	}
+	return l, nil
+}
+
+func (p *condParser) parseUnary() (condNode, error) {
+	if p.acceptSymbol("!") {
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{x: x}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *condParser) parseCompare() (condNode, error) {
+	l, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.acceptSymbol(op) {
+			r, err := p.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			return &compareNode{op: op, l: l, r: r}, nil
+		}
+	}
+	return l, nil
+}
+
+func (p *condParser) parsePrimary() (condNode, error) {
+	if p.acceptSymbol("(") {
+		x, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.acceptSymbol(")") {
+			return nil, fmt.Errorf("invalid condition. expected ) but got %s", p.peek().text)
+		}
+		return x, nil
+	}
+	if p.done() {
+		return nil, fmt.Errorf("invalid condition. unexpected end of expression")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	switch t.kind {
+	case condTokenNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid condition. invalid number %s", t.text)
+		}
+		return &literalNode{value: f}, nil
+	case condTokenString:
+		return &literalNode{value: t.text}, nil
+	case condTokenIdent:
+		switch t.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null":
+			return &literalNode{value: nil}, nil
+		case "payload", "outputs":
+			return p.parsePath(t.text)
+		}
+		return nil, fmt.Errorf("invalid condition. unknown identifier %s. use payload or outputs", t.text)
+	}
+	return nil, fmt.Errorf("invalid condition. unexpected %s", t.text)
+}
+
+func (p *condParser) parsePath(root string) (condNode, error) {
+	keys := []interface{}{root}
+	for {
+		switch {
+		case p.acceptSymbol("."):
+			t := p.peek()
+			if p.done() || t.kind != condTokenIdent {
+				return nil, fmt.Errorf("invalid condition. expected key after . but got %s", t.text)
+			}
+			p.pos++
+			keys = append(keys, t.text)
+		case p.acceptSymbol("["):
+			t := p.peek()
+			if p.done() {
+				return nil, fmt.Errorf("invalid condition. unexpected end of expression")
+			}
+			p.pos++
+			switch t.kind {
+			case condTokenNumber:
+				i, err := strconv.Atoi(t.text)
+				if err != nil {
+					return nil, fmt.Errorf("invalid condition. invalid index %s", t.text)
+				}
+				keys = append(keys, i)
+			case condTokenString:
+				keys = append(keys, t.text)
+			default:
+				return nil, fmt.Errorf("invalid condition. invalid index %s", t.text)
+			}
+			if !p.acceptSymbol("]") {
+				return nil, fmt.Errorf("invalid condition. expected ] but got %s", p.peek().text)
+			}
+		default:
+			return &pathNode{keys: keys}, nil
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package domain
+
+import (
+	"testing"
+)
+
+func TestParseCondition(t *testing.T) {
+	p := &Payload{
+		Body: []byte(`{"temperature": 35, "status": "ok", "items": [{"name": "a"}, {"name": "b"}], "tags": ["x"], "empty": ""}`),
+		Outputs: map[string][]byte{
+			"sensor": []byte(`{"status": "ok", "level": 3}`),
+			"raw":    []byte(`not json`),
+		},
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{expr: `payload.temperature > 30`, want: true},
+		{expr: `payload.temperature >= 35`, want: true},
+		{expr: `payload.temperature < 35`, want: false},
+		{expr: `payload.temperature <= -1`, want: false},
+		{expr: `payload.temperature == 35.0`, want: true},
+		{expr: `payload.status == "ok"`, want: true},
+		{expr: `payload.status != 'ok'`, want: false},
+		{expr: `payload.status < "pk"`, want: true},
+		{expr: `payload.items[1].name == "b"`, want: true},
+		{expr: `payload["status"] == "ok"`, want: true},
+		{expr: `payload.items[5].name == null`, want: true},
+		{expr: `payload.missing`, want: false},
+		{expr: `payload.missing > 1`, want: false},
+		{expr: `payload.empty`, want: false},
+		{expr: `payload.tags`, want: true},
+		{expr: `!payload.missing`, want: true},
+		{expr: `!!payload.status`, want: true},
+		{expr: `outputs.sensor.status == "ok" && outputs.sensor.level > 2`, want: true},
+		{expr: `outputs.sensor.level > 5 || payload.temperature > 30`, want: true},
+		{expr: `outputs.raw == "not json"`, want: true},
+		{expr: `payload.temperature > 30 && (outputs.sensor.level > 5 || payload.status == "ok")`, want: true},
+		{expr: `!(payload.temperature > 30)`, want: false},
+		{expr: `true && false`, want: false},
+		{expr: `payload.items == payload.items`, want: true},
+		{expr: `"a\"b" == 'a"b'`, want: true},
+		{expr: `  payload.temperature>30  `, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			c, err := ParseCondition(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseCondition(%q): %v", tt.expr, err)
+			}
+			got, err := c.Eval(p)
+			if err != nil {
+				t.Fatalf("Eval(%q): %v", tt.expr, err)
+			}
+			if got != tt.want {
+				t.Errorf("Eval(%q): want %v, got %v", tt.expr, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseConditionInvalid(t *testing.T) {
+	tests := []string{
+		``,
+		`payload.`,
+		`payload.temperature >`,
+		`payload.temperature > 30 &&`,
+		`(payload.temperature > 30`,
+		`payload.temperature > 30)`,
+		`payload[0`,
+		`payload[]`,
+		`payload[true]`,
+		`payload.items[1.5]`,
+		`temperature > 30`,
+		`payload.status == "ok`,
+		`payload.temperature = 30`,
+		`payload.temperature & 30`,
+		`payload.temperature # 30`,
+		`1.2.3 > 1`,
+		`payload payload`,
+	}
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := ParseCondition(expr); err == nil {
+				t.Errorf("ParseCondition(%q): want an error, got nil", expr)
+			}
+		})
+	}
+}
+
+func TestConditionEvalError(t *testing.T) {
+	p := &Payload{Body: []byte(`{"temperature": 35, "status": "ok", "ok": true}`)}
+	tests := []string{
+		`payload.temperature > "30"`,
+		`payload.status < 1`,
+		`payload.ok > false`,
+	}
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			c, err := ParseCondition(expr)
+			if err != nil {
+				t.Fatalf("ParseCondition(%q): %v", expr, err)
+			}
+			if _, err := c.Eval(p); err == nil {
+				t.Errorf("Eval(%q): want an error, got nil", expr)
+			}
+		})
+	}
+}
+
+func TestStepShouldRun(t *testing.T) {
+	p := &Payload{Body: []byte(`{"temperature": 20}`)}
+	tests := []struct {
+		when    string
+		want    bool
+		wantErr bool
+	}{
+		{when: ``, want: true},
+		{when: `payload.temperature > 30`, want: false},
+		{when: `payload.temperature > 10`, want: true},
+		{when: `payload.temperature >`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.when, func(t *testing.T) {
+			s := &Step{When: tt.when}
+			got, err := s.ShouldRun(p)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ShouldRun(%q): want an error, got nil", tt.when)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ShouldRun(%q): %v", tt.when, err)
+			}
+			if got != tt.want {
+				t.Errorf("ShouldRun(%q): want %v, got %v", tt.when, tt.want, got)
+			}
+		})
+	}
+}
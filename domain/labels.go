@@ -0,0 +1,205 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ワーカーのラベル. 値の無いラベル(ex: ssd)は空文字
+type Labels map[string]string
+
+// "key=value"か"key"のリストから作る. 空の要素は無視する
+func ParseLabels(ss []string) Labels {
+	l := make(Labels, len(ss))
+	for _, s := range ss {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		kv := strings.SplitN(s, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) == 1 {
+			l[key] = ""
+			continue
+		}
+		l[key] = strings.TrimSpace(kv[1])
+	}
+	return l
+}
+
+type selectorOperator string
+
+const (
+	selectorExists    selectorOperator = "exists"
+	selectorNotExists selectorOperator = "!"
+	selectorEquals    selectorOperator = "="
+	selectorNotEquals selectorOperator = "!="
+	selectorIn        selectorOperator = "in"
+	selectorNotIn     selectorOperator = "notin"
+)
+
+// ステップを実行できるワーカーのラベルの条件. 全ての条件を満たすワーカーだけが選ばれる
+// ex: log-db, zone in (tokyo, osaka), tier notin (test), !gpu, env=prod
+type LabelSelector struct {
+	requirements []*labelRequirement
+}
+
+type labelRequirement struct {
+	key      string
+	operator selectorOperator
+	values   []string
+}
+
+func ParseLabelSelector(expr string) (*LabelSelector, error) {
+	s := &LabelSelector{}
+	terms, err := splitSelectorTerms(expr)
+	if err != nil {
+		return nil, err
+	}
+	for _, term := range terms {
+		r, err := parseLabelRequirement(term)
+		if err != nil {
+			return nil, err
+		}
+		s.requirements = append(s.requirements, r)
+	}
+	return s, nil
+}
+
+// 括弧の中以外のカンマで区切る
+func splitSelectorTerms(expr string) ([]string, error) {
+	terms := make([]string, 0)
+	depth, start := 0, 0
+	for i, r := range expr {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("invalid selector. unexpected ) in %s", expr)
+			}
+		case ',':
+			if depth == 0 {
+				terms = append(terms, expr[start:i])
+				start = i + 1
+			}
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("invalid selector. unclosed ( in %s", expr)
+	}
+	terms = append(terms, expr[start:])
+	nonEmpty := make([]string, 0, len(terms))
+	for _, t := range terms {
+		if t = strings.TrimSpace(t); t != "" {
+			nonEmpty = append(nonEmpty, t)
+		}
+	}
+	return nonEmpty, nil
+}
+
+func parseLabelRequirement(term string) (*labelRequirement, error) {
+	if strings.HasPrefix(term, "!") && !strings.Contains(term, "=") {
+		return newLabelRequirement(strings.TrimPrefix(term, "!"), selectorNotExists, nil)
+	}
+	for _, op := range []selectorOperator{selectorNotIn, selectorIn} {
+		sep := " " + string(op) + " "
+		i := strings.Index(term, sep)
+		if i < 0 {
+			continue
+		}
+		values, err := parseSelectorValues(strings.TrimSpace(term[i+len(sep):]))
+		if err != nil {
+			return nil, err
+		}
+		return newLabelRequirement(term[:i], op, values)
+	}
+	if i := strings.Index(term, "!="); i >= 0 {
+		return newLabelRequirement(term[:i], selectorNotEquals, []string{strings.TrimSpace(term[i+2:])})
+	}
+	if i := strings.Index(term, "="); i >= 0 {
+		value := strings.TrimPrefix(term[i+1:], "=")
+		return newLabelRequirement(term[:i], selectorEquals, []string{strings.TrimSpace(value)})
+	}
+	return newLabelRequirement(term, selectorExists, nil)
+}
+
+// (a, b, c)
+func parseSelectorValues(s string) ([]string, error) {
+	if !strings.HasPrefix(s, "(") || !strings.HasSuffix(s, ")") {
+		return nil, fmt.Errorf("invalid selector. values must be in parentheses: %s", s)
+	}
+	values := make([]string, 0)
+	for _, v := range strings.Split(s[1:len(s)-1], ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return nil, fmt.Errorf("invalid selector. no values in %s", s)
+	}
+	return values, nil
+}
+
+func newLabelRequirement(key string, op selectorOperator, values []string) (*labelRequirement, error) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return nil, fmt.Errorf("invalid selector. label key is empty")
+	}
+	if strings.ContainsAny(key, " !=()") {
+		return nil, fmt.Errorf("invalid selector. invalid label key %s", key)
+	}
+	return &labelRequirement{
+		key:      key,
+		operator: op,
+		values:   values,
+	}, nil
+}
+
+func (s *LabelSelector) Matches(l Labels) bool {
+	for _, r := range s.requirements {
+		if !r.matches(l) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *labelRequirement) matches(l Labels) bool {
+	v, ok := l[r.key]
+	switch r.operator {
+	case selectorExists:
+		return ok
+	case selectorNotExists:
+		return !ok
+	case selectorEquals:
+		return ok && v == r.values[0]
+	case selectorNotEquals:
+		return !ok || v != r.values[0]
+	case selectorIn:
+		return ok && containsString(r.values, v)
+	case selectorNotIn:
+		return !ok || !containsString(r.values, v)
+	}
+	return false
+}
+
+func containsString(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// labelsとselectorを合わせた条件. labelsは全て持っている必要がある
+func (s *Step) LabelSelector() (*LabelSelector, error) {
+	exprs := make([]string, 0, len(s.Labels)+1)
+	exprs = append(exprs, s.Labels...)
+	if s.Selector != "" {
+		exprs = append(exprs, s.Selector)
+	}
+	return ParseLabelSelector(strings.Join(exprs, ","))
+}
@@ -0,0 +1,111 @@
+package domain
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLabels(t *testing.T) {
+	got := ParseLabels([]string{"zone=tokyo", " ssd ", "", "env = prod", "path=a=b"})
+	want := Labels{
+		"zone": "tokyo",
+		"ssd":  "",
+		"env":  "prod",
+		"path": "a=b",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseLabels: want %v, got %v", want, got)
+	}
+}
+
+func TestParseLabelSelector(t *testing.T) {
+	labels := Labels{
+		"zone": "tokyo",
+		"env":  "prod",
+		"ssd":  "",
+	}
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{expr: ``, want: true},
+		{expr: `ssd`, want: true},
+		{expr: `gpu`, want: false},
+		{expr: `!gpu`, want: true},
+		{expr: `!ssd`, want: false},
+		{expr: `env=prod`, want: true},
+		{expr: `env==prod`, want: true},
+		{expr: `env = prod`, want: true},
+		{expr: `env=test`, want: false},
+		{expr: `env!=test`, want: true},
+		{expr: `gpu!=yes`, want: true},
+		{expr: `env!=prod`, want: false},
+		{expr: `zone in (tokyo, osaka)`, want: true},
+		{expr: `zone in (osaka)`, want: false},
+		{expr: `gpu in (yes)`, want: false},
+		{expr: `zone notin (osaka, nagoya)`, want: true},
+		{expr: `zone notin (tokyo)`, want: false},
+		{expr: `gpu notin (yes)`, want: true},
+		{expr: `ssd, zone in (tokyo, osaka), tier notin (test), !gpu, env=prod`, want: true},
+		{expr: `ssd, env=test`, want: false},
+		{expr: `ssd,,env=prod,`, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			s, err := ParseLabelSelector(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseLabelSelector(%q): %v", tt.expr, err)
+			}
+			if got := s.Matches(labels); got != tt.want {
+				t.Errorf("Matches(%q): want %v, got %v", tt.expr, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseLabelSelectorInvalid(t *testing.T) {
+	tests := []string{
+		`zone in (tokyo`,
+		`zone in tokyo)`,
+		`zone in tokyo`,
+		`zone in ()`,
+		`zone in ( , )`,
+		`=prod`,
+		`!=prod`,
+		`!`,
+		`zone tokyo`,
+		`env=prod, (ssd`,
+	}
+	for _, expr := range tests {
+		t.Run(expr, func(t *testing.T) {
+			if _, err := ParseLabelSelector(expr); err == nil {
+				t.Errorf("ParseLabelSelector(%q): want an error, got nil", expr)
+			}
+		})
+	}
+}
+
+func TestStepLabelSelector(t *testing.T) {
+	s := &Step{
+		Labels:   []string{"ssd", "zone=tokyo"},
+		Selector: "!gpu",
+	}
+	sel, err := s.LabelSelector()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		labels Labels
+		want   bool
+	}{
+		{labels: Labels{"ssd": "", "zone": "tokyo"}, want: true},
+		{labels: Labels{"ssd": "", "zone": "osaka"}, want: false},
+		{labels: Labels{"zone": "tokyo"}, want: false},
+		{labels: Labels{"ssd": "", "zone": "tokyo", "gpu": ""}, want: false},
+	}
+	for _, tt := range tests {
+		if got := sel.Matches(tt.labels); got != tt.want {
+			t.Errorf("Matches(%v): want %v, got %v", tt.labels, tt.want, got)
+		}
+	}
+}
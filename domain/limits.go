@@ -0,0 +1,89 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ジョブが使えるリソースの上限
+// memoryは 128Mi, 1Gi, 512M のようなバイト数、cpuは 500m (0.5コア), 2 のようなコア数
+type Limits struct {
+	Memory string `yaml:"memory" json:"memory"`
+	CPU    string `yaml:"cpu" json:"cpu"`
+}
+
+var memoryUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"Ki", 1 << 10},
+	{"Mi", 1 << 20},
+	{"Gi", 1 << 30},
+	{"Ti", 1 << 40},
+	{"k", 1000},
+	{"K", 1000},
+	{"M", 1000 * 1000},
+	{"G", 1000 * 1000 * 1000},
+	{"T", 1000 * 1000 * 1000 * 1000},
+}
+
+// メモリの上限をバイト数で返す. 指定が無ければ0
+func (l *Limits) MemoryBytes() (int64, error) {
+	if l == nil || l.Memory == "" {
+		return 0, nil
+	}
+	return ParseMemory(l.Memory)
+}
+
+// CPUの上限をミリコアで返す. 指定が無ければ0
+func (l *Limits) MilliCPU() (int64, error) {
+	if l == nil || l.CPU == "" {
+		return 0, nil
+	}
+	return ParseCPU(l.CPU)
+}
+
+func ParseMemory(s string) (int64, error) {
+	num, unit := s, int64(1)
+	for _, u := range memoryUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			num, unit = strings.TrimSuffix(s, u.suffix), u.bytes
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil || f <= 0 {
+		return 0, fmt.Errorf("invalid memory %s", s)
+	}
+	return int64(f * float64(unit)), nil
+}
+
+func ParseCPU(s string) (int64, error) {
+	if strings.HasSuffix(s, "m") {
+		m, err := strconv.ParseInt(strings.TrimSuffix(s, "m"), 10, 64)
+		if err != nil || m <= 0 {
+			return 0, fmt.Errorf("invalid cpu %s", s)
+		}
+		return m, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f <= 0 {
+		return 0, fmt.Errorf("invalid cpu %s", s)
+	}
+	milli := int64(f * 1000)
+	if milli == 0 {
+		return 0, errors.New("cpu must be at least 1m")
+	}
+	return milli, nil
+}
+
+func (l *Limits) validate(errs *ValidationErrors, path string) {
+	if _, err := l.MemoryBytes(); err != nil {
+		errs.add(path+".memory", "%s", err.Error())
+	}
+	if _, err := l.MilliCPU(); err != nil {
+		errs.add(path+".cpu", "%s", err.Error())
+	}
+}
@@ -0,0 +1,118 @@
+package domain
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+var (
+	ErrMissingInput = errors.New("input is not produced yet")
+)
+
+// ステップ間で受け渡すデータ
+type Payload struct {
+	// 直前のステップ(またはtrigger)の出力
+	Body []byte `json:"body"`
+	// ここまでに出力された名前付きの出力. k=出力名
+	Outputs map[string][]byte `json:"outputs"`
+	// 親ステップがwhenで実行されなかった時にtrue. 合流するステップの待ち合わせのために送る
+	Skipped bool `json:"skipped,omitempty"`
+	// 親ステップが失敗して、後続のステップが実行されない時にtrue. 合流するステップの待ち合わせを捨てるために送る
+	Failed bool `json:"failed,omitempty"`
+}
+
+// 実行されなかったステップから後続の合流するステップへ送るデータ
+func SkippedPayload() *Payload {
+	p := NewPayload(nil)
+	p.Skipped = true
+	return p
+}
+
+// 失敗したステップから後続の合流するステップへ送るデータ
+func FailedPayload() *Payload {
+	p := NewPayload(nil)
+	p.Failed = true
+	return p
+}
+
+func NewPayload(body []byte) *Payload {
+	return &Payload{
+		Body:    body,
+		Outputs: make(map[string][]byte),
+	}
+}
+
+// bodyを次のステップへ渡す. outputNameがあれば名前付きの出力としても残す
+func (p *Payload) Next(outputName string, body []byte) *Payload {
+	np := NewPayload(body)
+	for k, v := range p.Outputs {
+		np.Outputs[k] = v
+	}
+	if outputName != "" {
+		np.Outputs[outputName] = body
+	}
+	return np
+}
+
+// 名前付きの出力はまとめ、bodyは名前をキーにしたJSONにする
+func MergePayloads(names []string, ps []*Payload) (*Payload, error) {
+	bodies := make(map[string][]byte, len(ps))
+	merged := NewPayload(nil)
+	for i, p := range ps {
+		if p == nil {
+			continue
+		}
+		bodies[names[i]] = p.Body
+		for k, v := range p.Outputs {
+			merged.Outputs[k] = v
+		}
+	}
+	body, err := marshalNamedBodies(bodies)
+	if err != nil {
+		return nil, err
+	}
+	merged.Body = body
+	return merged, nil
+}
+
+// ステップのジョブに渡すデータ
+// inputsがなければ直前の出力、1つならその出力、複数なら出力名をキーにしたJSON
+func (s *Step) Input(p *Payload) ([]byte, error) {
+	names := s.InputNames()
+	switch len(names) {
+	case 0:
+		return p.Body, nil
+	case 1:
+		body, ok := p.Outputs[names[0]]
+		if !ok {
+			return nil, ErrMissingInput
+		}
+		return body, nil
+	}
+	bodies := make(map[string][]byte, len(names))
+	for _, name := range names {
+		body, ok := p.Outputs[name]
+		if !ok {
+			return nil, ErrMissingInput
+		}
+		bodies[name] = body
+	}
+	return marshalNamedBodies(bodies)
+}
+
+// JSONとして読めないものは文字列として扱う
+func marshalNamedBodies(bodies map[string][]byte) ([]byte, error) {
+	named := make(map[string]json.RawMessage, len(bodies))
+	for name, body := range bodies {
+		if json.Valid(body) {
+			named[name] = body
+			continue
+		}
+		b, err := json.Marshal(string(body))
+		if err != nil {
+			return nil, err
+		}
+		named[name] = b
+	}
+	return json.Marshal(named)
+}
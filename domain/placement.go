@@ -0,0 +1,109 @@
+package domain
+
+import (
+	"time"
+)
+
+// ステップのplaceにワーカーが無い時の配置の方針
+type PlacementMode string
+
+const (
+	// placeのワーカーにだけ配置する. 無ければ失敗
+	PlacementStrict PlacementMode = "strict"
+	// placesの順に試して、最初にワーカーが見つかったplaceへ配置する
+	PlacementFallback PlacementMode = "fallback"
+	// placeのワーカーが見つかるまでtimeoutまで待つ
+	PlacementQueue PlacementMode = "queue"
+)
+
+const defaultPlacementQueueTimeout = time.Minute
+
+// placementが無いステップは、cloudのステップだけcloudのワーカーに配置し、それ以外はどのワーカーにも配置する
+type PlacementPolicy struct {
+	Mode PlacementMode `yaml:"mode" json:"mode"`
+	// fallbackで試すplaceの順番 (ex: [edge, cloud])
+	Places []Place `yaml:"places" json:"places"`
+	// queueで待つ時間の上限 (ex: 30s, 5m). 空なら1m
+	Timeout string `yaml:"timeout" json:"timeout"`
+}
+
+// placement: strict のようにモードだけ、placement: [edge, cloud] のようにfallbackのplacesだけでも書ける
+func (p *PlacementPolicy) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var mode string
+	if err := unmarshal(&mode); err == nil {
+		*p = PlacementPolicy{Mode: PlacementMode(mode)}
+		return nil
+	}
+	var places []Place
+	if err := unmarshal(&places); err == nil {
+		*p = PlacementPolicy{Mode: PlacementFallback, Places: places}
+		return nil
+	}
+	type plain PlacementPolicy
+	var pp plain
+	if err := unmarshal(&pp); err != nil {
+		return err
+	}
+	*p = PlacementPolicy(pp)
+	return nil
+}
+
+// queue以外は待たないので0
+func (p *PlacementPolicy) QueueTimeout() time.Duration {
+	if p == nil || p.Mode != PlacementQueue {
+		return 0
+	}
+	d, err := time.ParseDuration(p.Timeout)
+	if err != nil {
+		return defaultPlacementQueueTimeout
+	}
+	return d
+}
+
+// 配置を試すplaceの順番. placementが無ければ空文字だけで、今まで通りステップのplaceに従う
+func (s *Step) PlacementPlaces() []Place {
+	if s.Placement == nil {
+		return []Place{""}
+	}
+	if s.Placement.Mode == PlacementFallback {
+		return s.Placement.Places
+	}
+	return []Place{s.Place}
+}
+
+func (p *PlacementPolicy) validate(errs *ValidationErrors, path string, place Place) {
+	switch p.Mode {
+	case PlacementStrict, PlacementQueue:
+		if place == "" {
+			errs.add(path, "place is required for placement %s", p.Mode)
+		}
+		if len(p.Places) != 0 {
+			errs.add(path+".places", "places is only for placement fallback")
+		}
+	case PlacementFallback:
+		if len(p.Places) == 0 {
+			errs.add(path+".places", "places is required for placement fallback")
+		}
+		for _, pl := range p.Places {
+			switch pl {
+			case PlaceEdge, PlaceCloud, PlaceAny, PlaceDevice:
+			default:
+				errs.add(path+".places", "unknown place %s", pl)
+			}
+		}
+	case "":
+		errs.add(path+".mode", "mode is required")
+	default:
+		errs.add(path+".mode", "unknown placement mode %s", p.Mode)
+	}
+	if p.Timeout == "" {
+		return
+	}
+	if p.Mode != PlacementQueue {
+		errs.add(path+".timeout", "timeout is only for placement queue")
+		return
+	}
+	if d, err := time.ParseDuration(p.Timeout); err != nil || d <= 0 {
+		errs.add(path+".timeout", "invalid timeout %s", p.Timeout)
+	}
+}
@@ -0,0 +1,104 @@
+package domain
+
+import (
+	"fmt"
+	"time"
+)
+
+// リトライする失敗の種類
+type RetryReason string
+
+const (
+	// ジョブが/failを呼んだ時
+	RetryOnFail RetryReason = "fail"
+	// 次のステップのワーカーへデータを渡せなかった時
+	RetryOnTransfer RetryReason = "transfer"
+	// ジョブがタイムアウトした時
+	RetryOnTimeout RetryReason = "timeout"
+)
+
+const (
+	defaultRetryBackoff    = time.Second
+	defaultRetryMultiplier = 2
+)
+
+// ステップのリトライ設定
+// 全てのリトライに失敗してから、failureのステップへ進む
+type RetryPolicy struct {
+	// 最初の実行を含めた最大の実行回数
+	MaxAttempts int `yaml:"maxAttempts" json:"max_attempts"`
+	// 最初のリトライまでの待ち時間 (ex: 500ms, 2s). 空なら1s
+	Backoff string `yaml:"backoff" json:"backoff"`
+	// リトライ毎に待ち時間を何倍にするか. 0なら2
+	Multiplier float64 `yaml:"multiplier" json:"multiplier"`
+	// 待ち時間の上限. 空なら上限なし
+	MaxBackoff string `yaml:"maxBackoff" json:"max_backoff"`
+	// リトライする失敗の種類. 空なら全て
+	On []RetryReason `yaml:"on" json:"on"`
+}
+
+// attempt回目の実行に失敗した後、もう一度実行するかどうか
+func (r *RetryPolicy) ShouldRetry(reason RetryReason, attempt int) bool {
+	if r == nil || attempt >= r.MaxAttempts {
+		return false
+	}
+	if len(r.On) == 0 {
+		return true
+	}
+	for _, on := range r.On {
+		if on == reason {
+			return true
+		}
+	}
+	return false
+}
+
+// attempt回目の実行に失敗した後の待ち時間
+func (r *RetryPolicy) BackoffAfter(attempt int) time.Duration {
+	backoff := defaultRetryBackoff
+	if d, err := time.ParseDuration(r.Backoff); err == nil {
+		backoff = d
+	}
+	multiplier := r.Multiplier
+	if multiplier == 0 {
+		multiplier = defaultRetryMultiplier
+	}
+	maxBackoff, err := time.ParseDuration(r.MaxBackoff)
+	if err != nil {
+		maxBackoff = 0
+	}
+	for i := 1; i < attempt; i++ {
+		backoff = time.Duration(float64(backoff) * multiplier)
+		if maxBackoff > 0 && backoff >= maxBackoff {
+			break
+		}
+	}
+	if maxBackoff > 0 && backoff > maxBackoff {
+		return maxBackoff
+	}
+	return backoff
+}
+
+func (r *RetryPolicy) validate(errs *ValidationErrors, path string) {
+	if r.MaxAttempts < 1 {
+		errs.add(path+".maxAttempts", "maxAttempts must be at least 1")
+	}
+	for field, v := range map[string]string{"backoff": r.Backoff, "maxBackoff": r.MaxBackoff} {
+		if v == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(v); err != nil || d < 0 {
+			errs.add(fmt.Sprintf("%s.%s", path, field), "invalid duration %s", v)
+		}
+	}
+	if r.Multiplier != 0 && r.Multiplier < 1 {
+		errs.add(path+".multiplier", "multiplier must be at least 1")
+	}
+	for _, on := range r.On {
+		switch on {
+		case RetryOnFail, RetryOnTransfer, RetryOnTimeout:
+		default:
+			errs.add(path+".on", "unknown retry reason %s", on)
+		}
+	}
+}
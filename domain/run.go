@@ -0,0 +1,207 @@
+package domain
+
+import (
+	"time"
+)
+
+// ワークフローの1回の実行
+// triggerから開始されるたびに作られ、run IDはワーカー間でステップと一緒に受け渡される
+type Run struct {
+	ID              string    `json:"id"`
+	WorkflowID      string    `json:"workflow_id"`
+	WorkflowVersion int       `json:"workflow_version"`
+	Status          RunStatus `json:"status"`
+	StartedAt       time.Time `json:"started_at"`
+	// 実行中はゼロ値
+	FinishedAt time.Time  `json:"finished_at"`
+	Steps      []*StepRun `json:"steps"`
+	// placementの方針で、ステップのplace以外に配置したか、ワーカーを待ったステップ
+	Placements []*StepPlacement `json:"placements,omitempty"`
+}
+
+type RunStatus string
+
+const (
+	RunStatusRunning   RunStatus = "running"
+	RunStatusSucceeded RunStatus = "succeeded"
+	RunStatusFailed    RunStatus = "failed"
+)
+
+// runの中でのステップの1回の実行. リトライした時は実行毎に残す
+type StepRun struct {
+	StepID     string        `json:"step_id"`
+	StepName   string        `json:"step_name"`
+	WorkerID   string        `json:"worker_id"`
+	Attempt    int           `json:"attempt"`
+	Status     StepRunStatus `json:"status"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+	Duration   time.Duration `json:"duration"`
+	Message    string        `json:"message"`
+	// リトライせずに失敗が確定した
+	Final bool `json:"final"`
+}
+
+// placementのfallbackかqueueで配置した記録
+type StepPlacement struct {
+	StepID   string `json:"step_id"`
+	StepName string `json:"step_name"`
+	WorkerID string `json:"worker_id"`
+	// 配置したplace
+	Place Place `json:"place"`
+	// placesの最初以外のplaceに配置した
+	Fallback bool `json:"fallback"`
+	// ワーカーが見つかるまで待った時間
+	Waited time.Duration `json:"waited"`
+	Time   time.Time     `json:"time"`
+}
+
+type StepRunStatus string
+
+const (
+	StepRunStatusRunning   StepRunStatus = "running"
+	StepRunStatusSucceeded StepRunStatus = "succeeded"
+	StepRunStatusFailed    StepRunStatus = "failed"
+	// whenが偽だったので実行しなかった
+	StepRunStatusSkipped StepRunStatus = "skipped"
+)
+
+func (s StepRunStatus) isFinished() bool {
+	return s == StepRunStatusSucceeded || s == StepRunStatusFailed || s == StepRunStatusSkipped
+}
+
+// ワーカーからmasterへ報告されるrunの出来事
+type RunEvent struct {
+	Type            RunEventType `json:"type"`
+	WorkflowID      string       `json:"workflow_id"`
+	WorkflowVersion int          `json:"workflow_version"`
+	StepID          string       `json:"step_id"`
+	WorkerID        string       `json:"worker_id"`
+	Attempt         int          `json:"attempt"`
+	// 失敗の理由など
+	Message string `json:"message"`
+	// 失敗した時に、もうリトライしないならtrue
+	Final bool      `json:"final"`
+	Time  time.Time `json:"time"`
+}
+
+type RunEventType string
+
+const (
+	RunEventStarted       RunEventType = "run_started"
+	RunEventStepStarted   RunEventType = "step_started"
+	RunEventStepSucceeded RunEventType = "step_succeeded"
+	RunEventStepFailed    RunEventType = "step_failed"
+	RunEventStepSkipped   RunEventType = "step_skipped"
+)
+
+func NewRun(id, workflowID string, workflowVersion int, startedAt time.Time) *Run {
+	return &Run{
+		ID:              id,
+		WorkflowID:      workflowID,
+		WorkflowVersion: workflowVersion,
+		Status:          RunStatusRunning,
+		StartedAt:       startedAt,
+		Steps:           make([]*StepRun, 0),
+	}
+}
+
+// イベントは順不同で届くことがあるので、終了したステップの実行は開始のイベントで上書きしない
+func (r *Run) Apply(e *RunEvent) {
+	if e.Type == RunEventStarted {
+		if r.StartedAt.IsZero() || e.Time.Before(r.StartedAt) {
+			r.StartedAt = e.Time
+		}
+		return
+	}
+	sr := r.stepRun(e.StepID, e.Attempt)
+	if e.WorkerID != "" {
+		sr.WorkerID = e.WorkerID
+	}
+	switch e.Type {
+	case RunEventStepStarted:
+		sr.StartedAt = e.Time
+		if sr.Status == "" {
+			sr.Status = StepRunStatusRunning
+		}
+	case RunEventStepSucceeded:
+		sr.Status = StepRunStatusSucceeded
+		sr.FinishedAt = e.Time
+	case RunEventStepFailed:
+		sr.Status = StepRunStatusFailed
+		sr.FinishedAt = e.Time
+		sr.Message = e.Message
+		sr.Final = e.Final
+	case RunEventStepSkipped:
+		sr.Status = StepRunStatusSkipped
+		sr.FinishedAt = e.Time
+	}
+	if !sr.StartedAt.IsZero() && !sr.FinishedAt.IsZero() {
+		sr.Duration = sr.FinishedAt.Sub(sr.StartedAt)
+	}
+}
+
+func (r *Run) stepRun(stepID string, attempt int) *StepRun {
+	for _, sr := range r.Steps {
+		if sr.StepID == stepID && sr.Attempt == attempt {
+			return sr
+		}
+	}
+	sr := &StepRun{
+		StepID:  stepID,
+		Attempt: attempt,
+	}
+	r.Steps = append(r.Steps, sr)
+	return sr
+}
+
+// ステップの実行状況からrunの状態を決める
+// 実行中のステップが無く、リトライを使い切って失敗したステップがあれば失敗
+// 全てのステップが成功か実行されなかったら成功
+func (r *Run) UpdateStatus(wf *Workflow) {
+	finished := make(map[string]bool, len(wf.Steps))
+	running, failed := false, false
+	for _, sr := range r.Steps {
+		if s := wf.StepByID(sr.StepID); s != nil {
+			sr.StepName = s.Name
+		}
+		if !sr.Status.isFinished() {
+			running = true
+			continue
+		}
+		if sr.Status == StepRunStatusFailed {
+			failed = failed || sr.Final
+			continue
+		}
+		finished[sr.StepID] = true
+	}
+	last := r.FinishedAt
+	for _, sr := range r.Steps {
+		if sr.FinishedAt.After(last) {
+			last = sr.FinishedAt
+		}
+	}
+	switch {
+	case running:
+		r.Status = RunStatusRunning
+		r.FinishedAt = time.Time{}
+	case failed:
+		r.Status = RunStatusFailed
+		r.FinishedAt = last
+	case allStepsFinished(wf, finished):
+		r.Status = RunStatusSucceeded
+		r.FinishedAt = last
+	default:
+		r.Status = RunStatusRunning
+		r.FinishedAt = time.Time{}
+	}
+}
+
+func allStepsFinished(wf *Workflow, finished map[string]bool) bool {
+	for _, s := range wf.Steps {
+		if !finished[s.ID] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,33 @@
+package domain
+
+import (
+	"errors"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// cronトリガーの次の実行時刻を決める
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// cron式(ex: */5 * * * *, @hourly)か、間隔(ex: 10s, 5m)をパースする
+func ParseSchedule(spec string) (Schedule, error) {
+	if d, err := time.ParseDuration(spec); err == nil {
+		if d < time.Second {
+			return nil, errors.New("interval must be at least 1s")
+		}
+		return cron.Every(d), nil
+	}
+	return cron.ParseStandard(spec)
+}
+
+// fromより後、to以前の実行時刻をmax件まで返す
+func ListFireTimes(s Schedule, from, to time.Time, max int) []time.Time {
+	ts := make([]time.Time, 0)
+	for t := s.Next(from); !t.IsZero() && !t.After(to) && len(ts) < max; t = s.Next(t) {
+		ts = append(ts, t)
+	}
+	return ts
+}
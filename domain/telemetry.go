@@ -0,0 +1,123 @@
+package domain
+
+import (
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ステップの実行1回分の計測値. 次のステップへ渡す毎に1つ記録される
+type TelemetrySample struct {
+	WorkflowID      string `json:"workflow_id"`
+	WorkflowVersion int    `json:"workflow_version"`
+	RunID           string `json:"run_id"`
+	StepID          string `json:"step_id"`
+	StepName        string `json:"step_name"`
+	Attempt         int    `json:"attempt"`
+	FromWorkerID    string `json:"from_worker_id"`
+	// 次のステップを実行するワーカー. 最後のステップなら空
+	ToWorkerName string `json:"to_worker_name"`
+	// ジョブの実行時間
+	Runtime time.Duration `json:"runtime"`
+	// 次のステップのワーカーへデータを渡すのにかかった時間. 同じワーカーなら0
+	TransferTime time.Duration `json:"transfer_time"`
+	RAM          float64       `json:"ram"`
+	CPU          float64       `json:"cpu"`
+	RecordedAt   time.Time     `json:"recorded_at"`
+}
+
+// 最後のステップでなければ、次のステップへ渡した記録
+func (s *TelemetrySample) HasTransfer() bool {
+	return s.ToWorkerName != ""
+}
+
+type DurationPercentiles struct {
+	Count int           `json:"count"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P99   time.Duration `json:"p99"`
+	Max   time.Duration `json:"max"`
+}
+
+func NewDurationPercentiles(ds []time.Duration) DurationPercentiles {
+	if len(ds) == 0 {
+		return DurationPercentiles{}
+	}
+	sorted := make([]time.Duration, len(ds))
+	copy(sorted, ds)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return DurationPercentiles{
+		Count: len(sorted),
+		P50:   percentile(sorted, 50),
+		P90:   percentile(sorted, 90),
+		P99:   percentile(sorted, 99),
+		Max:   sorted[len(sorted)-1],
+	}
+}
+
+// nearest-rank法. sortedは昇順
+func percentile(sorted []time.Duration, p int) time.Duration {
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+type StepTelemetry struct {
+	StepName     string              `json:"step_name"`
+	Runtime      DurationPercentiles `json:"runtime"`
+	TransferTime DurationPercentiles `json:"transfer_time"`
+}
+
+type WorkflowTelemetry struct {
+	WorkflowName string           `json:"workflow_name"`
+	Steps        []*StepTelemetry `json:"steps"`
+}
+
+// ステップ毎にまとめる. 次のステップが複数あると同じ実行が何度も記録されるので、実行時間は実行毎に1回だけ数える
+// 並びはワークフローのステップ順で、今のワークフローに無いステップは後ろに名前順
+func SummarizeTelemetry(wf *Workflow, samples []*TelemetrySample) *WorkflowTelemetry {
+	runtimes := make(map[string][]time.Duration)
+	transfers := make(map[string][]time.Duration)
+	seen := make(map[string]bool)
+	for _, s := range samples {
+		key := s.RunID + "/" + s.StepID + "/" + strconv.Itoa(s.Attempt)
+		if !seen[key] {
+			seen[key] = true
+			runtimes[s.StepName] = append(runtimes[s.StepName], s.Runtime)
+		}
+		if s.HasTransfer() {
+			transfers[s.StepName] = append(transfers[s.StepName], s.TransferTime)
+		}
+	}
+	names := make([]string, 0, len(runtimes))
+	ordered := make(map[string]bool, len(wf.Steps))
+	for _, s := range wf.stepsWithFailures() {
+		if _, ok := runtimes[s.Name]; ok {
+			names = append(names, s.Name)
+			ordered[s.Name] = true
+		}
+	}
+	rest := make([]string, 0)
+	for name := range runtimes {
+		if !ordered[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	names = append(names, rest...)
+
+	t := &WorkflowTelemetry{
+		WorkflowName: wf.Name,
+		Steps:        make([]*StepTelemetry, 0, len(names)),
+	}
+	for _, name := range names {
+		t.Steps = append(t.Steps, &StepTelemetry{
+			StepName:     name,
+			Runtime:      NewDurationPercentiles(runtimes[name]),
+			TransferTime: NewDurationPercentiles(transfers[name]),
+		})
+	}
+	return t
+}
@@ -0,0 +1,337 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ワークフロー登録前の検証で見つかった問題
+// Pathは問題のある箇所 (ex: steps[aggregate-step].after)
+type ValidationError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Path, e.Message)
+}
+
+type ValidationErrors []*ValidationError
+
+func (es ValidationErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func (es *ValidationErrors) add(path, format string, args ...interface{}) {
+	*es = append(*es, &ValidationError{
+		Path:    path,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// ワーカーの実行環境
+type Platform struct {
+	Type ImageType `json:"type"`
+	Arch ArchType  `json:"arch"`
+}
+
+func (p *Platform) String() string {
+	return fmt.Sprintf("%s/%s", p.Type, p.Arch)
+}
+
+type OptionsValidate struct {
+	// 登録済みワーカーの実行環境. 空の時はイメージが実行可能かのチェックをしない (CLIでのオフライン検証など)
+	Platforms []*Platform
+}
+
+// 登録前にワークフローの問題を全て洗い出す。問題がなければnilを返す
+func (w *Workflow) Validate(opts *OptionsValidate) ValidationErrors {
+	if opts == nil {
+		opts = &OptionsValidate{}
+	}
+	var errs ValidationErrors
+	if w.Name == "" {
+		errs.add("name", "name is required")
+	}
+	w.validateTrigger(&errs)
+
+	jobs := make(map[string]*Job, len(w.Jobs))
+	for i, j := range w.Jobs {
+		path := elemPath("jobs", i, j.Name)
+		if j.Name == "" {
+			errs.add(path+".name", "name is required")
+		} else if _, ok := jobs[j.Name]; ok {
+			errs.add(path+".name", "duplicate job name %s", j.Name)
+		} else {
+			jobs[j.Name] = j
+		}
+		validateImages(&errs, path, j)
+		j.Limits.validate(&errs, path+".limits")
+	}
+
+	steps := make(map[string]*Step, len(w.Steps))
+	for i, s := range w.Steps {
+		path := elemPath("steps", i, s.Name)
+		if s.Name == "" {
+			errs.add(path+".name", "name is required")
+		} else if _, ok := steps[s.Name]; ok {
+			errs.add(path+".name", "duplicate step name %s", s.Name)
+		} else {
+			steps[s.Name] = s
+		}
+	}
+
+	for i, s := range w.Steps {
+		path := elemPath("steps", i, s.Name)
+		validateStep(&errs, path, s, jobs, opts)
+		afters := make(map[string]bool, len(s.After))
+		for _, after := range s.After {
+			switch {
+			case after == s.Name:
+				errs.add(path+".after", "step can not be after itself")
+			case afters[after]:
+				errs.add(path+".after", "duplicate after step name %s", after)
+			case steps[after] == nil:
+				errs.add(path+".after", "after step name is not found. %s", after)
+			}
+			afters[after] = true
+		}
+		if s.Failure != nil {
+			validateStep(&errs, path+".failure", s.Failure, jobs, opts)
+			// failure stepのfailureは実行されないので受け付けない
+			if s.Failure.Failure != nil {
+				errs.add(path+".failure.failure", "failure step can not have failure")
+			}
+		}
+	}
+
+	w.validateIO(&errs)
+
+	for _, cycle := range w.findCycles(steps) {
+		errs.add(fmt.Sprintf("steps[%s].after", cycle[0]), "cycle detected: %s", strings.Join(cycle, " -> "))
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+func (w *Workflow) validateTrigger(errs *ValidationErrors) {
+	if w.Trigger == nil {
+		errs.add("trigger", "trigger is required")
+		return
+	}
+	switch w.Trigger.Type {
+	case "":
+		errs.add("trigger.type", "type is required")
+	case TriggerTypeHTTP:
+		if w.Trigger.Path == "" {
+			errs.add("trigger.path", "path is required for http trigger")
+		}
+	case TriggerTypeCron:
+		if w.Trigger.Schedule == "" {
+			errs.add("trigger.schedule", "schedule is required for cron trigger")
+		} else if _, err := ParseSchedule(w.Trigger.Schedule); err != nil {
+			errs.add("trigger.schedule", "invalid schedule. %s", err.Error())
+		}
+		switch w.Trigger.MissedFire {
+		case "", MissedFireSkip, MissedFireOnce, MissedFireAll:
+		default:
+			errs.add("trigger.missedFire", "unknown missed fire policy %s", w.Trigger.MissedFire)
+		}
+	default:
+		errs.add("trigger.type", "unknown trigger type %s", w.Trigger.Type)
+	}
+	if w.Trigger.Sync {
+		if w.Trigger.Type != TriggerTypeHTTP {
+			errs.add("trigger.sync", "sync is only for http trigger")
+		}
+		if w.Trigger.Result == "" {
+			errs.add("trigger.result", "result is required for sync trigger")
+		}
+	}
+	if w.Trigger.Timeout != "" {
+		if d, err := time.ParseDuration(w.Trigger.Timeout); err != nil || d <= 0 {
+			errs.add("trigger.timeout", "invalid duration %s", w.Trigger.Timeout)
+		}
+	}
+}
+
+// 名前付きの入力には、それを出力するステップかtriggerが1つだけ必要
+func (w *Workflow) validateIO(errs *ValidationErrors) {
+	const triggerProducer = "trigger"
+	// k=出力名, v=出力するステップ名
+	producers := make(map[string]string, len(w.Steps)+1)
+	if w.Trigger != nil && w.Trigger.Output != "" {
+		producers[w.Trigger.Output] = triggerProducer
+	}
+	for i, s := range w.Steps {
+		output := s.outputName(w.jobOf(s))
+		if output == "" {
+			continue
+		}
+		if producer, ok := producers[output]; ok {
+			errs.add(elemPath("steps", i, s.Name)+".output", "output %s is already produced by %s. set a different output on the step", output, producer)
+			continue
+		}
+		producers[output] = s.Name
+	}
+	for i, s := range w.Steps {
+		path := elemPath("steps", i, s.Name) + ".inputs"
+		inputs := make(map[string]bool)
+		for _, input := range s.inputNames(w.jobOf(s)) {
+			producer, ok := producers[input]
+			switch {
+			case input == "":
+				errs.add(path, "input name is empty")
+			case inputs[input]:
+				errs.add(path, "duplicate input %s", input)
+			case !ok:
+				errs.add(path, "no step or trigger produces input %s", input)
+			case producer == s.Name:
+				errs.add(path, "step can not consume its own output %s", input)
+			}
+			inputs[input] = true
+		}
+	}
+	if w.Trigger == nil || w.Trigger.Result == "" {
+		return
+	}
+	if producer, ok := producers[w.Trigger.Result]; !ok || producer == triggerProducer {
+		errs.add("trigger.result", "no step produces output %s", w.Trigger.Result)
+	}
+}
+
+func validateImages(errs *ValidationErrors, path string, j *Job) {
+	if len(j.Images) == 0 {
+		errs.add(path+".images", "at least one image is required")
+	}
+	for i, img := range j.Images {
+		imgPath := fmt.Sprintf("%s.images[%d]", path, i)
+		if img.Type == "" {
+			errs.add(imgPath+".type", "type is required")
+		}
+		for _, t := range strings.Split(string(img.Type), ",") {
+			if t != "" && ImageType(t) != ImageTypeDocker && ImageType(t) != ImageTypeShell {
+				errs.add(imgPath+".type", "unknown image type %s", t)
+			}
+		}
+		if img.Arch == "" {
+			errs.add(imgPath+".arch", "arch is required")
+		}
+		if img.Image == "" {
+			errs.add(imgPath+".image", "image is required")
+		}
+	}
+}
+
+func validateStep(errs *ValidationErrors, path string, s *Step, jobs map[string]*Job, opts *OptionsValidate) {
+	if s.When != "" {
+		if _, err := ParseCondition(s.When); err != nil {
+			errs.add(path+".when", "%s", err.Error())
+		}
+	}
+	if s.Timeout != "" {
+		if d, err := time.ParseDuration(s.Timeout); err != nil || d <= 0 {
+			errs.add(path+".timeout", "invalid timeout %s", s.Timeout)
+		}
+	}
+	if s.Retry != nil {
+		s.Retry.validate(errs, path+".retry")
+	}
+	if _, err := s.LabelSelector(); err != nil {
+		errs.add(path+".selector", "%s", err.Error())
+	}
+	switch s.Place {
+	case "", PlaceEdge, PlaceCloud, PlaceAny, PlaceDevice:
+	default:
+		errs.add(path+".place", "unknown place %s", s.Place)
+	}
+	if s.Placement != nil {
+		s.Placement.validate(errs, path+".placement", s.Place)
+	}
+	if s.JobName == "" {
+		errs.add(path+".jobName", "jobName is required")
+		return
+	}
+	j, ok := jobs[s.JobName]
+	if !ok {
+		errs.add(path+".jobName", "could not find matched job name %s", s.JobName)
+		return
+	}
+	if len(opts.Platforms) == 0 || j.hasImageFor(opts.Platforms) {
+		return
+	}
+	platforms := make([]string, len(opts.Platforms))
+	for i, p := range opts.Platforms {
+		platforms[i] = p.String()
+	}
+	errs.add(path+".jobName", "job %s has no image for any registered worker (%s)", j.Name, strings.Join(platforms, ", "))
+}
+
+func (j *Job) hasImageFor(platforms []*Platform) bool {
+	for _, p := range platforms {
+		if j.ImageFor(p.Type, p.Arch) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// afterと入力の依存を辿って循環しているステップ名の列を返す
+func (w *Workflow) findCycles(steps map[string]*Step) [][]string {
+	ss := w.Steps
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	states := make(map[string]int, len(ss))
+	cycles := make([][]string, 0)
+	stack := make([]string, 0, len(ss))
+	var visit func(name string)
+	visit = func(name string) {
+		states[name] = visiting
+		stack = append(stack, name)
+		for _, after := range w.DependencyNames(steps[name]) {
+			if steps[after] == nil || after == name {
+				continue
+			}
+			switch states[after] {
+			case unvisited:
+				visit(after)
+			case visiting:
+				cycle := make([]string, 0)
+				for i := len(stack) - 1; i >= 0; i-- {
+					cycle = append([]string{stack[i]}, cycle...)
+					if stack[i] == after {
+						break
+					}
+				}
+				cycles = append(cycles, append(cycle, after))
+			}
+		}
+		stack = stack[:len(stack)-1]
+		states[name] = visited
+	}
+	for _, s := range ss {
+		if steps[s.Name] != s || states[s.Name] != unvisited {
+			continue
+		}
+		visit(s.Name)
+	}
+	return cycles
+}
+
+func elemPath(kind string, i int, name string) string {
+	if name == "" {
+		return fmt.Sprintf("%s[%d]", kind, i)
+	}
+	return fmt.Sprintf("%s[%s]", kind, name)
+}
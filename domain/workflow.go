@@ -1,12 +1,15 @@
 package domain
 
 import (
+	"encoding/json"
 	"errors"
 	"strings"
+	"time"
 )
 
 type Workflow struct {
 	ID      string   `json:"id"`
+	Version int      `yaml:"-" json:"version"`
 	Name    string   `yaml:"name" json:"name"`
 	Trigger *Trigger `yaml:"trigger" json:"trigger"`
 	Jobs    []*Job   `yaml:"jobs" json:"jobs"`
@@ -14,11 +17,11 @@ type Workflow struct {
 }
 
 func (w *Workflow) SetStepsJob() error {
-	for fi, f := range w.Steps {
+	for _, f := range w.stepsWithFailures() {
 		matched := false
 		for _, j := range w.Jobs {
 			if f.JobName == j.Name {
-				w.Steps[fi].Job = j
+				f.Job = j
 				matched = true
 				break
 			}
@@ -30,16 +33,87 @@ func (w *Workflow) SetStepsJob() error {
 	return nil
 }
 
+// failureのステップも含めた全てのステップ
+func (w *Workflow) stepsWithFailures() []*Step {
+	ss := make([]*Step, 0, len(w.Steps))
+	for _, s := range w.Steps {
+		ss = append(ss, s)
+		if s.Failure != nil {
+			ss = append(ss, s.Failure)
+		}
+	}
+	return ss
+}
+
 func (w *Workflow) NextStepsByCurrentStepID(currentStepID string) []*Step {
 	ss := make([]*Step, 0)
 	for _, s := range w.Steps {
-		if s.AfterByID == currentStepID {
+		if s.HasParent(currentStepID) {
 			ss = append(ss, s)
 		}
 	}
 	return ss
 }
 
+// 名前付きの出力を出すステップ名. triggerの出力なら空文字
+func (w *Workflow) producerOf(outputName string) (string, bool) {
+	if w.Trigger != nil && w.Trigger.Output != "" && w.Trigger.Output == outputName {
+		return "", true
+	}
+	for _, s := range w.Steps {
+		if s.outputName(w.jobOf(s)) == outputName {
+			return s.Name, true
+		}
+	}
+	return "", false
+}
+
+// SetStepsJobの前でもjobNameからジョブを引く
+func (w *Workflow) jobOf(s *Step) *Job {
+	if s.Job != nil {
+		return s.Job
+	}
+	for _, j := range w.Jobs {
+		if j.Name == s.JobName {
+			return j
+		}
+	}
+	return nil
+}
+
+// 待ち合わせる必要のあるステップ名
+// afterに加えて、入力を出力するステップも含める
+func (w *Workflow) DependencyNames(s *Step) []string {
+	names := make([]string, 0, len(s.After))
+	seen := make(map[string]bool, len(s.After))
+	add := func(name string) {
+		if name == "" || seen[name] {
+			return
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	for _, after := range s.After {
+		add(after)
+	}
+	for _, input := range s.inputNames(w.jobOf(s)) {
+		if producer, ok := w.producerOf(input); ok && producer != s.Name {
+			add(producer)
+		}
+	}
+	return names
+}
+
+// failureのステップも探す
+func (w *Workflow) StepByID(id string) *Step {
+	for _, s := range w.stepsWithFailures() {
+		if s.ID == id {
+			return s
+		}
+	}
+	return nil
+}
+
 func (w *Workflow) GetFailureStepByFailedStepID(failedStepID string) *Step {
 	for _, s := range w.Steps {
 		if s.ID != failedStepID {
@@ -58,11 +132,45 @@ const (
 )
 
 type Trigger struct {
-	Type   TriggerType `yaml:"type" json:"type"`
-	Path   string      `yaml:"path" json:"path"`
-	Output string      `yaml:"output" json:"output"`
+	Type TriggerType `yaml:"type" json:"type"`
+	Path string      `yaml:"path" json:"path"`
+	// cronの実行間隔. cron式(ex: */5 * * * *)か間隔(ex: 10s)
+	Schedule string `yaml:"schedule" json:"schedule"`
+	// cronで実行を始めるワーカー名. 空ならmasterが決める
+	Worker string `yaml:"worker" json:"worker"`
+	// masterが止まっていて実行し損ねた時の扱い
+	MissedFire MissedFirePolicy `yaml:"missedFire" json:"missed_fire"`
+	// triggerのデータを名前付きの出力として後続のステップへ渡す時の名前
+	Output string `yaml:"output" json:"output"`
+	// ワークフローの結果となる名前付きの出力. 同期トリガーで返す
+	Result string `yaml:"result" json:"result"`
+	// trueなら、Resultが出力されるまでHTTPのリクエストを待たせて結果を返す. httpトリガーのみ
+	Sync bool `yaml:"sync" json:"sync"`
+	// 同期トリガーで結果を待つ時間 (ex: 10s). 空なら30s
+	Timeout string `yaml:"timeout" json:"timeout"`
+}
+
+const defaultTriggerTimeout = 30 * time.Second
+
+func (t *Trigger) TimeoutDuration() time.Duration {
+	d, err := time.ParseDuration(t.Timeout)
+	if err != nil || d <= 0 {
+		return defaultTriggerTimeout
+	}
+	return d
 }
 
+type MissedFirePolicy string
+
+const (
+	// 実行し損ねた分は実行しない
+	MissedFireSkip MissedFirePolicy = "skip"
+	// 実行し損ねた分があれば1回だけ実行する
+	MissedFireOnce MissedFirePolicy = "once"
+	// 実行し損ねた回数分実行する
+	MissedFireAll MissedFirePolicy = "all"
+)
+
 type ImageType string
 
 func (t ImageType) Satisfy(at ImageType) bool {
@@ -89,28 +197,28 @@ const (
 	PlaceEdge  Place = "edge"
 	PlaceCloud Place = "cloud"
 	PlaceAny   Place = "any"
+	// edgeにつながるセンサーなどの端末. placeがdeviceのステップだけを実行する
+	PlaceDevice Place = "device"
 )
 
-type ArchType string
+// ワーカーのplaceとして使えるかどうか
+func (p Place) IsWorkerPlace() bool {
+	return p == PlaceDevice || p == PlaceEdge || p == PlaceCloud
+}
 
-func (t ArchType) Satisfy(at ArchType) bool {
-	ts := strings.Split(string(t), ",")
-	ats := strings.Split(string(at), ",")
-	for _, tt := range ts {
-		for _, att := range ats {
-			if tt == att {
-				return true
-			}
-		}
+// device→edge→cloudの順に上流になる. ワーカーのplaceでなければ-1
+func (p Place) Tier() int {
+	switch p {
+	case PlaceDevice:
+		return 0
+	case PlaceEdge:
+		return 1
+	case PlaceCloud:
+		return 2
 	}
-	return false
+	return -1
 }
 
-const (
-	ArchTypeAMD ArchType = "amd"
-	ArchTypeARM ArchType = "arm"
-)
-
 type Image struct {
 	Type  ImageType `yaml:"type" json:"type"`
 	Arch  ArchType  `yaml:"arch" json:"arch"`
@@ -122,21 +230,132 @@ type Job struct {
 	Images   []*Image `yaml:"images" json:"images"`
 	Function string   `yaml:"function,omitempty" json:"function"`
 	Input    string   `yaml:"input" json:"input"`
-	Limits   struct {
-		Memory string `yaml:"memory" json:"memory"`
-		CPU    string `yaml:"cpu" json:"cpu"`
-	} `yaml:"limits,omitempty" json:"limits"`
-	Output string `yaml:"output,omitempty" json:"output"`
+	Limits   Limits   `yaml:"limits,omitempty" json:"limits"`
+	Output   string   `yaml:"output,omitempty" json:"output"`
 }
 
 type Step struct {
-	ID        string   `yaml:"-" json:"id"`
-	Name      string   `yaml:"name" json:"name"`
-	JobName   string   `yaml:"jobName" json:"job_name"`
-	Place     Place    `yaml:"place" json:"place"`
-	Labels    []string `yaml:"labels" json:"labels"`
-	After     string   `yaml:"after" json:"after"`
-	AfterByID string   `yaml:"-" json:"after_by_id"`
-	Job       *Job     `yaml:"-" json:"job"`
-	Failure   *Step    `yaml:"failure" json:"failure"`
+	ID        string    `yaml:"-" json:"id"`
+	Name      string    `yaml:"name" json:"name"`
+	JobName   string    `yaml:"jobName" json:"job_name"`
+	Place     Place     `yaml:"place" json:"place"`
+	Labels    []string  `yaml:"labels" json:"labels"`
+	After     StepNames `yaml:"after" json:"after"`
+	AfterByID []string  `yaml:"-" json:"after_by_id"`
+	// labelsに加えたワーカーのラベルの条件 (ex: zone in (tokyo, osaka), !gpu)
+	// labelsはワーカーが全て持っている必要がある (ex: log-db, zone=tokyo)
+	Selector string `yaml:"selector" json:"selector"`
+	// 受け取る名前付きの出力. 空ならjobのinput
+	Inputs []string `yaml:"inputs" json:"inputs"`
+	// このステップの出力の名前. 空ならjobのoutput
+	Output string `yaml:"output" json:"output"`
+	// 実行する条件. 偽ならこのステップと後続のステップは実行しない
+	When    string `yaml:"when" json:"when"`
+	Job     *Job   `yaml:"-" json:"job"`
+	Failure *Step  `yaml:"failure" json:"failure"`
+	// 失敗した時のリトライ. nilならリトライしない
+	Retry *RetryPolicy `yaml:"retry" json:"retry"`
+	// ジョブの実行時間の上限 (ex: 30s, 5m). 空ならワーカーのデフォルト
+	Timeout string `yaml:"timeout" json:"timeout"`
+	// placeにワーカーが無い時の方針. nilなら今まで通り
+	Placement *PlacementPolicy `yaml:"placement" json:"placement"`
+}
+
+// 0ならタイムアウト無し
+func (s *Step) TimeoutDuration() time.Duration {
+	d, err := time.ParseDuration(s.Timeout)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// triggerから直接実行されるステップかどうか
+func (s *Step) IsRoot() bool {
+	return len(s.AfterByID) == 0
+}
+
+func (s *Step) InputNames() []string {
+	return s.inputNames(s.Job)
+}
+
+func (s *Step) OutputName() string {
+	return s.outputName(s.Job)
+}
+
+func (s *Step) inputNames(j *Job) []string {
+	if len(s.Inputs) != 0 {
+		return s.Inputs
+	}
+	if j == nil || j.Input == "" {
+		return nil
+	}
+	names := strings.Split(j.Input, ",")
+	for i := range names {
+		names[i] = strings.TrimSpace(names[i])
+	}
+	return names
+}
+
+func (s *Step) outputName(j *Job) string {
+	if s.Output != "" {
+		return s.Output
+	}
+	if j == nil {
+		return ""
+	}
+	return j.Output
+}
+
+// 複数の親ステップを待ち合わせるステップかどうか
+func (s *Step) IsJoin() bool {
+	return len(s.AfterByID) > 1
+}
+
+func (s *Step) HasParent(stepID string) bool {
+	for _, id := range s.AfterByID {
+		if id == stepID {
+			return true
+		}
+	}
+	return false
+}
+
+// afterに指定されたステップ名のリスト
+// 以前の書き方(after: step-name)も受け付けるように、文字列単体もリストとして扱う
+type StepNames []string
+
+func (n *StepNames) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err == nil {
+		*n = newStepNames(name)
+		return nil
+	}
+	var names []string
+	if err := unmarshal(&names); err != nil {
+		return err
+	}
+	*n = names
+	return nil
+}
+
+func (n *StepNames) UnmarshalJSON(b []byte) error {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		*n = newStepNames(name)
+		return nil
+	}
+	var names []string
+	if err := json.Unmarshal(b, &names); err != nil {
+		return err
+	}
+	*n = names
+	return nil
+}
+
+func newStepNames(name string) StepNames {
+	if name == "" {
+		return nil
+	}
+	return StepNames{name}
 }
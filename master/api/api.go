@@ -1,14 +1,17 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/mobmob912/takuhai/domain"
 
 	"github.com/mobmob912/takuhai/master/master"
+	"github.com/mobmob912/takuhai/master/master/repository"
 
 	"github.com/go-chi/chi"
 )
@@ -38,14 +41,35 @@ func (s *Server) Serve() error {
 	r.Method(GET, "/workers", handler(s.listWorkers))
 	r.Method(POST, "/workers", handler(s.addWorker))
 	r.Method(PUT, "/workers/{workerID}", handler(s.updateWorkerResource))
+	// ワーカー間のレイテンシ. 各ワーカーが定期的に計測して送ってくる
+	r.Method(GET, "/workers/latencies", handler(s.listLatencies))
+	r.Method(POST, "/workers/{workerID}/latencies", handler(s.recordLatencies))
+	// メンテナンスのために新しいステップを配置しないようにする. drainはジョブが終わってからアンデプロイもする
+	r.Method(POST, "/workers/{workerID}/cordon", handler(s.cordonWorker))
+	r.Method(POST, "/workers/{workerID}/uncordon", handler(s.uncordonWorker))
+	r.Method(POST, "/workers/{workerID}/drain", handler(s.drainWorker))
 
 	r.Method(GET, "/workflows", handler(s.listWorkflows))
 	r.Method(POST, "/workflows", handler(s.addWorkflow))
+	r.Method(PUT, "/workflows/{workflowName}", handler(s.updateWorkflow))
+	r.Method(GET, "/workflows/{workflowName}/versions", handler(s.listWorkflowVersions))
+	r.Method(POST, "/workflows/{workflowName}/rollback", handler(s.rollbackWorkflow))
+	r.Method(GET, "/workflows/{workflowName}/schedule", handler(s.listUpcomingFires))
 	r.Method(GET, "/workflows/{workflowID}/steps/{stepID}/worker", handler(s.nextJobWorker))
+	// 配置せずにnextJobWorkerと同じ判断をする. explain=trueならワーカー毎の判断の内訳も返す
+	r.Method(GET, "/workflows/{workflowID}/steps/{stepID}/placement", handler(s.placement))
 
-	// とりま何もしない. ログ集めとかする
+	// ワーカーからステップの失敗が報告される. runの履歴に残す
 	r.Method(POST, "/workflows/{workflowID}/steps/{stepID}/fail", handler(s.fail))
 	r.Method(GET, "/workflows/{workflowName}/status", handler(s.getStatusOfWorkflow))
+	r.Method(GET, "/workflows/{workflowName}/runs", handler(s.listWorkflowRuns))
+	r.Method(GET, "/workflows/{workflowName}/telemetry", handler(s.getWorkflowTelemetry))
+
+	// ワーカーからステップの実行時間や転送時間が送られる
+	r.Method(POST, "/delayinfo", handler(s.recordDelayInfo))
+
+	r.Method(GET, "/runs/{runID}", handler(s.getRun))
+	r.Method(POST, "/runs/{runID}/events", handler(s.recordRunEvent))
 
 	return http.ListenAndServe(":3000", r)
 }
@@ -146,7 +170,12 @@ func (s *Server) updateWorkerResource(w http.ResponseWriter, r *http.Request) er
 		return err
 	}
 	wk.ID = workerID
-	if err := s.master.UpdateWorkerResource(ctx, wk); err != nil {
+	switch err := s.master.UpdateWorkerResource(ctx, wk); err {
+	case nil:
+	case master.ErrWorkerRemoved:
+		sendResponse(w, http.StatusGone, []byte(err.Error()))
+		return err
+	default:
 		sendResponse(w, http.StatusInternalServerError, nil)
 		return err
 	}
@@ -154,6 +183,35 @@ func (s *Server) updateWorkerResource(w http.ResponseWriter, r *http.Request) er
 	return nil
 }
 
+func (s *Server) cordonWorker(w http.ResponseWriter, r *http.Request) error {
+	return s.changeWorkerCordon(w, r, s.master.CordonWorker, http.StatusNoContent)
+}
+
+func (s *Server) uncordonWorker(w http.ResponseWriter, r *http.Request) error {
+	return s.changeWorkerCordon(w, r, s.master.UncordonWorker, http.StatusNoContent)
+}
+
+// drainは待たずに返すので、終わったかはGET /workersのdrainで確かめる
+func (s *Server) drainWorker(w http.ResponseWriter, r *http.Request) error {
+	return s.changeWorkerCordon(w, r, s.master.DrainWorker, http.StatusAccepted)
+}
+
+func (s *Server) changeWorkerCordon(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, id string) error, status int) error {
+	ctx := r.Context()
+	workerID := chi.URLParam(r, "workerID")
+	switch err := change(ctx, workerID); err {
+	case nil:
+	case repository.ErrNotFound:
+		sendResponse(w, http.StatusNotFound, []byte(err.Error()))
+		return err
+	default:
+		sendResponse(w, http.StatusInternalServerError, nil)
+		return err
+	}
+	sendResponse(w, status, nil)
+	return nil
+}
+
 func (s *Server) check(w http.ResponseWriter, r *http.Request) error {
 	sendResponse(w, http.StatusOK, nil)
 	return nil
@@ -179,9 +237,14 @@ func (s *Server) addWorkflow(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	var wf domain.Workflow
 	if err := json.NewDecoder(r.Body).Decode(&wf); err != nil {
+		sendResponse(w, http.StatusBadRequest, []byte(err.Error()))
 		return err
 	}
 	id, err := s.master.AddWorkflow(ctx, &wf)
+	if errs, ok := err.(domain.ValidationErrors); ok {
+		sendValidationErrors(w, errs)
+		return err
+	}
 	if err != nil {
 		sendResponse(w, http.StatusInternalServerError, nil)
 		return err
@@ -196,36 +259,143 @@ func (s *Server) addWorkflow(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
-// JobIDの整合性取れないので無理
-//func (s *Server) updateWorkflow(w http.ResponseWriter, r *http.Request) error {
-//	ctx := r.Context()
-//	var wf domain.Workflow
-//	if err := json.NewDecoder(r.Body).Decode(&wf); err != nil {
-//		sendResponse(w, http.StatusInternalServerError, nil)
-//		return err
-//	}
-//	if err := s.master.UpdateWorkflow(ctx, wf.JobName, &wf); err != nil {
-//		sendResponse(w, http.StatusInternalServerError, nil)
-//		return err
-//	}
-//	respBody, err := json.Marshal(wf)
-//	if err != nil {
-//		sendResponse(w, http.StatusInternalServerError, []byte(err.Error()))
-//		return err
-//	}
-//	sendResponse(w, http.StatusCreated, respBody)
-//	return nil
-//}
+func (s *Server) updateWorkflow(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	workflowName := chi.URLParam(r, "workflowName")
+	var wf domain.Workflow
+	if err := json.NewDecoder(r.Body).Decode(&wf); err != nil {
+		sendResponse(w, http.StatusBadRequest, []byte(err.Error()))
+		return err
+	}
+	err := s.master.UpdateWorkflow(ctx, workflowName, &wf)
+	if errs, ok := err.(domain.ValidationErrors); ok {
+		sendValidationErrors(w, errs)
+		return err
+	}
+	switch err {
+	case nil:
+	case repository.ErrNotFound:
+		sendResponse(w, http.StatusNotFound, []byte(err.Error()))
+		return err
+	case master.ErrWorkflowNameMismatch:
+		sendResponse(w, http.StatusBadRequest, []byte(err.Error()))
+		return err
+	default:
+		sendResponse(w, http.StatusInternalServerError, []byte(err.Error()))
+		return err
+	}
+	respBody, err := json.Marshal(wf)
+	if err != nil {
+		sendResponse(w, http.StatusInternalServerError, []byte(err.Error()))
+		return err
+	}
+	sendResponse(w, http.StatusOK, respBody)
+	return nil
+}
+
+func (s *Server) listWorkflowVersions(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	workflowName := chi.URLParam(r, "workflowName")
+	wfs, err := s.master.ListWorkflowRevisions(ctx, workflowName)
+	if err == repository.ErrNotFound {
+		sendResponse(w, http.StatusNotFound, []byte(err.Error()))
+		return err
+	}
+	if err != nil {
+		sendResponse(w, http.StatusInternalServerError, []byte(err.Error()))
+		return err
+	}
+	respBody, err := json.Marshal(wfs)
+	if err != nil {
+		sendResponse(w, http.StatusInternalServerError, []byte(err.Error()))
+		return err
+	}
+	sendResponse(w, http.StatusOK, respBody)
+	return nil
+}
+
+func (s *Server) rollbackWorkflow(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	workflowName := chi.URLParam(r, "workflowName")
+	var req RollbackWorkflowRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendResponse(w, http.StatusBadRequest, []byte(err.Error()))
+		return err
+	}
+	wf, err := s.master.RollbackWorkflow(ctx, workflowName, req.Version)
+	if errs, ok := err.(domain.ValidationErrors); ok {
+		sendValidationErrors(w, errs)
+		return err
+	}
+	if err == repository.ErrNotFound {
+		sendResponse(w, http.StatusNotFound, []byte(err.Error()))
+		return err
+	}
+	if err != nil {
+		sendResponse(w, http.StatusInternalServerError, []byte(err.Error()))
+		return err
+	}
+	respBody, err := json.Marshal(wf)
+	if err != nil {
+		sendResponse(w, http.StatusInternalServerError, []byte(err.Error()))
+		return err
+	}
+	sendResponse(w, http.StatusOK, respBody)
+	return nil
+}
+
+// cronトリガーの次回以降の実行時刻
+func (s *Server) listUpcomingFires(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	workflowName := chi.URLParam(r, "workflowName")
+	n := 5
+	if nStr := r.URL.Query().Get("n"); nStr != "" {
+		parsed, err := strconv.Atoi(nStr)
+		if err != nil || parsed <= 0 {
+			sendResponse(w, http.StatusBadRequest, []byte("n must be a positive number"))
+			return err
+		}
+		n = parsed
+	}
+	fires, err := s.master.ListUpcomingFires(ctx, workflowName, n)
+	switch err {
+	case nil:
+	case repository.ErrNotFound:
+		sendResponse(w, http.StatusNotFound, []byte(err.Error()))
+		return err
+	case master.ErrNotCronWorkflow:
+		sendResponse(w, http.StatusBadRequest, []byte(err.Error()))
+		return err
+	default:
+		sendResponse(w, http.StatusInternalServerError, []byte(err.Error()))
+		return err
+	}
+	respBody, err := json.Marshal(fires)
+	if err != nil {
+		sendResponse(w, http.StatusInternalServerError, []byte(err.Error()))
+		return err
+	}
+	sendResponse(w, http.StatusOK, respBody)
+	return nil
+}
 
 func (s *Server) nextJobWorker(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	workflowID := chi.URLParam(r, "workflowID")
 	stepID := chi.URLParam(r, "stepID")
 	preWorkerID := r.URL.Query().Get("previousJobWorkerID")
+	runID := r.URL.Query().Get("runID")
+	version, err := workflowVersionParam(r)
+	if err != nil {
+		sendResponse(w, http.StatusBadRequest, []byte("workflowVersion must be a number"))
+		return err
+	}
 	wk, err := s.master.DetermineNextJobWorker(ctx, &master.OptionsDetermineNextJobWorker{
 		WorkflowID:          workflowID,
 		StepID:              stepID,
 		PreviousJobWorkerID: preWorkerID,
+		RunID:               runID,
+		WorkflowVersion:     version,
 	})
 	if err != nil {
 		sendResponse(w, http.StatusInternalServerError, []byte(err.Error()))
@@ -242,11 +412,64 @@ func (s *Server) nextJobWorker(w http.ResponseWriter, r *http.Request) error {
 	return nil
 }
 
+func (s *Server) placement(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	version, err := workflowVersionParam(r)
+	if err != nil {
+		sendResponse(w, http.StatusBadRequest, []byte("workflowVersion must be a number"))
+		return err
+	}
+	e, err := s.master.ExplainPlacement(ctx, &master.OptionsDetermineNextJobWorker{
+		WorkflowID:          chi.URLParam(r, "workflowID"),
+		StepID:              chi.URLParam(r, "stepID"),
+		PreviousJobWorkerID: r.URL.Query().Get("previousJobWorkerID"),
+		RunID:               r.URL.Query().Get("runID"),
+		WorkflowVersion:     version,
+	})
+	if err == repository.ErrNotFound {
+		sendResponse(w, http.StatusNotFound, []byte(err.Error()))
+		return err
+	}
+	if err != nil {
+		sendResponse(w, http.StatusInternalServerError, []byte(err.Error()))
+		return err
+	}
+	if r.URL.Query().Get("explain") != "true" {
+		e.Workers = nil
+	}
+	respBody, err := json.Marshal(e)
+	if err != nil {
+		sendResponse(w, http.StatusInternalServerError, []byte(err.Error()))
+		return err
+	}
+	sendResponse(w, http.StatusOK, respBody)
+	return nil
+}
+
+// runが開始された時のワークフローのバージョン. 無ければ0
+func workflowVersionParam(r *http.Request) (int, error) {
+	v := r.URL.Query().Get("workflowVersion")
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(v)
+}
+
 func (s *Server) fail(w http.ResponseWriter, r *http.Request) error {
 	ctx := r.Context()
 	workflowID := chi.URLParam(r, "workflowID")
 	stepID := chi.URLParam(r, "stepID")
-	return s.master.FailJob(ctx, workflowID, stepID)
+	var req FailJobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendResponse(w, http.StatusBadRequest, nil)
+		return err
+	}
+	if err := s.master.FailJob(ctx, workflowID, stepID, req.ToStepFailure()); err != nil {
+		sendResponse(w, http.StatusInternalServerError, nil)
+		return err
+	}
+	sendResponse(w, http.StatusNoContent, nil)
+	return nil
 }
 
 func (s *Server) getStatusOfWorkflow(w http.ResponseWriter, r *http.Request) error {
@@ -265,3 +488,160 @@ func (s *Server) getStatusOfWorkflow(w http.ResponseWriter, r *http.Request) err
 	sendResponse(w, http.StatusOK, respBody)
 	return nil
 }
+
+func (s *Server) listWorkflowRuns(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	workflowName := chi.URLParam(r, "workflowName")
+	limit := 20
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 0 {
+			sendResponse(w, http.StatusBadRequest, []byte("limit must be a positive number"))
+			return err
+		}
+		limit = parsed
+	}
+	runs, err := s.master.ListWorkflowRuns(ctx, workflowName, limit)
+	if err == repository.ErrNotFound {
+		sendResponse(w, http.StatusNotFound, []byte(err.Error()))
+		return err
+	}
+	if err != nil {
+		sendResponse(w, http.StatusInternalServerError, []byte(err.Error()))
+		return err
+	}
+	respBody, err := json.Marshal(runs)
+	if err != nil {
+		sendResponse(w, http.StatusInternalServerError, []byte(err.Error()))
+		return err
+	}
+	sendResponse(w, http.StatusOK, respBody)
+	return nil
+}
+
+func (s *Server) getWorkflowTelemetry(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	workflowName := chi.URLParam(r, "workflowName")
+	limit := 1000
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed < 0 {
+			sendResponse(w, http.StatusBadRequest, []byte("limit must be a positive number"))
+			return err
+		}
+		limit = parsed
+	}
+	t, err := s.master.GetWorkflowTelemetry(ctx, workflowName, limit)
+	if err == repository.ErrNotFound {
+		sendResponse(w, http.StatusNotFound, []byte(err.Error()))
+		return err
+	}
+	if err != nil {
+		sendResponse(w, http.StatusInternalServerError, []byte(err.Error()))
+		return err
+	}
+	respBody, err := json.Marshal(t)
+	if err != nil {
+		sendResponse(w, http.StatusInternalServerError, []byte(err.Error()))
+		return err
+	}
+	sendResponse(w, http.StatusOK, respBody)
+	return nil
+}
+
+func (s *Server) recordDelayInfo(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	var info DelayInfo
+	if err := json.NewDecoder(r.Body).Decode(&info); err != nil {
+		sendResponse(w, http.StatusBadRequest, []byte(err.Error()))
+		return err
+	}
+	if err := info.Validate(); err != nil {
+		sendResponse(w, http.StatusBadRequest, []byte(err.Error()))
+		return err
+	}
+	if err := s.master.RecordTelemetry(ctx, info.ToTelemetrySample()); err != nil {
+		sendResponse(w, http.StatusInternalServerError, []byte(err.Error()))
+		return err
+	}
+	sendResponse(w, http.StatusNoContent, nil)
+	return nil
+}
+
+func (s *Server) getRun(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	runID := chi.URLParam(r, "runID")
+	run, err := s.master.GetRun(ctx, runID)
+	if err == repository.ErrNotFound {
+		sendResponse(w, http.StatusNotFound, []byte(err.Error()))
+		return err
+	}
+	if err != nil {
+		sendResponse(w, http.StatusInternalServerError, []byte(err.Error()))
+		return err
+	}
+	respBody, err := json.Marshal(run)
+	if err != nil {
+		sendResponse(w, http.StatusInternalServerError, []byte(err.Error()))
+		return err
+	}
+	sendResponse(w, http.StatusOK, respBody)
+	return nil
+}
+
+func (s *Server) recordRunEvent(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	runID := chi.URLParam(r, "runID")
+	var e domain.RunEvent
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		sendResponse(w, http.StatusBadRequest, []byte(err.Error()))
+		return err
+	}
+	if err := s.master.RecordRunEvent(ctx, runID, &e); err != nil {
+		sendResponse(w, http.StatusInternalServerError, []byte(err.Error()))
+		return err
+	}
+	sendResponse(w, http.StatusNoContent, nil)
+	return nil
+}
+
+func (s *Server) listLatencies(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	ls, err := s.master.ListLatencies(ctx)
+	if err != nil {
+		sendResponse(w, http.StatusInternalServerError, []byte(err.Error()))
+		return err
+	}
+	respBody, err := json.Marshal(ls)
+	if err != nil {
+		sendResponse(w, http.StatusInternalServerError, []byte(err.Error()))
+		return err
+	}
+	sendResponse(w, http.StatusOK, respBody)
+	return nil
+}
+
+func (s *Server) recordLatencies(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	workerID := chi.URLParam(r, "workerID")
+	var req WorkerLatenciesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendResponse(w, http.StatusBadRequest, []byte(err.Error()))
+		return err
+	}
+	if err := s.master.RecordLatencies(ctx, workerID, req.ToMap()); err != nil {
+		sendResponse(w, http.StatusInternalServerError, []byte(err.Error()))
+		return err
+	}
+	sendResponse(w, http.StatusNoContent, nil)
+	return nil
+}
+
+func sendValidationErrors(w http.ResponseWriter, errs domain.ValidationErrors) {
+	respBody, err := json.Marshal(&ValidationErrorResponse{Errors: errs})
+	if err != nil {
+		sendResponse(w, http.StatusInternalServerError, []byte(err.Error()))
+		return
+	}
+	sendResponse(w, http.StatusBadRequest, respBody)
+}
@@ -3,9 +3,11 @@ package api
 import (
 	"errors"
 	"net/url"
+	"time"
 
 	"github.com/mobmob912/takuhai/domain"
 
+	"github.com/mobmob912/takuhai/master/master"
 	"github.com/mobmob912/takuhai/master/worker"
 )
 
@@ -15,6 +17,7 @@ type WorkerInfoRequest struct {
 	Arch   domain.ArchType  `json:"arch"`
 	Type   domain.ImageType `json:"type"`
 	Place  domain.Place     `json:"place"`
+	Parent string           `json:"parent"`
 	Labels []string         `json:"labels"`
 
 	CPUUsagePercent float64 `json:"cpu_usage_percent"`
@@ -52,8 +55,9 @@ func (n *WorkerInfoRequest) ToWorker() (*worker.Worker, error) {
 	return &worker.Worker{
 		Name:            n.Name,
 		Type:            n.Type,
-		Arch:            n.Arch,
+		Arch:            n.Arch.Canonical(),
 		Place:           n.Place,
+		Parent:          n.Parent,
 		Labels:          n.Labels,
 		URL:             u,
 		CPUUsagePercent: n.CPUUsagePercent,
@@ -62,16 +66,116 @@ func (n *WorkerInfoRequest) ToWorker() (*worker.Worker, error) {
 	}, nil
 }
 
+// ワーカーが計測した他のワーカーとのレイテンシ
+type WorkerLatenciesRequest struct {
+	Latencies []*WorkerLatency `json:"latencies"`
+}
+
+type WorkerLatency struct {
+	WorkerID string        `json:"worker_id"`
+	Latency  time.Duration `json:"latency"`
+}
+
+func (r *WorkerLatenciesRequest) ToMap() map[string]time.Duration {
+	m := make(map[string]time.Duration, len(r.Latencies))
+	for _, l := range r.Latencies {
+		m[l.WorkerID] = l.Latency
+	}
+	return m
+}
+
+// ワーカーから送られるステップの計測値. 次のステップへ渡す毎に送られる
+type DelayInfo struct {
+	WorkflowID      string `json:"workflow_id"`
+	WorkflowVersion int    `json:"workflow_version"`
+	RunID           string `json:"run_id"`
+	StepID          string `json:"step_id"`
+	Attempt         int    `json:"attempt"`
+	// ステップ名
+	JobName      string `json:"job_name"`
+	FromWorkerID string `json:"from_worker_id"`
+	// 最後のステップなら空
+	ToWorkerName string        `json:"to_worker_name"`
+	RAM          float64       `json:"ram"`
+	CPU          float64       `json:"cpu"`
+	Runtime      time.Duration `json:"runtime"`
+	// 次のステップのワーカーへ渡すのにかかった時間
+	Time time.Duration `json:"time"`
+}
+
+func (d *DelayInfo) Validate() error {
+	if d.WorkflowID == "" || d.StepID == "" {
+		return errors.New("workflow_id and step_id are must not empty")
+	}
+	return nil
+}
+
+func (d *DelayInfo) ToTelemetrySample() *domain.TelemetrySample {
+	return &domain.TelemetrySample{
+		WorkflowID:      d.WorkflowID,
+		WorkflowVersion: d.WorkflowVersion,
+		RunID:           d.RunID,
+		StepID:          d.StepID,
+		StepName:        d.JobName,
+		Attempt:         d.Attempt,
+		FromWorkerID:    d.FromWorkerID,
+		ToWorkerName:    d.ToWorkerName,
+		Runtime:         d.Runtime,
+		TransferTime:    d.Time,
+		RAM:             d.RAM,
+		CPU:             d.CPU,
+	}
+}
+
 type WorkerInfoResponse struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
-	URL  string `json:"url"`
+	ID              string                    `json:"id"`
+	Name            string                    `json:"name"`
+	URL             string                    `json:"url"`
+	State           worker.State              `json:"state"`
+	LastHeartbeatAt time.Time                 `json:"last_heartbeat_at"`
+	StateChangedAt  time.Time                 `json:"state_changed_at"`
+	Transitions     []*worker.StateTransition `json:"transitions"`
+	Cordoned        bool                      `json:"cordoned"`
+	Drain           worker.DrainState         `json:"drain,omitempty"`
 }
 
 func WorkerInfoResponseFromWorker(no *worker.Worker) *WorkerInfoResponse {
 	return &WorkerInfoResponse{
-		ID:   no.ID,
-		Name: no.Name,
-		URL:  no.URL.String(),
+		ID:              no.ID,
+		Name:            no.Name,
+		URL:             no.URL.String(),
+		State:           no.CurrentState(),
+		LastHeartbeatAt: no.LastHeartbeatAt,
+		StateChangedAt:  no.StateChangedAt,
+		Transitions:     no.Transitions,
+		Cordoned:        no.Cordoned,
+		Drain:           no.Drain,
+	}
+}
+
+type RollbackWorkflowRequest struct {
+	Version int `json:"version"`
+}
+
+// ワーカーからのステップの失敗の報告
+type FailJobRequest struct {
+	RunID           string             `json:"run_id"`
+	WorkflowVersion int                `json:"workflow_version"`
+	WorkerID        string             `json:"worker_id"`
+	Reason          domain.RetryReason `json:"reason"`
+	Message         string             `json:"message"`
+	Attempt         int                `json:"attempt"`
+	Final           bool               `json:"final"`
+}
+
+func (f *FailJobRequest) ToStepFailure() *master.StepFailure {
+	return &master.StepFailure{
+		RunID:           f.RunID,
+		WorkflowVersion: f.WorkflowVersion,
+		WorkerID:        f.WorkerID,
+		Reason:          f.Reason,
+		Message:         f.Message,
+		Attempt:         f.Attempt,
+		Final:           f.Final,
 	}
 }
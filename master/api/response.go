@@ -1,6 +1,7 @@
 package api
 
 import (
+	"github.com/mobmob912/takuhai/domain"
 	"github.com/mobmob912/takuhai/master/worker"
 )
 
@@ -21,3 +22,7 @@ func NewResponseWorker(w *worker.Worker) *ResponseWorker {
 		URL:  w.URL.String(),
 	}
 }
+
+type ValidationErrorResponse struct {
+	Errors domain.ValidationErrors `json:"errors"`
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/mobmob912/takuhai/master/master"
+)
+
+// masterの設定ファイル
+type Config struct {
+	Scheduler *master.SchedulerConfig `yaml:"scheduler"`
+	Lease     *master.LeaseConfig     `yaml:"lease"`
+}
+
+func NewConfigFromFile(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := yaml.Unmarshal(b, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
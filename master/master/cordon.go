@@ -0,0 +1,222 @@
+package master
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"github.com/mobmob912/takuhai/master/worker"
+)
+
+const (
+	// drain中に、ワーカーのジョブが終わったかを問い合わせる間隔
+	drainInterval = 1 * time.Second
+	// コンテナのアンデプロイは時間がかかることがある
+	undeployTimeout = 5 * time.Minute
+)
+
+// 新しいステップを配置しないようにする. 実行中のジョブはそのまま
+func (m *Master) CordonWorker(ctx context.Context, id string) error {
+	return m.updateWorker(ctx, id, func(w *worker.Worker) error {
+		w.Cordoned = true
+		return nil
+	})
+}
+
+// 配置するように戻す. drain中ならやめて、ワーカーにも新しいrunを受け付けさせる
+func (m *Master) UncordonWorker(ctx context.Context, id string) error {
+	var u url.URL
+	err := m.updateWorker(ctx, id, func(w *worker.Worker) error {
+		w.Cordoned = false
+		w.Drain = ""
+		u = *w.URL
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return setWorkerDraining(u, false)
+}
+
+// cordonしてから、実行中のジョブが終わるのを待って全てアンデプロイする
+// 待つのはgoroutineで、進み具合はワーカーのdrainで分かる. 既にdrain中なら新しく始めない
+func (m *Master) DrainWorker(ctx context.Context, id string) error {
+	start := false
+	err := m.updateWorker(ctx, id, func(w *worker.Worker) error {
+		w.Cordoned = true
+		w.Drain = worker.DrainStateDraining
+		start = !m.drainingWorkers[id]
+		m.drainingWorkers[id] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if start {
+		go m.drainWorker(context.Background(), id)
+	}
+	return nil
+}
+
+// uncordonされるか、ワーカーが消されたらやめる
+// ワーカーに新しいrunを止めさせてから、ジョブが終わるのを待ってアンデプロイさせる
+func (m *Master) drainWorker(ctx context.Context, id string) {
+	for {
+		w, ok, err := m.getDrainingWorker(ctx, id)
+		if !ok {
+			// uncordonと入れ違いでワーカーをdrain中にしていたら戻す
+			if w != nil && w.Drain == "" {
+				if err := setWorkerDraining(*w.URL, false); err != nil {
+					log.Printf("stop draining worker failed. id: %s. msg: %s", id, err.Error())
+				}
+			}
+			return
+		}
+		if err == nil {
+			if err = setWorkerDraining(*w.URL, true); err == nil {
+				if err = m.undeployWhenIdle(ctx, w); err == nil {
+					break
+				}
+			}
+		}
+		log.Printf("drain worker is waiting. id: %s. msg: %s", id, err.Error())
+		time.Sleep(drainInterval)
+	}
+	err := m.updateWorker(ctx, id, func(w *worker.Worker) error {
+		delete(m.drainingWorkers, id)
+		// 待っている間にuncordonされていたら戻さない
+		if w.Drain == worker.DrainStateDraining {
+			w.Drain = worker.DrainStateDrained
+		}
+		return nil
+	})
+	if err != nil {
+		m.workerMutex.Lock()
+		delete(m.drainingWorkers, id)
+		m.workerMutex.Unlock()
+		log.Printf("drain worker failed. id: %s. msg: %s", id, err.Error())
+		return
+	}
+	log.Printf("worker drained. id: %s", id)
+}
+
+// ワーカーとdrain中かどうかを返す. drain中でなくなっていたらfalseを返して、drainのgoroutineを終わらせる
+// DrainWorkerと同じロックの中で判断するので、終わりかけのgoroutineにdrainを取りこぼされない
+func (m *Master) getDrainingWorker(ctx context.Context, id string) (*worker.Worker, bool, error) {
+	m.workerMutex.Lock()
+	defer m.workerMutex.Unlock()
+	w, err := m.workerRepository.Get(ctx, id)
+	if err == repository.ErrNotFound {
+		delete(m.drainingWorkers, id)
+		return nil, false, nil
+	}
+	if err == nil && w.Drain != worker.DrainStateDraining {
+		delete(m.drainingWorkers, id)
+		return w, false, nil
+	}
+	return w, true, err
+}
+
+// ワーカーにdrain中かどうかを伝える. drain中のワーカーは新しいrunを受け付けない
+func setWorkerDraining(u url.URL, draining bool) error {
+	c := &http.Client{Timeout: 3 * time.Second}
+	u.Path = "/drain"
+	method := http.MethodPost
+	if !draining {
+		method = http.MethodDelete
+	}
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("set draining failed. status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+var (
+	errJobsRunning   = errors.New("jobs are running")
+	errJobsRemaining = errors.New("jobs remain after undeploy")
+)
+
+// ステップの状態の問い合わせで、どのワークフローのジョブも実行中でなければアンデプロイさせる
+// アンデプロイの後にもう一度問い合わせて、ジョブが残っていなければdrainを終える
+func (m *Master) undeployWhenIdle(ctx context.Context, w *worker.Worker) error {
+	statuses, err := m.listWorkerStepStatuses(ctx, w)
+	if err != nil {
+		return err
+	}
+	for _, s := range statuses {
+		// デプロイ中のジョブも、終われば実行されるので待つ
+		if s.IsRunning || s.IsPending {
+			return errJobsRunning
+		}
+	}
+	if err := undeployJobs(*w.URL); err != nil {
+		return err
+	}
+	statuses, err = m.listWorkerStepStatuses(ctx, w)
+	if err != nil {
+		return err
+	}
+	if !isDrained(statuses) {
+		return errJobsRemaining
+	}
+	return nil
+}
+
+// 全ワークフローについて、ワーカーのステップの状態を問い合わせる
+func (m *Master) listWorkerStepStatuses(ctx context.Context, w *worker.Worker) ([]*WorkerStepStatus, error) {
+	wfs, err := m.workflowRepository.ListAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]*WorkerStepStatus, 0)
+	for _, wf := range wfs {
+		ss, err := m.GetWorkerStepStatus(ctx, w, wf.ID)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, ss...)
+	}
+	return statuses, nil
+}
+
+// デプロイ済み、デプロイ中、実行中のジョブが一つも無ければdrainは終わり
+func isDrained(statuses []*WorkerStepStatus) bool {
+	for _, s := range statuses {
+		if s.IsDeployed || s.IsPending || s.IsRunning {
+			return false
+		}
+	}
+	return true
+}
+
+func undeployJobs(u url.URL) error {
+	c := &http.Client{Timeout: undeployTimeout}
+	u.Path = "/jobs/undeploy"
+	req, err := http.NewRequest(http.MethodPost, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("undeploy jobs failed. status: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package master
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/worker"
+)
+
+// ステップの状態を返し、アンデプロイでafterUndeployの状態に変わるワーカーマネージャ
+type fakeWorkerManager struct {
+	mutex          sync.Mutex
+	statuses       []*WorkerStepStatus
+	afterUndeploy  []*WorkerStepStatus
+	undeployCalled int
+}
+
+func (f *fakeWorkerManager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/jobs/undeploy":
+		f.undeployCalled++
+		f.statuses = f.afterUndeploy
+	case r.URL.Path == "/drain":
+	case r.Method == http.MethodGet && r.URL.Path == "/workflows/flow/steps/status":
+		json.NewEncoder(w).Encode(f.statuses)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeWorkerManager) undeployCount() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.undeployCalled
+}
+
+func newDrainTestMaster(t *testing.T, f *fakeWorkerManager) (*Master, string) {
+	t.Helper()
+	ctx := context.Background()
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	m := newTestMaster(t)
+	wf := &domain.Workflow{ID: "flow", Name: "flow", Version: 1}
+	if err := m.workflowRepository.Set(ctx, wf.ID, wf); err != nil {
+		t.Fatal(err)
+	}
+	id, err := m.AddWorker(ctx, newTestWorker(t, "edge1", srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, id
+}
+
+func TestUndeployWhenIdle(t *testing.T) {
+	step := &domain.Step{ID: "step", Name: "step"}
+	deployed := []*WorkerStepStatus{{Step: step, IsDeployed: true}}
+	tests := []struct {
+		name          string
+		statuses      []*WorkerStepStatus
+		afterUndeploy []*WorkerStepStatus
+		want          error
+		wantUndeploy  int
+	}{
+		{name: "nothing deployed", want: nil, wantUndeploy: 1},
+		{name: "idle jobs", statuses: deployed, want: nil, wantUndeploy: 1},
+		{name: "running job", statuses: []*WorkerStepStatus{{Step: step, IsDeployed: true, IsRunning: true}}, want: errJobsRunning},
+		{name: "pending job", statuses: []*WorkerStepStatus{{Step: step, IsPending: true}}, want: errJobsRunning},
+		// アンデプロイの後にジョブが残っていたら、まだdrainedにしない
+		{name: "deployed after undeploy", statuses: deployed, afterUndeploy: deployed, want: errJobsRemaining, wantUndeploy: 1},
+		{name: "pending after undeploy", statuses: deployed, afterUndeploy: []*WorkerStepStatus{{Step: step, IsPending: true}}, want: errJobsRemaining, wantUndeploy: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			f := &fakeWorkerManager{statuses: tt.statuses, afterUndeploy: tt.afterUndeploy}
+			m, id := newDrainTestMaster(t, f)
+			w, err := m.workerRepository.Get(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := m.undeployWhenIdle(ctx, w); err != tt.want {
+				t.Errorf("want %v, got %v", tt.want, err)
+			}
+			if got := f.undeployCount(); got != tt.wantUndeploy {
+				t.Errorf("undeploy: want %d calls, got %d", tt.wantUndeploy, got)
+			}
+		})
+	}
+}
+
+func TestDrainWorker(t *testing.T) {
+	ctx := context.Background()
+	step := &domain.Step{ID: "step", Name: "step"}
+	f := &fakeWorkerManager{
+		statuses: []*WorkerStepStatus{{Step: step, IsDeployed: true}},
+	}
+	m, id := newDrainTestMaster(t, f)
+	if err := m.DrainWorker(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	// 既にdrain中なら新しく始めない
+	if err := m.DrainWorker(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	var w *worker.Worker
+	for time.Now().Before(deadline) {
+		var err error
+		w, err = m.workerRepository.Get(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Drain == worker.DrainStateDrained {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if w.Drain != worker.DrainStateDrained {
+		t.Fatalf("drain: want %s, got %s", worker.DrainStateDrained, w.Drain)
+	}
+	if !w.Cordoned {
+		t.Errorf("want the drained worker cordoned")
+	}
+	if got := f.undeployCount(); got != 1 {
+		t.Errorf("undeploy: want 1 call, got %d", got)
+	}
+}
@@ -0,0 +1,241 @@
+package master
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"github.com/mobmob912/takuhai/master/worker"
+)
+
+// 再起動時に実行し損ねた分を実行する最大回数
+const maxMissedFires = 100
+
+var (
+	ErrNotCronWorkflow = errors.New("workflow is not triggered by cron")
+)
+
+type cronEntry struct {
+	workflow *domain.Workflow
+	schedule domain.Schedule
+	next     time.Time
+}
+
+// cronトリガーのワークフローと次の実行時刻を管理する
+type cronScheduler struct {
+	mutex *sync.Mutex
+	// k=workflowID
+	entries map[string]*cronEntry
+}
+
+func newCronScheduler() *cronScheduler {
+	return &cronScheduler{
+		mutex:   new(sync.Mutex),
+		entries: make(map[string]*cronEntry),
+	}
+}
+
+func (c *cronScheduler) set(e *cronEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[e.workflow.ID] = e
+}
+
+func (c *cronScheduler) remove(workflowID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.entries, workflowID)
+}
+
+func (c *cronScheduler) get(workflowID string) (*cronEntry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	e, ok := c.entries[workflowID]
+	if !ok {
+		return nil, false
+	}
+	cp := *e
+	return &cp, true
+}
+
+// 実行時刻を過ぎたワークフローを返し、次の実行時刻へ進める
+func (c *cronScheduler) popDue(now time.Time) []*domain.Workflow {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	wfs := make([]*domain.Workflow, 0)
+	for _, e := range c.entries {
+		if e.next.After(now) {
+			continue
+		}
+		wfs = append(wfs, e.workflow)
+		e.next = e.schedule.Next(now)
+	}
+	return wfs
+}
+
+// スケジュールを登録する
+// 前回の実行時刻から今までに実行し損ねた分は、ワークフローのmissedFireに従って実行する
+func (m *Master) registerCronWorkflow(ctx context.Context, wf *domain.Workflow) error {
+	s, err := domain.ParseSchedule(wf.Trigger.Schedule)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	m.cron.set(&cronEntry{
+		workflow: wf,
+		schedule: s,
+		next:     s.Next(now),
+	})
+
+	lastFiredAt, err := m.scheduleRepository.GetLastFiredAt(ctx, wf.ID)
+	if err == repository.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	missed := len(domain.ListFireTimes(s, lastFiredAt, now, maxMissedFires))
+	if missed == 0 {
+		return nil
+	}
+	fires := missedFiresToRun(wf.Trigger.MissedFire, missed)
+	if fires == 0 {
+		log.Printf("skip %d missed fires. workflow: %s", missed, wf.Name)
+		return nil
+	}
+	log.Printf("fire %d missed runs. workflow: %s", fires, wf.Name)
+	go func() {
+		for i := 0; i < fires; i++ {
+			if err := m.FireCronWorkflow(context.Background(), wf); err != nil {
+				log.Println(err)
+			}
+		}
+	}()
+	return nil
+}
+
+// 実行し損ねたmissed回のうち、missedFireに従って実行する回数. 指定が無ければskip
+func missedFiresToRun(policy domain.MissedFirePolicy, missed int) int {
+	switch policy {
+	case domain.MissedFireOnce:
+		if missed > 0 {
+			return 1
+		}
+	case domain.MissedFireAll:
+		return missed
+	}
+	return 0
+}
+
+// 基本goroutineで動かす
+func (m *Master) PeriodicFireCronWorkflows(ctx context.Context) {
+	for {
+		time.Sleep(1 * time.Second)
+		for _, wf := range m.cron.popDue(time.Now()) {
+			wf := wf
+			go func() {
+				if err := m.FireCronWorkflow(ctx, wf); err != nil {
+					log.Printf("cron fire failed. workflow: %s. msg: %s", wf.Name, err.Error())
+				}
+			}()
+		}
+	}
+}
+
+// ワークフローを開始するワーカーを選んで、実行を依頼する
+func (m *Master) FireCronWorkflow(ctx context.Context, wf *domain.Workflow) error {
+	firedAt := time.Now()
+	err := m.startCronWorkflow(ctx, wf)
+	// 失敗しても実行を試みた時刻として記録する. 記録しないと再起動の度に、missedFire: allで溜まった分を全て実行し直してしまう
+	if serr := m.scheduleRepository.SetLastFiredAt(ctx, wf.ID, firedAt); serr != nil {
+		if err != nil {
+			log.Println(serr)
+			return err
+		}
+		return serr
+	}
+	return err
+}
+
+func (m *Master) startCronWorkflow(ctx context.Context, wf *domain.Workflow) error {
+	wk, err := m.determineCronWorker(ctx, wf)
+	if err != nil {
+		return err
+	}
+	c := http.DefaultClient
+	u := *wk.URL
+	u.Path = fmt.Sprintf("/workflows/%s/start", wf.ID)
+	req, err := http.NewRequest(http.MethodPost, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("start workflow failed. worker: %s, status: %d", wk.Name, resp.StatusCode)
+	}
+	return nil
+}
+
+// trigger.workerで指定されていればそのワーカー、なければ最初のステップを実行するワーカー
+func (m *Master) determineCronWorker(ctx context.Context, wf *domain.Workflow) (*worker.Worker, error) {
+	if wf.Trigger.Worker != "" {
+		ws, err := m.workerRepository.ListAll(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, w := range ws {
+			if w.Name == wf.Trigger.Worker && w.Schedulable() && !w.Cordoned {
+				return w, nil
+			}
+		}
+		return nil, ErrMatchedWorkerNotFound
+	}
+	for _, s := range wf.Steps {
+		if !s.IsRoot() {
+			continue
+		}
+		return m.DetermineNextJobWorker(ctx, &OptionsDetermineNextJobWorker{
+			WorkflowID:      wf.ID,
+			StepID:          s.ID,
+			WorkflowVersion: wf.Version,
+		})
+	}
+	return nil, ErrMatchedWorkerNotFound
+}
+
+type UpcomingFires struct {
+	WorkflowName string      `json:"workflow_name"`
+	Schedule     string      `json:"schedule"`
+	Times        []time.Time `json:"times"`
+}
+
+// 次回以降の実行時刻をn件返す
+func (m *Master) ListUpcomingFires(ctx context.Context, workflowName string, n int) (*UpcomingFires, error) {
+	wf, err := m.workflowRepository.GetByName(ctx, workflowName)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := m.cron.get(wf.ID)
+	if !ok {
+		return nil, ErrNotCronWorkflow
+	}
+	times := make([]time.Time, 0, n)
+	for t := e.next; !t.IsZero() && len(times) < n; t = e.schedule.Next(t) {
+		times = append(times, t)
+	}
+	return &UpcomingFires{
+		WorkflowName: wf.Name,
+		Schedule:     wf.Trigger.Schedule,
+		Times:        times,
+	}, nil
+}
@@ -0,0 +1,67 @@
+package master
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mobmob912/takuhai/domain"
+)
+
+func TestMissedFiresToRun(t *testing.T) {
+	now := time.Date(2020, 12, 24, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		schedule    string
+		lastFiredAt time.Time
+		policy      domain.MissedFirePolicy
+		want        int
+	}{
+		{name: "none missed", schedule: "0 * * * *", lastFiredAt: now.Add(-20 * time.Minute), policy: domain.MissedFireAll, want: 0},
+		{name: "default skips", schedule: "0 * * * *", lastFiredAt: now.Add(-3 * time.Hour), want: 0},
+		{name: "skip", schedule: "0 * * * *", lastFiredAt: now.Add(-3 * time.Hour), policy: domain.MissedFireSkip, want: 0},
+		{name: "once", schedule: "0 * * * *", lastFiredAt: now.Add(-3 * time.Hour), policy: domain.MissedFireOnce, want: 1},
+		{name: "all", schedule: "0 * * * *", lastFiredAt: now.Add(-3 * time.Hour), policy: domain.MissedFireAll, want: 3},
+		{name: "all with an interval", schedule: "10m", lastFiredAt: now.Add(-35 * time.Minute), policy: domain.MissedFireAll, want: 3},
+		// 止まっていた間が長くても、maxMissedFiresまでしか実行しない
+		{name: "all is capped", schedule: "1m", lastFiredAt: now.Add(-24 * time.Hour), policy: domain.MissedFireAll, want: maxMissedFires},
+		{name: "once after a long stop", schedule: "1m", lastFiredAt: now.Add(-24 * time.Hour), policy: domain.MissedFireOnce, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := domain.ParseSchedule(tt.schedule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			missed := len(domain.ListFireTimes(s, tt.lastFiredAt, now, maxMissedFires))
+			if got := missedFiresToRun(tt.policy, missed); got != tt.want {
+				t.Errorf("want %d fires, got %d (missed %d)", tt.want, got, missed)
+			}
+		})
+	}
+}
+
+func TestCronSchedulerPopDue(t *testing.T) {
+	now := time.Date(2020, 12, 24, 10, 30, 0, 0, time.UTC)
+	s, err := domain.ParseSchedule("10m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newCronScheduler()
+	c.set(&cronEntry{workflow: &domain.Workflow{ID: "due"}, schedule: s, next: now})
+	c.set(&cronEntry{workflow: &domain.Workflow{ID: "later"}, schedule: s, next: now.Add(time.Minute)})
+
+	wfs := c.popDue(now)
+	if len(wfs) != 1 || wfs[0].ID != "due" {
+		t.Fatalf("want [due], got %v", wfs)
+	}
+	e, ok := c.get("due")
+	if !ok {
+		t.Fatal("due is removed")
+	}
+	if want := now.Add(10 * time.Minute); !e.next.Equal(want) {
+		t.Errorf("next: want %v, got %v", want, e.next)
+	}
+	if wfs := c.popDue(now); len(wfs) != 0 {
+		t.Errorf("want nothing due twice, got %v", wfs)
+	}
+}
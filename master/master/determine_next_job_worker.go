@@ -3,7 +3,8 @@ package master
 import (
 	"context"
 	"errors"
-	"reflect"
+	"log"
+	"time"
 
 	"github.com/mobmob912/takuhai/domain"
 
@@ -18,107 +19,140 @@ func (m *Master) DetermineNextJobWorker(ctx context.Context, opts *OptionsDeterm
 	if err := opts.Validate(); err != nil {
 		return nil, err
 	}
-	step, err := m.workflowRepository.GetStep(ctx, opts.WorkflowID, opts.StepID)
+	step, err := m.getStepOfVersion(ctx, opts.WorkflowID, opts.StepID, opts.WorkflowVersion)
 	if err != nil {
 		return nil, err
 	}
 
-	wks, err := m.workerRepository.ListAll(ctx)
-	if err != nil {
-		return nil, err
+	if !step.IsJoin() || opts.RunID == "" {
+		return m.determineNextJobWorker(ctx, step, opts)
 	}
-
-	wks, err = listWorkersFromTypeAndArch(ctx, wks, step.Job)
+	if workerID, ok := m.joinPlacements.get(opts.RunID, step.ID); ok {
+		return m.workerRepository.Get(ctx, workerID)
+	}
+	wk, err := m.determineNextJobWorker(ctx, step, opts)
 	if err != nil {
 		return nil, err
 	}
-
-	if len(step.Labels) != 0 {
-		labeledWks := make([]*worker.Worker, 0)
-		for _, w := range wks {
-			if reflect.DeepEqual(w.Labels, step.Labels) {
-				labeledWks = append(labeledWks, w)
-			}
-		}
-		wks = labeledWks
-	}
-	return determineNextWorkerFromWorkers(ctx, wks, step, opts)
+	m.joinPlacements.set(opts.RunID, step.ID, wk.ID, len(step.AfterByID))
+	return wk, nil
 }
 
-func listWorkersFromTypeAndArch(ctx context.Context, wks []*worker.Worker, j *domain.Job) ([]*worker.Worker, error) {
-	rwks := make([]*worker.Worker, 0)
-	for _, w := range wks {
-		for _, is := range j.Images {
-			if is.Type.Satisfy(w.Type) && is.Arch.Satisfy(w.Arch) {
-				rwks = append(rwks, w)
+// placementがqueueのステップで、ワーカーを探し直す間隔
+const placementQueueInterval = time.Second
+
+func (m *Master) determineNextJobWorker(ctx context.Context, step *domain.Step, opts *OptionsDetermineNextJobWorker) (*worker.Worker, error) {
+	start := time.Now()
+	waited := false
+	for {
+		wk, e, err := m.placeStep(ctx, step, opts)
+		if err != nil {
+			return nil, err
+		}
+		if wk != nil {
+			if waited {
+				e.Waited = time.Since(start)
+			}
+			if e.Fallback || e.Waited > 0 {
+				m.recordPlacement(ctx, opts.RunID, e)
 			}
+			return wk, nil
+		}
+		if time.Since(start) >= step.Placement.QueueTimeout() {
+			return nil, ErrMatchedWorkerNotFound
+		}
+		waited = true
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(placementQueueInterval):
 		}
 	}
-	return rwks, nil
 }
 
-func determineNextWorkerFromWorkers(ctx context.Context, wks []*worker.Worker, step *domain.Step, opts *OptionsDetermineNextJobWorker) (*worker.Worker, error) {
-	// TODO なかった時
-	if step.Place == domain.PlaceEdge && opts.PreviousJobWorkerID != "" {
-		for _, w := range wks {
-			if w.ID == opts.PreviousJobWorkerID {
-				return w, nil
-			}
+// ステップを配置するワーカーと、その判断の内訳を返す. 配置できるワーカーが無ければnil
+// placementがfallbackなら、ワーカーが見つかるまでplacesの順に試す
+func (m *Master) placeStep(ctx context.Context, step *domain.Step, opts *OptionsDetermineNextJobWorker) (*worker.Worker, *PlacementExplanation, error) {
+	wks, err := m.workerRepository.ListAll(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	sc := &SchedulingContext{
+		WorkflowID:          opts.WorkflowID,
+		Step:                step,
+		PreviousJobWorkerID: opts.PreviousJobWorkerID,
+		RunID:               opts.RunID,
+		Topology:            worker.NewTopology(wks),
+	}
+	if opts.PreviousJobWorkerID != "" {
+		sc.Latencies, err = m.latencyMatrix(ctx)
+		if err != nil {
+			return nil, nil, err
 		}
 	}
-
-	if step.Place == domain.PlaceCloud {
-		return determineNextWorkerByCloudWorkers(ctx, wks, opts)
+	var e *PlacementExplanation
+	for i, place := range step.PlacementPlaces() {
+		sc.Place = place
+		var wk *worker.Worker
+		wk, e, err = m.placeStepIn(ctx, sc, wks, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		if wk != nil {
+			e.Fallback = i > 0
+			return wk, e, nil
+		}
 	}
-	return determineNextWorkerByAllWorkers(ctx, wks, opts)
+	return nil, e, nil
 }
 
-func determineNextWorkerByCloudWorkers(ctx context.Context, wks []*worker.Worker, opts *OptionsDetermineNextJobWorker) (*worker.Worker, error) {
-	if err := opts.Validate(); err != nil {
-		return nil, err
-	}
-	cwks := make([]*worker.Worker, 0)
-
-	for _, w := range wks {
-		if w.Place == domain.PlaceCloud {
-			cwks = append(cwks, w)
+// sc.Placeのワーカーの中から選ぶ
+func (m *Master) placeStepIn(ctx context.Context, sc *SchedulingContext, wks []*worker.Worker, opts *OptionsDetermineNextJobWorker) (*worker.Worker, *PlacementExplanation, error) {
+	wk, explanations, err := m.scheduler.explain(ctx, sc, wks)
+	if err != nil {
+		return nil, nil, err
+	}
+	e := newPlacementExplanation(sc.Step, opts, explanations)
+	e.Place = sc.Place
+	e.DecidedBy = DecidedByScores
+	if sc.Step.Place == domain.PlaceAny {
+		predicted, estimates, err := m.predictNextJobWorker(ctx, sc, wks)
+		if err != nil {
+			return nil, nil, err
 		}
-	}
-	if len(cwks) == 0 {
-		return nil, ErrMatchedWorkerNotFound
-	}
-
-	var determinedWorker *worker.Worker
-	var maxRAM uint64
-	for _, wk := range cwks {
-		if wk.AvailableMemory > maxRAM {
-			determinedWorker = wk
-			maxRAM = wk.AvailableMemory
+		e.setPredictions(estimates)
+		if predicted != nil {
+			wk = predicted
+			e.DecidedBy = DecidedByPrediction
 		}
 	}
-	if determinedWorker == nil {
-		// TODO error type
-		return nil, ErrMatchedWorkerNotFound
-	}
-	return determinedWorker, nil
+	e.setWorker(wk)
+	return wk, e, nil
 }
 
-func determineNextWorkerByAllWorkers(ctx context.Context, wks []*worker.Worker, opts *OptionsDetermineNextJobWorker) (*worker.Worker, error) {
-	if err := opts.Validate(); err != nil {
-		return nil, err
-	}
-
-	var determinedWorker *worker.Worker
-	var maxRAM uint64
-	for _, wk := range wks {
-		if wk.AvailableMemory > maxRAM {
-			determinedWorker = wk
-			maxRAM = wk.AvailableMemory
-		}
+// fallbackやqueueで配置したことをrunに残す. 配置は決まっているので、残せなくてもログだけ
+func (m *Master) recordPlacement(ctx context.Context, runID string, e *PlacementExplanation) {
+	log.Printf("placed by placement policy. workflowID=%s, stepID=%s, runID=%s, workerID=%s, place=%s, fallback=%t, waited=%s", e.WorkflowID, e.StepID, runID, e.WorkerID, e.Place, e.Fallback, e.Waited)
+	if runID == "" {
+		return
 	}
-	if determinedWorker == nil {
-		// TODO error type
-		return nil, ErrMatchedWorkerNotFound
+	m.runMutex.Lock()
+	defer m.runMutex.Unlock()
+	run, err := m.runRepository.Get(ctx, runID)
+	if err != nil {
+		log.Printf("failed to get run %s: %s", runID, err.Error())
+		return
+	}
+	run.Placements = append(run.Placements, &domain.StepPlacement{
+		StepID:   e.StepID,
+		StepName: e.StepName,
+		WorkerID: e.WorkerID,
+		Place:    e.Place,
+		Fallback: e.Fallback,
+		Waited:   e.Waited,
+		Time:     time.Now(),
+	})
+	if err := m.runRepository.Set(ctx, run); err != nil {
+		log.Printf("failed to record placement of run %s: %s", runID, err.Error())
 	}
-	return determinedWorker, nil
 }
@@ -0,0 +1,145 @@
+package master
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/worker"
+)
+
+func newPlacementTestWorker(t *testing.T, name string, place domain.Place) *worker.Worker {
+	t.Helper()
+	w := newTestWorker(t, name, "http://"+name+":8080")
+	w.Arch = domain.ArchTypeAMD64
+	w.Place = place
+	w.AvailableMemory = gib
+	return w
+}
+
+func TestDetermineNextJobWorkerPlacement(t *testing.T) {
+	tests := []struct {
+		name      string
+		place     domain.Place
+		placement *domain.PlacementPolicy
+		workers   map[string]domain.Place
+		// 少し後から登録するワーカー
+		lateWorkers map[string]domain.Place
+		// 空ならErrMatchedWorkerNotFound
+		want         string
+		wantFallback bool
+		wantWaited   bool
+	}{
+		{
+			name:      "fallback uses the first place",
+			place:     domain.PlaceEdge,
+			placement: &domain.PlacementPolicy{Mode: domain.PlacementFallback, Places: []domain.Place{domain.PlaceEdge, domain.PlaceCloud}},
+			workers:   map[string]domain.Place{"edge1": domain.PlaceEdge, "cloud1": domain.PlaceCloud},
+			want:      "edge1",
+		},
+		{
+			name:         "fallback to the next place",
+			place:        domain.PlaceEdge,
+			placement:    &domain.PlacementPolicy{Mode: domain.PlacementFallback, Places: []domain.Place{domain.PlaceEdge, domain.PlaceCloud}},
+			workers:      map[string]domain.Place{"cloud1": domain.PlaceCloud},
+			want:         "cloud1",
+			wantFallback: true,
+		},
+		{
+			name:      "fallback without workers",
+			place:     domain.PlaceEdge,
+			placement: &domain.PlacementPolicy{Mode: domain.PlacementFallback, Places: []domain.Place{domain.PlaceEdge, domain.PlaceCloud}},
+			workers:   map[string]domain.Place{"device1": domain.PlaceDevice},
+		},
+		{
+			name:      "strict does not fall back",
+			place:     domain.PlaceEdge,
+			placement: &domain.PlacementPolicy{Mode: domain.PlacementStrict},
+			workers:   map[string]domain.Place{"cloud1": domain.PlaceCloud},
+		},
+		{
+			name:        "queue waits for a worker",
+			place:       domain.PlaceEdge,
+			placement:   &domain.PlacementPolicy{Mode: domain.PlacementQueue, Timeout: "10s"},
+			workers:     map[string]domain.Place{"cloud1": domain.PlaceCloud},
+			lateWorkers: map[string]domain.Place{"edge1": domain.PlaceEdge},
+			want:        "edge1",
+			wantWaited:  true,
+		},
+		{
+			name:      "queue times out",
+			place:     domain.PlaceEdge,
+			placement: &domain.PlacementPolicy{Mode: domain.PlacementQueue, Timeout: "1s"},
+			workers:   map[string]domain.Place{"cloud1": domain.PlaceCloud},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			m := newTestMaster(t)
+			for name, place := range tt.workers {
+				if _, err := m.AddWorker(ctx, newPlacementTestWorker(t, name, place)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			step := newSchedulingStep(tt.place, "")
+			step.Placement = tt.placement
+			wf := &domain.Workflow{ID: "flow", Name: "flow", Version: 1, Steps: []*domain.Step{step}}
+			if err := m.workflowRepository.Set(ctx, wf.ID, wf); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.runRepository.Set(ctx, &domain.Run{ID: "run", WorkflowID: wf.ID, WorkflowVersion: 1}); err != nil {
+				t.Fatal(err)
+			}
+			late := make([]*worker.Worker, 0, len(tt.lateWorkers))
+			for name, place := range tt.lateWorkers {
+				late = append(late, newPlacementTestWorker(t, name, place))
+			}
+			time.AfterFunc(500*time.Millisecond, func() {
+				for _, w := range late {
+					if _, err := m.AddWorker(ctx, w); err != nil {
+						t.Error(err)
+					}
+				}
+			})
+
+			got, err := m.DetermineNextJobWorker(ctx, &OptionsDetermineNextJobWorker{
+				WorkflowID: wf.ID,
+				StepID:     step.ID,
+				RunID:      "run",
+			})
+			if tt.want == "" {
+				if err != ErrMatchedWorkerNotFound {
+					t.Fatalf("want %v, got %v %v", ErrMatchedWorkerNotFound, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Name != tt.want {
+				t.Errorf("want %s, got %s", tt.want, got.Name)
+			}
+
+			run, err := m.runRepository.Get(ctx, "run")
+			if err != nil {
+				t.Fatal(err)
+			}
+			// fallbackかqueueで待った時だけrunに残す
+			if !tt.wantFallback && !tt.wantWaited {
+				if len(run.Placements) != 0 {
+					t.Errorf("want no placements recorded, got %d", len(run.Placements))
+				}
+				return
+			}
+			if len(run.Placements) != 1 {
+				t.Fatalf("want 1 placement recorded, got %d", len(run.Placements))
+			}
+			p := run.Placements[0]
+			if p.WorkerID != got.ID || p.Fallback != tt.wantFallback || (p.Waited > 0) != tt.wantWaited {
+				t.Errorf("placement: want worker %s, fallback %t, waited %t, got %+v", got.ID, tt.wantFallback, tt.wantWaited, p)
+			}
+		})
+	}
+}
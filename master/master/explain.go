@@ -0,0 +1,97 @@
+package master
+
+import (
+	"context"
+	"time"
+
+	"github.com/mobmob912/takuhai/domain"
+
+	"github.com/mobmob912/takuhai/master/worker"
+)
+
+// 配置の決め方
+type DecidedBy string
+
+const (
+	// フィルタとスコアプラグインの点数
+	DecidedByScores DecidedBy = "scores"
+	// place: anyのステップで、完了までの時間の予測
+	DecidedByPrediction DecidedBy = "prediction"
+	// 合流するステップで、runの中で既に決まっていた
+	DecidedByJoin DecidedBy = "join"
+)
+
+// ステップの配置の判断の内訳
+type PlacementExplanation struct {
+	WorkflowID          string `json:"workflow_id"`
+	StepID              string `json:"step_id"`
+	StepName            string `json:"step_name"`
+	PreviousJobWorkerID string `json:"previous_job_worker_id"`
+	// 選ばれたワーカー. 見つからなければ空
+	WorkerID   string    `json:"worker_id"`
+	WorkerName string    `json:"worker_name"`
+	DecidedBy  DecidedBy `json:"decided_by"`
+	// ステップのplacementで、最後に試したplace. placementが無ければ空
+	Place domain.Place `json:"place,omitempty"`
+	// placementのfallbackで、placesの最初以外に配置した
+	Fallback bool `json:"fallback,omitempty"`
+	// placementのqueueで、ワーカーが見つかるまで待った時間
+	Waited  time.Duration        `json:"waited,omitempty"`
+	Workers []*WorkerExplanation `json:"workers,omitempty"`
+}
+
+func newPlacementExplanation(step *domain.Step, opts *OptionsDetermineNextJobWorker, workers []*WorkerExplanation) *PlacementExplanation {
+	return &PlacementExplanation{
+		WorkflowID:          opts.WorkflowID,
+		StepID:              step.ID,
+		StepName:            step.Name,
+		PreviousJobWorkerID: opts.PreviousJobWorkerID,
+		Workers:             workers,
+	}
+}
+
+func (e *PlacementExplanation) setWorker(wk *worker.Worker) {
+	if wk == nil {
+		e.WorkerID, e.WorkerName = "", ""
+		return
+	}
+	e.WorkerID, e.WorkerName = wk.ID, wk.Name
+}
+
+func (e *PlacementExplanation) setPredictions(estimates []*completionEstimate) {
+	for _, est := range estimates {
+		for _, w := range e.Workers {
+			if w.WorkerID == est.worker.ID {
+				w.Prediction = est.prediction
+			}
+		}
+	}
+}
+
+// 実際には配置せずに、どのワーカーが選ばれるかとその理由を返す
+// 合流するステップの決定済みのワーカーは参照するだけで、消費しない
+func (m *Master) ExplainPlacement(ctx context.Context, opts *OptionsDetermineNextJobWorker) (*PlacementExplanation, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	step, err := m.getStepOfVersion(ctx, opts.WorkflowID, opts.StepID, opts.WorkflowVersion)
+	if err != nil {
+		return nil, err
+	}
+	_, e, err := m.placeStep(ctx, step, opts)
+	if err != nil {
+		return nil, err
+	}
+	if !step.IsJoin() || opts.RunID == "" {
+		return e, nil
+	}
+	if workerID, ok := m.joinPlacements.peek(opts.RunID, step.ID); ok {
+		wk, err := m.workerRepository.Get(ctx, workerID)
+		if err != nil {
+			return nil, err
+		}
+		e.setWorker(wk)
+		e.DecidedBy = DecidedByJoin
+	}
+	return e, nil
+}
@@ -0,0 +1,88 @@
+package master
+
+import (
+	"sync"
+	"time"
+)
+
+// 親ステップが問い合わせないまま止まった時に、決めたワーカーを忘れるまでの時間
+// ワーカーの待ち合わせのデフォルト(--joinTimeout)と同じ
+const joinPlacementTTL = time.Hour
+
+// 合流するステップ(afterが複数)は、全ての親ステップの結果が同じワーカーに届かないと
+// 待ち合わせができないので、run毎に一度決めたワーカーを覚えておく
+type joinPlacements struct {
+	mutex      *sync.Mutex
+	placements map[string]*joinPlacement
+}
+
+type joinPlacement struct {
+	workerID string
+	// まだワーカーを問い合わせていない親ステップの数
+	remaining int
+	expiresAt time.Time
+}
+
+func newJoinPlacements() *joinPlacements {
+	return &joinPlacements{
+		mutex:      new(sync.Mutex),
+		placements: make(map[string]*joinPlacement),
+	}
+}
+
+func joinPlacementKey(runID, stepID string) string {
+	return runID + "/" + stepID
+}
+
+// 決定済みのワーカーを返す。全ての親から問い合わせられたら忘れる
+func (p *joinPlacements) get(runID, stepID string) (string, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.deleteExpired(time.Now())
+	key := joinPlacementKey(runID, stepID)
+	jp, ok := p.placements[key]
+	if !ok {
+		return "", false
+	}
+	jp.remaining--
+	if jp.remaining <= 0 {
+		delete(p.placements, key)
+	}
+	return jp.workerID, true
+}
+
+// getと違い、問い合わせた回数に数えない
+func (p *joinPlacements) peek(runID, stepID string) (string, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.deleteExpired(time.Now())
+	jp, ok := p.placements[joinPlacementKey(runID, stepID)]
+	if !ok {
+		return "", false
+	}
+	return jp.workerID, true
+}
+
+func (p *joinPlacements) set(runID, stepID, workerID string, parentCount int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if parentCount <= 1 {
+		return
+	}
+	now := time.Now()
+	p.deleteExpired(now)
+	p.placements[joinPlacementKey(runID, stepID)] = &joinPlacement{
+		workerID:  workerID,
+		remaining: parentCount - 1,
+		expiresAt: now.Add(joinPlacementTTL),
+	}
+}
+
+// 失敗やタイムアウトで問い合わせなかった親ステップの分が残らないようにする
+func (p *joinPlacements) deleteExpired(now time.Time) {
+	for key, jp := range p.placements {
+		if now.After(jp.expiresAt) {
+			delete(p.placements, key)
+		}
+	}
+}
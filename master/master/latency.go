@@ -0,0 +1,48 @@
+package master
+
+import (
+	"context"
+	"time"
+
+	"github.com/mobmob912/takuhai/master/worker"
+)
+
+// ワーカーが計測した他のワーカーとのレイテンシを記録する
+// ワーカーのLatencyは、計測した相手との平均にする
+func (m *Master) RecordLatencies(ctx context.Context, fromID string, latencies map[string]time.Duration) error {
+	if _, err := m.workerRepository.Get(ctx, fromID); err != nil {
+		return err
+	}
+	now := time.Now()
+	var total time.Duration
+	for toID, d := range latencies {
+		if err := m.latencyRepository.Set(ctx, &worker.Latency{
+			FromID:     fromID,
+			ToID:       toID,
+			Latency:    d,
+			MeasuredAt: now,
+		}); err != nil {
+			return err
+		}
+		total += d
+	}
+	if len(latencies) == 0 {
+		return nil
+	}
+	return m.updateWorker(ctx, fromID, func(w *worker.Worker) error {
+		w.Latency = total / time.Duration(len(latencies))
+		return nil
+	})
+}
+
+func (m *Master) ListLatencies(ctx context.Context) ([]*worker.Latency, error) {
+	return m.latencyRepository.ListAll(ctx)
+}
+
+func (m *Master) latencyMatrix(ctx context.Context) (worker.LatencyMatrix, error) {
+	ls, err := m.latencyRepository.ListAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return worker.NewLatencyMatrix(ls), nil
+}
@@ -0,0 +1,161 @@
+package master
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"github.com/mobmob912/takuhai/master/worker"
+)
+
+// masterの設定ファイルのlease. 空ならデフォルトの値 (ex: 1s, 30s)
+type LeaseConfig struct {
+	// health checkの間隔. デフォルトは1s
+	Interval string `yaml:"interval"`
+	// 最後のハートビートからこれだけ経つとsuspect. デフォルトは5s
+	SuspectAfter string `yaml:"suspect_after"`
+	// unreachableにして配置しなくなるまで. デフォルトは30s
+	UnreachableAfter string `yaml:"unreachable_after"`
+	// removedにしてhealth checkをやめるまで. デフォルトは24h. 0ならremovedにしない
+	RemoveAfter string `yaml:"remove_after"`
+}
+
+// ワーカーのハートビートのリース
+// health checkの成功とワーカーからのリソースの報告をハートビートとして扱う
+type Lease struct {
+	interval         time.Duration
+	suspectAfter     time.Duration
+	unreachableAfter time.Duration
+	removeAfter      time.Duration
+}
+
+func DefaultLease() *Lease {
+	return &Lease{
+		interval:         time.Second,
+		suspectAfter:     5 * time.Second,
+		unreachableAfter: 30 * time.Second,
+		removeAfter:      24 * time.Hour,
+	}
+}
+
+func NewLease(c *LeaseConfig) (*Lease, error) {
+	l := DefaultLease()
+	if c == nil {
+		return l, nil
+	}
+	for _, d := range []struct {
+		name  string
+		value string
+		dst   *time.Duration
+	}{
+		{"interval", c.Interval, &l.interval},
+		{"suspect_after", c.SuspectAfter, &l.suspectAfter},
+		{"unreachable_after", c.UnreachableAfter, &l.unreachableAfter},
+		{"remove_after", c.RemoveAfter, &l.removeAfter},
+	} {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lease %s %s", d.name, d.value)
+		}
+		if v < 0 {
+			return nil, fmt.Errorf("lease %s must not be negative", d.name)
+		}
+		*d.dst = v
+	}
+	if l.interval == 0 {
+		return nil, fmt.Errorf("lease interval must be positive")
+	}
+	if l.suspectAfter == 0 || l.unreachableAfter < l.suspectAfter {
+		return nil, fmt.Errorf("lease must be 0 < suspect_after <= unreachable_after")
+	}
+	if l.removeAfter != 0 && l.removeAfter < l.unreachableAfter {
+		return nil, fmt.Errorf("lease remove_after must be 0 or not shorter than unreachable_after")
+	}
+	return l, nil
+}
+
+// 最後のハートビートからの経過時間で決まる状態
+func (l *Lease) stateAfter(elapsed time.Duration) worker.State {
+	switch {
+	case l.removeAfter != 0 && elapsed >= l.removeAfter:
+		return worker.StateRemoved
+	case elapsed >= l.unreachableAfter:
+		return worker.StateUnreachable
+	case elapsed >= l.suspectAfter:
+		return worker.StateSuspect
+	}
+	return worker.StateReady
+}
+
+// health checkの結果でリースを更新し、更新後の状態を返す
+// checkErrがnilならハートビートとして扱う
+func (m *Master) renewWorkerLease(ctx context.Context, id string, checkErr error, now time.Time) (worker.State, error) {
+	state := worker.StateReady
+	err := m.updateWorker(ctx, id, func(w *worker.Worker) error {
+		if w.CurrentState() == worker.StateRemoved {
+			return ErrWorkerRemoved
+		}
+		if checkErr == nil {
+			w.LastHeartbeatAt = now
+		} else {
+			// masterが止まっていた間はワーカーのせいではないので、起動した時刻から数える
+			since := w.LastHeartbeatAt
+			if since.Before(m.startedAt) {
+				since = m.startedAt
+			}
+			state = m.lease.stateAfter(now.Sub(since))
+		}
+		if w.SetState(state, now) {
+			log.Printf("worker state changed. name: %s, state: %s", w.Name, state)
+			if checkErr != nil {
+				log.Printf("last health check error. name: %s, msg: %s", w.Name, checkErr.Error())
+			}
+		}
+		return nil
+	})
+	if err == ErrWorkerRemoved {
+		return worker.StateRemoved, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return state, nil
+}
+
+// 基本goroutineで動かす. ワーカーが消されるかremovedになるまで続ける
+func (m *Master) PeriodicWorkerHealthCheck(ctx context.Context, w *worker.Worker) {
+	ticker := time.NewTicker(m.lease.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		// 登録し直してURLが変わっていることがあるので、毎回読み直す
+		cur, err := m.workerRepository.Get(ctx, w.ID)
+		if err == repository.ErrNotFound {
+			return
+		}
+		if err != nil {
+			log.Printf("worker lease update failed. id: %s. msg: %s", w.ID, err.Error())
+			continue
+		}
+		state, err := m.renewWorkerLease(ctx, w.ID, healthCheck(*cur.URL), time.Now())
+		if err == repository.ErrNotFound {
+			return
+		}
+		if err != nil {
+			log.Printf("worker lease update failed. id: %s. msg: %s", w.ID, err.Error())
+			continue
+		}
+		if state == worker.StateRemoved {
+			return
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package master
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mobmob912/takuhai/master/worker"
+)
+
+func TestLeaseStateAfter(t *testing.T) {
+	neverRemove, err := NewLease(&LeaseConfig{RemoveAfter: "0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		lease   *Lease
+		elapsed time.Duration
+		want    worker.State
+	}{
+		{name: "just beat", lease: DefaultLease(), elapsed: 0, want: worker.StateReady},
+		{name: "before suspect", lease: DefaultLease(), elapsed: 5*time.Second - time.Millisecond, want: worker.StateReady},
+		{name: "suspect", lease: DefaultLease(), elapsed: 5 * time.Second, want: worker.StateSuspect},
+		{name: "unreachable", lease: DefaultLease(), elapsed: 30 * time.Second, want: worker.StateUnreachable},
+		{name: "removed", lease: DefaultLease(), elapsed: 24 * time.Hour, want: worker.StateRemoved},
+		{name: "never removed", lease: neverRemove, elapsed: 365 * 24 * time.Hour, want: worker.StateUnreachable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.lease.stateAfter(tt.elapsed); got != tt.want {
+				t.Errorf("want %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRenewWorkerLease(t *testing.T) {
+	now := time.Now()
+	checkErr := errors.New("connection refused")
+	tests := []struct {
+		name string
+		from worker.State
+		// 最後のハートビートとmasterが起動した時刻の、nowからの経過時間
+		sinceHeartbeat time.Duration
+		sinceStarted   time.Duration
+		checkErr       error
+		want           worker.State
+	}{
+		{name: "heartbeat keeps ready", from: worker.StateReady, sinceHeartbeat: time.Minute, sinceStarted: time.Hour, want: worker.StateReady},
+		{name: "heartbeat recovers suspect", from: worker.StateSuspect, sinceHeartbeat: 10 * time.Second, sinceStarted: time.Hour, want: worker.StateReady},
+		{name: "heartbeat recovers unreachable", from: worker.StateUnreachable, sinceHeartbeat: time.Hour, sinceStarted: 2 * time.Hour, want: worker.StateReady},
+		{name: "failed check within suspect_after", from: worker.StateReady, sinceHeartbeat: time.Second, sinceStarted: time.Hour, checkErr: checkErr, want: worker.StateReady},
+		{name: "failed check becomes suspect", from: worker.StateReady, sinceHeartbeat: 10 * time.Second, sinceStarted: time.Hour, checkErr: checkErr, want: worker.StateSuspect},
+		{name: "failed check becomes unreachable", from: worker.StateSuspect, sinceHeartbeat: time.Minute, sinceStarted: time.Hour, checkErr: checkErr, want: worker.StateUnreachable},
+		{name: "failed check becomes removed", from: worker.StateUnreachable, sinceHeartbeat: 25 * time.Hour, sinceStarted: 48 * time.Hour, checkErr: checkErr, want: worker.StateRemoved},
+		// masterが止まっていた間は数えない
+		{name: "master was down", from: worker.StateReady, sinceHeartbeat: 25 * time.Hour, sinceStarted: time.Second, checkErr: checkErr, want: worker.StateReady},
+		{name: "removed stays removed", from: worker.StateRemoved, sinceHeartbeat: 25 * time.Hour, sinceStarted: 48 * time.Hour, want: worker.StateRemoved},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			m := newTestMaster(t)
+			m.lease = DefaultLease()
+			m.startedAt = now.Add(-tt.sinceStarted)
+			id, err := m.AddWorker(ctx, newTestWorker(t, "edge1", "http://10.0.0.1:8080"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := m.updateWorker(ctx, id, func(w *worker.Worker) error {
+				w.LastHeartbeatAt = now.Add(-tt.sinceHeartbeat)
+				w.SetState(tt.from, now.Add(-tt.sinceHeartbeat))
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := m.renewWorkerLease(ctx, id, tt.checkErr, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("want %s, got %s", tt.want, got)
+			}
+			w, err := m.workerRepository.Get(ctx, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w.CurrentState() != tt.want {
+				t.Errorf("stored state: want %s, got %s", tt.want, w.CurrentState())
+			}
+			if tt.checkErr == nil && tt.from != worker.StateRemoved && !w.LastHeartbeatAt.Equal(now) {
+				t.Errorf("last heartbeat: want %v, got %v", now, w.LastHeartbeatAt)
+			}
+		})
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/mobmob912/takuhai/domain"
@@ -23,16 +24,64 @@ import (
 )
 
 type Master struct {
-	workerRepository   repository.Worker
-	workflowRepository repository.Workflow
-	uidGenerator       repository.UID
+	workerRepository    repository.Worker
+	workflowRepository  repository.Workflow
+	scheduleRepository  repository.Schedule
+	runRepository       repository.Run
+	latencyRepository   repository.Latency
+	telemetryRepository repository.Telemetry
+	uidGenerator        repository.UID
+	scheduler           *Scheduler
+	joinPlacements      *joinPlacements
+	cron                *cronScheduler
+	runMutex            *sync.Mutex
+	lease               *Lease
+	// health checkとリソースの報告が同時にワーカーを書き換えないようにする
+	workerMutex *sync.Mutex
+	// drainのgoroutineが動いているワーカー. workerMutexで守る
+	drainingWorkers map[string]bool
+	startedAt       time.Time
 }
 
-func NewMaster(nr repository.Worker, wr repository.Workflow, uid repository.UID) *Master {
+type OptionsNewMaster struct {
+	WorkerRepository    repository.Worker
+	WorkflowRepository  repository.Workflow
+	ScheduleRepository  repository.Schedule
+	RunRepository       repository.Run
+	LatencyRepository   repository.Latency
+	TelemetryRepository repository.Telemetry
+	UIDGenerator        repository.UID
+	// nilならデフォルトのプラグインで配置を決める
+	Scheduler *Scheduler
+	// nilならDefaultLease
+	Lease *Lease
+}
+
+func NewMaster(opts *OptionsNewMaster) *Master {
+	scheduler := opts.Scheduler
+	if scheduler == nil {
+		scheduler, _ = NewScheduler(&OptionsNewScheduler{})
+	}
+	lease := opts.Lease
+	if lease == nil {
+		lease = DefaultLease()
+	}
 	return &Master{
-		workerRepository:   nr,
-		workflowRepository: wr,
-		uidGenerator:       uid,
+		workerRepository:    opts.WorkerRepository,
+		workflowRepository:  opts.WorkflowRepository,
+		scheduleRepository:  opts.ScheduleRepository,
+		runRepository:       opts.RunRepository,
+		latencyRepository:   opts.LatencyRepository,
+		telemetryRepository: opts.TelemetryRepository,
+		uidGenerator:        opts.UIDGenerator,
+		scheduler:           scheduler,
+		joinPlacements:      newJoinPlacements(),
+		cron:                newCronScheduler(),
+		runMutex:            new(sync.Mutex),
+		lease:               lease,
+		workerMutex:         new(sync.Mutex),
+		drainingWorkers:     make(map[string]bool),
+		startedAt:           time.Now(),
 	}
 }
 
@@ -45,8 +94,25 @@ func (m *Master) Init(ctx context.Context) error {
 		return err
 	}
 	for _, w := range ws {
+		if w.CurrentState() == worker.StateRemoved {
+			continue
+		}
 		go m.PeriodicWorkerHealthCheck(ctx, w)
 	}
+	wfs, err := m.workflowRepository.ListAll(ctx)
+	if err != nil {
+		return err
+	}
+	// 1つのワークフローが壊れていても、他のワークフローとmasterは動かす
+	for _, wf := range wfs {
+		if err := m.addInitialRevision(ctx, wf); err != nil {
+			log.Printf("failed to record revision of workflow %s. msg: %s", wf.Name, err.Error())
+		}
+		if err := m.ApplyWorkflow(ctx, wf); err != nil {
+			log.Printf("skip workflow %s. msg: %s", wf.Name, err.Error())
+		}
+	}
+	go m.PeriodicFireCronWorkflows(ctx)
 	return nil
 }
 
@@ -59,23 +125,73 @@ func (m *Master) GetWorkerByID(ctx context.Context, id string) (*worker.Worker,
 }
 
 func (m *Master) AddWorker(ctx context.Context, n *worker.Worker) (string, error) {
-	ns, err := m.workerRepository.ListAll(ctx)
+	ns, err := m.removeRemovedWorker(ctx, n.Name)
 	if err != nil {
 		return "", err
 	}
 	if err := n.Validate(ns); err != nil {
 		return "", err
 	}
-	if exist := CheckWorkerExistByName(ns, n.Name); exist {
-		return "", errors.New(fmt.Sprintf("Worker name %s is exist", n.Name))
+	// 再起動したワーカーマネージャは、suspectやunreachableのままの前の記録を使い直す
+	for _, pw := range ns {
+		if pw.Name == n.Name {
+			return pw.ID, m.reregisterWorker(ctx, pw.ID, n)
+		}
 	}
 	//if err := healthCheck(*n.URL); err != nil {
 	//	return "", errors.New("health check error. may be worker manager is not working or addr is invalid")
 	//}
 	id := xid.New().String()
 	n.ID = id
+	now := time.Now()
+	n.LastHeartbeatAt = now
+	n.SetState(worker.StateReady, now)
+	if err := m.workerRepository.Set(ctx, id, n); err != nil {
+		return "", err
+	}
 	go m.PeriodicWorkerHealthCheck(context.Background(), n)
-	return id, m.workerRepository.Set(ctx, id, n)
+	return id, nil
+}
+
+// removedになったワーカーは同じ名前で登録し直せるように消す. 消した後の全ワーカーを返す
+func (m *Master) removeRemovedWorker(ctx context.Context, name string) ([]*worker.Worker, error) {
+	ws, err := m.workerRepository.ListAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	rest := make([]*worker.Worker, 0, len(ws))
+	for _, w := range ws {
+		if w.Name == name && w.CurrentState() == worker.StateRemoved {
+			if err := m.DeleteWorker(ctx, w.ID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		rest = append(rest, w)
+	}
+	return rest, nil
+}
+
+// 登録し直したワーカーのURLと環境で置き換えて、readyにする
+// health checkのgoroutineは前の記録のものが動き続けている
+func (m *Master) reregisterWorker(ctx context.Context, id string, n *worker.Worker) error {
+	return m.updateWorker(ctx, id, func(pw *worker.Worker) error {
+		pw.URL = n.URL
+		pw.Type = n.Type
+		pw.Arch = n.Arch
+		pw.Labels = n.Labels
+		pw.Place = n.Place
+		pw.Parent = n.Parent
+		pw.CPUUsagePercent = n.CPUUsagePercent
+		pw.CPUClockMhz = n.CPUClockMhz
+		pw.AvailableMemory = n.AvailableMemory
+		now := time.Now()
+		pw.LastHeartbeatAt = now
+		if pw.SetState(worker.StateReady, now) {
+			log.Printf("worker state changed. name: %s, state: %s", pw.Name, worker.StateReady)
+		}
+		return nil
+	})
 }
 
 func CheckWorkerExistByName(ws []*worker.Worker, name string) bool {
@@ -88,8 +204,8 @@ func CheckWorkerExistByName(ws []*worker.Worker, name string) bool {
 }
 
 func healthCheck(u url.URL) error {
-	c := http.DefaultClient
-	c.Timeout = 3 * time.Second
+	// http.DefaultClientのTimeoutを書き換えると他のリクエストにも効いてしまう
+	c := &http.Client{Timeout: 3 * time.Second}
 	u.Path = "/check"
 	req, err := http.NewRequest("GET", u.String(), nil)
 	if err != nil {
@@ -99,21 +215,7 @@ func healthCheck(u url.URL) error {
 	return err
 }
 
-// 基本goroutineで動かす
-func (m *Master) PeriodicWorkerHealthCheck(ctx context.Context, w *worker.Worker) {
-	for {
-		time.Sleep(1 * time.Second)
-		if err := healthCheck(*w.URL); err != nil {
-			log.Printf("health check failed. worker name: %s. it will delete. msg: %s", w.Name, err.Error())
-			if err := m.DeleteWorker(ctx, w.ID); err != nil {
-				log.Printf("worker delete failed. id: %s. msg: ", w.ID, err.Error())
-				continue
-			}
-			break
-		}
-	}
-}
-
+// 失敗しても消さずにリースを更新する
 func (m *Master) HealthCheckAllWorkers(ctx context.Context) error {
 	ws, err := m.workerRepository.ListAll(ctx)
 	if err != nil {
@@ -122,13 +224,12 @@ func (m *Master) HealthCheckAllWorkers(ctx context.Context) error {
 	eg := &errgroup.Group{}
 	for _, w := range ws {
 		w := w
+		if w.CurrentState() == worker.StateRemoved {
+			continue
+		}
 		eg.Go(func() error {
-			if err := healthCheck(*w.URL); err != nil {
-				if err := m.DeleteWorker(ctx, w.ID); err != nil {
-					return err
-				}
-			}
-			return nil
+			_, err := m.renewWorkerLease(ctx, w.ID, healthCheck(*w.URL), time.Now())
+			return err
 		})
 	}
 	if err := eg.Wait(); err != nil {
@@ -137,21 +238,47 @@ func (m *Master) HealthCheckAllWorkers(ctx context.Context) error {
 	return nil
 }
 
-func (m *Master) UpdateWorkerResource(ctx context.Context, w *worker.Worker) error {
-	pw, err := m.workerRepository.Get(ctx, w.ID)
+var (
+	ErrWorkerRemoved = errors.New("worker is removed. register the worker again")
+)
+
+// ワーカーを書き換えて保存する. updateがエラーを返したら保存しない
+// ワーカーの読み込みから保存までをworkerMutexで守るので、ワーカーの書き換えは全てこれを通す
+func (m *Master) updateWorker(ctx context.Context, id string, update func(w *worker.Worker) error) error {
+	m.workerMutex.Lock()
+	defer m.workerMutex.Unlock()
+	w, err := m.workerRepository.Get(ctx, id)
 	if err != nil {
 		return err
 	}
-	pw.CPUUsagePercent = w.CPUUsagePercent
-	pw.CPUClockMhz = w.CPUClockMhz
-	pw.AvailableMemory = w.AvailableMemory
-	if err := m.workerRepository.Update(ctx, pw.ID, pw); err != nil {
+	if err := update(w); err != nil {
 		return err
 	}
-	return nil
+	return m.workerRepository.Update(ctx, id, w)
+}
+
+// リソースの報告もハートビートとして扱う
+func (m *Master) UpdateWorkerResource(ctx context.Context, w *worker.Worker) error {
+	return m.updateWorker(ctx, w.ID, func(pw *worker.Worker) error {
+		if pw.CurrentState() == worker.StateRemoved {
+			return ErrWorkerRemoved
+		}
+		now := time.Now()
+		pw.LastHeartbeatAt = now
+		if pw.SetState(worker.StateReady, now) {
+			log.Printf("worker state changed. name: %s, state: %s", pw.Name, worker.StateReady)
+		}
+		pw.CPUUsagePercent = w.CPUUsagePercent
+		pw.CPUClockMhz = w.CPUClockMhz
+		pw.AvailableMemory = w.AvailableMemory
+		return nil
+	})
 }
 
 func (m *Master) DeleteWorker(ctx context.Context, id string) error {
+	if err := m.latencyRepository.DeleteByWorkerID(ctx, id); err != nil {
+		return err
+	}
 	return m.workerRepository.Delete(ctx, id)
 }
 
@@ -172,36 +299,21 @@ func (m *Master) AddWorkflow(ctx context.Context, wf *domain.Workflow) (string,
 		return "", ErrAlreadyRegistered
 	}
 
-	for i := range wf.Steps {
-		wf.Steps[i].ID = m.uidGenerator.New()
-	}
-
-	// set after by id
-	for i, w := range wf.Steps {
-		if w.After == "" {
-			continue
-		}
-		found := false
-		for _, aw := range wf.Steps {
-			if aw.Name != w.After {
-				continue
-			}
-			wf.Steps[i].AfterByID = aw.ID
-			found = true
-		}
-		if !found {
-			return "", errors.New("after step name is not found. " + w.After)
-		}
-	}
-
-	if err := wf.SetStepsJob(); err != nil {
+	if err := m.prepareWorkflow(ctx, wf, nil); err != nil {
 		return "", err
 	}
 
 	id := m.uidGenerator.New()
+	wf.Version = 1
 	if err := m.workflowRepository.Set(ctx, id, wf); err != nil {
 		return "", err
 	}
+	if err := m.workflowRepository.AddRevision(ctx, wf); err != nil {
+		return "", err
+	}
+	if err := m.ApplyWorkflow(ctx, wf); err != nil {
+		return "", err
+	}
 	// 各Workerが定期的にworkflow更新を問い合わせる形も考えたが、
 	// workflow更新頻度の少なさを考えるとそれじゃトラフィックを圧迫しそうなので
 	// Masterから通知する形にする
@@ -211,11 +323,81 @@ func (m *Master) AddWorkflow(ctx context.Context, wf *domain.Workflow) (string,
 	return id, nil
 }
 
+// 検証してから、ステップIDの採番とafter, jobの解決をする
+// prevが渡された時は、名前の変わっていないステップはprevと同じIDを引き継ぐ
+func (m *Master) prepareWorkflow(ctx context.Context, wf, prev *domain.Workflow) error {
+	platforms, err := m.listWorkerPlatforms(ctx)
+	if err != nil {
+		return err
+	}
+	if errs := wf.Validate(&domain.OptionsValidate{Platforms: platforms}); len(errs) != 0 {
+		return errs
+	}
+
+	stepIDs := make(map[string]string, len(wf.Steps))
+	// k=失敗したステップ名
+	failureIDs := make(map[string]string)
+	if prev != nil {
+		for _, s := range prev.Steps {
+			stepIDs[s.Name] = s.ID
+			if s.Failure != nil {
+				failureIDs[s.Name] = s.Failure.ID
+			}
+		}
+	}
+	for i, s := range wf.Steps {
+		id, ok := stepIDs[s.Name]
+		if !ok {
+			id = m.uidGenerator.New()
+		}
+		wf.Steps[i].ID = id
+		stepIDs[s.Name] = id
+		if s.Failure == nil {
+			continue
+		}
+		failureID, ok := failureIDs[s.Name]
+		if !ok {
+			failureID = m.uidGenerator.New()
+		}
+		s.Failure.ID = failureID
+	}
+
+	// set after by id
+	for i, s := range wf.Steps {
+		deps := wf.DependencyNames(s)
+		wf.Steps[i].AfterByID = make([]string, 0, len(deps))
+		for _, after := range deps {
+			wf.Steps[i].AfterByID = append(wf.Steps[i].AfterByID, stepIDs[after])
+		}
+	}
+
+	return wf.SetStepsJob()
+}
+
+// 登録済みワーカーの実行環境の一覧
+func (m *Master) listWorkerPlatforms(ctx context.Context) ([]*domain.Platform, error) {
+	ws, err := m.workerRepository.ListAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	platforms := make([]*domain.Platform, 0, len(ws))
+	for _, w := range ws {
+		platforms = append(platforms, &domain.Platform{
+			Type: w.Type,
+			Arch: w.Arch,
+		})
+	}
+	return platforms, nil
+}
+
+// トリガーの種類に応じてワークフローを開始できるようにする
+// httpトリガーは各ワーカーが受け付けるので、masterでは何もしない
 func (m *Master) ApplyWorkflow(ctx context.Context, wf *domain.Workflow) error {
 	switch wf.Trigger.Type {
-	// TODO cron type実装
 	case domain.TriggerTypeCron:
+		return m.registerCronWorkflow(ctx, wf)
 	case domain.TriggerTypeHTTP:
+		m.cron.remove(wf.ID)
 	}
 	return nil
 }
@@ -235,6 +417,10 @@ func (m *Master) NotifyWorkflowsToAllWorkers(ctx context.Context) error {
 	}
 	eg := errgroup.Group{}
 	for _, w := range ws {
+		// 届かないワーカーは戻ってからワークフローを取りに来る
+		if !w.Schedulable() {
+			continue
+		}
 		w := w
 		eg.Go(func() error {
 			w := w
@@ -263,30 +449,41 @@ func (m *Master) NotifyWorkflowsToAllWorkers(ctx context.Context) error {
 	return nil
 }
 
-func (m *Master) FailJob(ctx context.Context, workflowID, stepID string) error {
-	return nil
+// ワーカーから報告されたステップの失敗
+type StepFailure struct {
+	RunID           string
+	WorkflowVersion int
+	WorkerID        string
+	Reason          domain.RetryReason
+	Message         string
+	// 何回目の実行で失敗したか
+	Attempt int
+	// もうリトライしないならtrue
+	Final bool
 }
 
-// JobIDの整合性取れないので無理
-//func (m *Master) UpdateWorkflow(ctx context.Context, name string, wf *domain.Workflow) error {
-//	pwf, err := m.workflowRepository.GetByName(ctx, name)
-//	if err != nil {
-//		return err
-//	}
-//	wf.StepID = pwf.StepID
-//	if err := m.workflowRepository.Set(ctx, wf.StepID, wf); err != nil {
-//		return err
-//	}
-//	if err := m.NotifyWorkflowsToAllWorkers(ctx); err != nil {
-//		return err
-//	}
-//	return nil
-//}
+// ステップの失敗をrunの出来事として記録する
+func (m *Master) FailJob(ctx context.Context, workflowID, stepID string, f *StepFailure) error {
+	return m.RecordRunEvent(ctx, f.RunID, &domain.RunEvent{
+		Type:            domain.RunEventStepFailed,
+		WorkflowID:      workflowID,
+		WorkflowVersion: f.WorkflowVersion,
+		StepID:          stepID,
+		WorkerID:        f.WorkerID,
+		Attempt:         f.Attempt,
+		Message:         fmt.Sprintf("%s: %s", f.Reason, f.Message),
+		Final:           f.Final,
+		Time:            time.Now(),
+	})
+}
 
 type OptionsDetermineNextJobWorker struct {
 	WorkflowID          string
 	StepID              string
 	PreviousJobWorkerID string
+	RunID               string
+	// runが開始された時のワークフローのバージョン. 0なら現在のワークフロー
+	WorkflowVersion int
 }
 
 func (opt *OptionsDetermineNextJobWorker) Validate() error {
@@ -0,0 +1,102 @@
+package master
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/store/memory"
+	"github.com/mobmob912/takuhai/master/worker"
+)
+
+// health checkのgoroutineがテスト中に動かないように、間隔を長くしたmaster
+func newTestMaster(t *testing.T) *Master {
+	t.Helper()
+	lease, err := NewLease(&LeaseConfig{Interval: "1h", SuspectAfter: "1h", UnreachableAfter: "1h", RemoveAfter: "1h"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewMaster(&OptionsNewMaster{
+		WorkerRepository:    memory.NewWorker(),
+		WorkflowRepository:  memory.NewWorkflow(),
+		LatencyRepository:   memory.NewLatency(),
+		RunRepository:       memory.NewRun(),
+		TelemetryRepository: memory.NewTelemetry(),
+		Lease:               lease,
+	})
+}
+
+func newTestWorker(t *testing.T, name, rawURL string) *worker.Worker {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &worker.Worker{
+		Name:  name,
+		URL:   u,
+		Type:  domain.ImageTypeDocker,
+		Place: domain.PlaceEdge,
+	}
+}
+
+func TestAddWorkerAgain(t *testing.T) {
+	tests := []struct {
+		state     worker.State
+		wantReuse bool
+	}{
+		{state: worker.StateReady, wantReuse: true},
+		{state: worker.StateSuspect, wantReuse: true},
+		{state: worker.StateUnreachable, wantReuse: true},
+		{state: worker.StateRemoved, wantReuse: false},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.state), func(t *testing.T) {
+			ctx := context.Background()
+			m := newTestMaster(t)
+			id, err := m.AddWorker(ctx, newTestWorker(t, "edge1", "http://10.0.0.1:8080"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := m.updateWorker(ctx, id, func(w *worker.Worker) error {
+				w.SetState(tt.state, time.Now())
+				return nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			again := newTestWorker(t, "edge1", "http://10.0.0.2:8080")
+			again.Arch = domain.ArchType("arm64")
+			again.Labels = []string{"ssd"}
+			newID, err := m.AddWorker(ctx, again)
+			if err != nil {
+				t.Fatalf("add worker again: %v", err)
+			}
+			if got := newID == id; got != tt.wantReuse {
+				t.Errorf("reuse id: want %v, got %v", tt.wantReuse, got)
+			}
+			ws, err := m.Workers(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ws) != 1 {
+				t.Fatalf("want 1 worker, got %d", len(ws))
+			}
+			w := ws[0]
+			if w.ID != newID {
+				t.Errorf("id: want %s, got %s", newID, w.ID)
+			}
+			if w.CurrentState() != worker.StateReady {
+				t.Errorf("state: want %s, got %s", worker.StateReady, w.CurrentState())
+			}
+			if w.URL.String() != "http://10.0.0.2:8080" {
+				t.Errorf("url: want http://10.0.0.2:8080, got %s", w.URL)
+			}
+			if w.Arch != "arm64" || len(w.Labels) != 1 {
+				t.Errorf("platform: want arm64 [ssd], got %s %v", w.Arch, w.Labels)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package master
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/mobmob912/takuhai/domain"
+
+	"github.com/mobmob912/takuhai/master/worker"
+)
+
+// 予測に使う計測値の数. 新しい方から
+const predictionSampleLimit = 1000
+
+// ワーカー毎の、ステップの完了までにかかる時間の予測
+type CompletionPrediction struct {
+	Runtime  time.Duration `json:"runtime"`
+	Transfer time.Duration `json:"transfer"`
+	Total    time.Duration `json:"total"`
+}
+
+type completionEstimate struct {
+	worker     *worker.Worker
+	prediction *CompletionPrediction
+}
+
+// place: anyのステップは、過去の計測値から完了までの時間が最も短いワーカーを選ぶ
+// どの候補も予測できなければnilを返すので、スケジューラで決める
+func (m *Master) predictNextJobWorker(ctx context.Context, sc *SchedulingContext, wks []*worker.Worker) (*worker.Worker, []*completionEstimate, error) {
+	candidates, err := m.scheduler.filter(ctx, sc, wks)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(candidates) == 0 {
+		return nil, nil, nil
+	}
+	samples, err := m.telemetryRepository.ListByWorkflowID(ctx, sc.WorkflowID, predictionSampleLimit)
+	if err != nil {
+		return nil, nil, err
+	}
+	estimates := estimateCompletions(sc, candidates, wks, samples)
+	var best *completionEstimate
+	for _, e := range estimates {
+		if best == nil || e.prediction.Total < best.prediction.Total {
+			best = e
+		}
+	}
+	if best == nil {
+		return nil, estimates, nil
+	}
+	return best.worker, estimates, nil
+}
+
+// 同じワーカーでの実行時間の履歴が無い候補は、同じplaceのワーカー、全てのワーカーの順に履歴を使う
+// どのワーカーでも実行されていなければ予測しない
+func estimateCompletions(sc *SchedulingContext, candidates, wks []*worker.Worker, samples []*domain.TelemetrySample) []*completionEstimate {
+	places := make(map[string]domain.Place, len(wks))
+	for _, w := range wks {
+		places[w.ID] = w.Place
+	}
+	// 同じワーカーで実行した時の実行時間と、同じplaceのワーカーで実行した時の実行時間と、全ての実行時間
+	byWorker := make(map[string][]time.Duration)
+	byPlace := make(map[domain.Place][]time.Duration)
+	all := make([]time.Duration, 0)
+	// k=次のステップのワーカー名. 直前のワーカーから親ステップの出力を渡した時間
+	transfers := make(map[string][]time.Duration)
+	seen := make(map[string]bool)
+	for _, s := range samples {
+		if s.StepName == sc.Step.Name {
+			key := s.RunID + "/" + s.StepID + "/" + strconv.Itoa(s.Attempt)
+			if !seen[key] {
+				seen[key] = true
+				byWorker[s.FromWorkerID] = append(byWorker[s.FromWorkerID], s.Runtime)
+				all = append(all, s.Runtime)
+				if place, ok := places[s.FromWorkerID]; ok {
+					byPlace[place] = append(byPlace[place], s.Runtime)
+				}
+			}
+			continue
+		}
+		if s.HasTransfer() && s.FromWorkerID == sc.PreviousJobWorkerID && sc.Step.HasParent(s.StepID) {
+			transfers[s.ToWorkerName] = append(transfers[s.ToWorkerName], s.TransferTime)
+		}
+	}
+
+	estimates := make([]*completionEstimate, 0, len(candidates))
+	for _, w := range candidates {
+		runtimes := byWorker[w.ID]
+		if len(runtimes) == 0 {
+			runtimes = byPlace[w.Place]
+		}
+		if len(runtimes) == 0 {
+			runtimes = all
+		}
+		if len(runtimes) == 0 {
+			continue
+		}
+		p := &CompletionPrediction{
+			Runtime:  median(runtimes),
+			Transfer: estimateTransfer(sc, w, transfers[w.Name]),
+		}
+		p.Total = p.Runtime + p.Transfer
+		estimates = append(estimates, &completionEstimate{
+			worker:     w,
+			prediction: p,
+		})
+	}
+	return estimates
+}
+
+// 同じワーカーなら0. 転送の履歴が無ければワーカー間のレイテンシを使う
+func estimateTransfer(sc *SchedulingContext, w *worker.Worker, transfers []time.Duration) time.Duration {
+	if sc.PreviousJobWorkerID == "" || sc.PreviousJobWorkerID == w.ID {
+		return 0
+	}
+	if len(transfers) != 0 {
+		return median(transfers)
+	}
+	if sc.Latencies == nil {
+		return 0
+	}
+	d, ok := sc.Latencies.Get(sc.PreviousJobWorkerID, w.ID)
+	if !ok {
+		return sc.Latencies.Max()
+	}
+	return d
+}
+
+func median(ds []time.Duration) time.Duration {
+	return domain.NewDurationPercentiles(ds).P50
+}
@@ -0,0 +1,101 @@
+package master
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/worker"
+)
+
+func newRuntimeSamples(stepName, workerID string, runtimes ...time.Duration) []*domain.TelemetrySample {
+	ss := make([]*domain.TelemetrySample, len(runtimes))
+	for i, d := range runtimes {
+		ss[i] = &domain.TelemetrySample{
+			RunID:        workerID + "-" + strconv.Itoa(i),
+			StepID:       stepName,
+			StepName:     stepName,
+			Attempt:      1,
+			FromWorkerID: workerID,
+			Runtime:      d,
+		}
+	}
+	return ss
+}
+
+func TestEstimateCompletions(t *testing.T) {
+	wks := []*worker.Worker{
+		newSchedulingWorker("edge1", domain.PlaceEdge, gib),
+		newSchedulingWorker("edge2", domain.PlaceEdge, gib),
+		newSchedulingWorker("cloud1", domain.PlaceCloud, gib),
+		newSchedulingWorker("device1", domain.PlaceDevice, gib),
+	}
+	edge1History := newRuntimeSamples("step", "edge1", time.Second, 2*time.Second, 3*time.Second)
+	deviceHistory := newRuntimeSamples("step", "device1", 10*time.Second, 20*time.Second)
+	otherStep := newRuntimeSamples("other", "cloud1", time.Millisecond)
+
+	tests := []struct {
+		name    string
+		samples []*domain.TelemetrySample
+		// k=ワーカーID, v=予測した実行時間. 無いワーカーは予測しない
+		want map[string]time.Duration
+	}{
+		{
+			name:    "no history",
+			samples: otherStep,
+			want:    map[string]time.Duration{},
+		},
+		{
+			// edge1は自分の履歴、edge2はedgeの履歴、cloud1は全てのワーカーの履歴を使う
+			name:    "fallback to place and fleet median",
+			samples: append(append(append([]*domain.TelemetrySample{}, edge1History...), deviceHistory...), otherStep...),
+			want: map[string]time.Duration{
+				"edge1":  2 * time.Second,
+				"edge2":  2 * time.Second,
+				"cloud1": 3 * time.Second,
+			},
+		},
+		{
+			name:    "fleet median only",
+			samples: deviceHistory,
+			want: map[string]time.Duration{
+				"edge1":  10 * time.Second,
+				"edge2":  10 * time.Second,
+				"cloud1": 10 * time.Second,
+			},
+		},
+		{
+			// 同じ試行の記録が重なっても1回と数える
+			name:    "duplicated samples",
+			samples: append(append(newRuntimeSamples("step", "edge1", time.Second), newRuntimeSamples("step", "edge2", 5*time.Second)...), newRuntimeSamples("step", "edge2", 5*time.Second)...),
+			want: map[string]time.Duration{
+				"edge1":  time.Second,
+				"edge2":  5 * time.Second,
+				"cloud1": time.Second,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sc := &SchedulingContext{Step: newSchedulingStep(domain.PlaceAny, "")}
+			candidates := wks[:3]
+			estimates := estimateCompletions(sc, candidates, wks, tt.samples)
+			got := make(map[string]time.Duration, len(estimates))
+			for _, e := range estimates {
+				got[e.worker.ID] = e.prediction.Runtime
+				if e.prediction.Total != e.prediction.Runtime {
+					t.Errorf("%s: want no transfer for the first step, got %v", e.worker.ID, e.prediction.Transfer)
+				}
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+			for id, d := range tt.want {
+				if got[id] != d {
+					t.Errorf("%s: want %v, got %v", id, d, got[id])
+				}
+			}
+		})
+	}
+}
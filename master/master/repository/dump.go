@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/worker"
+)
+
+// masterのリポジトリ一式
+type Repositories struct {
+	Worker    Worker
+	Workflow  Workflow
+	Schedule  Schedule
+	Run       Run
+	Latency   Latency
+	Telemetry Telemetry
+}
+
+// リポジトリの全てのデータ. 別のバックエンドへ移すのに使う
+type Dump struct {
+	Workflows []*domain.Workflow `json:"workflows"`
+	Revisions []*domain.Workflow `json:"revisions"`
+	// k=ワークフローID, v=cronトリガーの最終実行時刻
+	Schedules map[string]time.Time      `json:"schedules"`
+	Runs      []*domain.Run             `json:"runs"`
+	Workers   []*worker.Worker          `json:"workers"`
+	Latencies []*worker.Latency         `json:"latencies"`
+	Telemetry []*domain.TelemetrySample `json:"telemetry"`
+}
+
+// ワークフローに紐づくデータは、ワークフロー毎に全て読む
+func Export(ctx context.Context, r *Repositories) (*Dump, error) {
+	wfs, err := r.Workflow.ListAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d := &Dump{
+		Workflows: wfs,
+		Revisions: make([]*domain.Workflow, 0),
+		Schedules: make(map[string]time.Time),
+		Runs:      make([]*domain.Run, 0),
+		Telemetry: make([]*domain.TelemetrySample, 0),
+	}
+	for _, wf := range wfs {
+		revisions, err := r.Workflow.ListRevisions(ctx, wf.ID)
+		if err != nil {
+			return nil, err
+		}
+		d.Revisions = append(d.Revisions, revisions...)
+
+		t, err := r.Schedule.GetLastFiredAt(ctx, wf.ID)
+		if err != nil && err != ErrNotFound {
+			return nil, err
+		}
+		if err == nil {
+			d.Schedules[wf.ID] = t
+		}
+
+		runs, err := r.Run.ListByWorkflowID(ctx, wf.ID, 0)
+		if err != nil {
+			return nil, err
+		}
+		d.Runs = append(d.Runs, runs...)
+
+		samples, err := r.Telemetry.ListByWorkflowID(ctx, wf.ID, 0)
+		if err != nil {
+			return nil, err
+		}
+		d.Telemetry = append(d.Telemetry, samples...)
+	}
+	if d.Workers, err = r.Worker.ListAll(ctx); err != nil {
+		return nil, err
+	}
+	if d.Latencies, err = r.Latency.ListAll(ctx); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// 空のリポジトリに入れることを想定している. 既にあるワーカーと同じIDがあると重複することがある
+func Import(ctx context.Context, r *Repositories, d *Dump) error {
+	for _, wf := range d.Workflows {
+		if err := r.Workflow.Set(ctx, wf.ID, wf); err != nil {
+			return err
+		}
+	}
+	for _, wf := range d.Revisions {
+		if err := r.Workflow.AddRevision(ctx, wf); err != nil {
+			return err
+		}
+	}
+	for id, t := range d.Schedules {
+		if err := r.Schedule.SetLastFiredAt(ctx, id, t); err != nil {
+			return err
+		}
+	}
+	for _, run := range d.Runs {
+		if err := r.Run.Set(ctx, run); err != nil {
+			return err
+		}
+	}
+	for _, w := range d.Workers {
+		if err := r.Worker.Set(ctx, w.ID, w); err != nil {
+			return err
+		}
+	}
+	for _, l := range d.Latencies {
+		if err := r.Latency.Set(ctx, l); err != nil {
+			return err
+		}
+	}
+	// 新しい順に出しているので、古い順に入れる
+	for i := len(d.Telemetry) - 1; i >= 0; i-- {
+		if err := r.Telemetry.Add(ctx, d.Telemetry[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
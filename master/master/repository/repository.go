@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/mobmob912/takuhai/domain"
 	"github.com/mobmob912/takuhai/master/worker"
@@ -12,24 +13,68 @@ var (
 	ErrNotFound = errors.New("record not found")
 )
 
+// 見つからなければErrNotFoundを返す. 実装はrepositorytestのテストを通ること
 type Workflow interface {
 	Get(ctx context.Context, id string) (*domain.Workflow, error)
 	GetByName(ctx context.Context, name string) (*domain.Workflow, error)
+	// ワークフローかステップが無ければErrNotFound
 	GetStep(ctx context.Context, workflowID, stepID string) (*domain.Step, error)
+	// IDの順
 	ListAll(ctx context.Context) ([]*domain.Workflow, error)
 	CheckExistByByName(ctx context.Context, name string) (bool, error)
+	// 無ければ追加し、あれば置き換える. workflow.IDをidにする
 	Set(ctx context.Context, id string, workflow *domain.Workflow) error
+
+	// 更新前のワークフローもバージョン毎に残しておく
+	AddRevision(ctx context.Context, workflow *domain.Workflow) error
+	GetRevision(ctx context.Context, id string, version int) (*domain.Workflow, error)
+	// 古いバージョンから順に
+	ListRevisions(ctx context.Context, id string) ([]*domain.Workflow, error)
+}
+
+// cronトリガーの最終実行時刻. masterの再起動時に実行し損ねた分を判断するのに使う
+type Schedule interface {
+	GetLastFiredAt(ctx context.Context, workflowID string) (time.Time, error)
+	SetLastFiredAt(ctx context.Context, workflowID string, t time.Time) error
+}
+
+// ワークフローの実行履歴
+type Run interface {
+	Get(ctx context.Context, id string) (*domain.Run, error)
+	// 新しい順にlimit件まで. limitが0なら全て
+	ListByWorkflowID(ctx context.Context, workflowID string, limit int) ([]*domain.Run, error)
+	Set(ctx context.Context, run *domain.Run) error
 }
 
+// 見つからなければErrNotFoundを返す. 実装はrepositorytestのテストを通ること
 type Worker interface {
 	Get(ctx context.Context, id string) (*worker.Worker, error)
+	// IDの順
 	ListAll(ctx context.Context) ([]*worker.Worker, error)
 	ListClouds(ctx context.Context) ([]*worker.Worker, error)
+	// 無ければ追加し、あれば置き換える. worker.IDをidにする
 	Set(ctx context.Context, id string, worker *worker.Worker) error
+	// 無ければErrNotFound
 	Update(ctx context.Context, id string, worker *worker.Worker) error
+	// 無くてもエラーにしない
 	Delete(ctx context.Context, id string) error
 }
 
+// ステップの計測値
+type Telemetry interface {
+	Add(ctx context.Context, sample *domain.TelemetrySample) error
+	// 新しい順にlimit件まで. limitが0なら全て
+	ListByWorkflowID(ctx context.Context, workflowID string, limit int) ([]*domain.TelemetrySample, error)
+}
+
+// ワーカー間のレイテンシ. 計測した向き毎に最新の値だけを持つ
+type Latency interface {
+	ListAll(ctx context.Context) ([]*worker.Latency, error)
+	Set(ctx context.Context, latency *worker.Latency) error
+	// ワーカーが計測した分と、ワーカーまでの分を消す
+	DeleteByWorkerID(ctx context.Context, workerID string) error
+}
+
 // FlowAppが稼働しているWorkerを管理
 type Application interface {
 	FindDeployedWorker(ctx context.Context, flowID string) (*worker.Worker, error)
@@ -0,0 +1,111 @@
+package repositorytest
+
+import (
+	"context"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"github.com/mobmob912/takuhai/master/worker"
+)
+
+func newLatency(fromID, toID string, d time.Duration) *worker.Latency {
+	return &worker.Latency{
+		FromID:     fromID,
+		ToID:       toID,
+		Latency:    d,
+		MeasuredAt: baseTime,
+	}
+}
+
+// newRepoはサブテスト毎に呼ばれ、空のリポジトリを返す
+func TestLatency(t *testing.T, newRepo func(t *testing.T) repository.Latency) {
+	ctx := context.Background()
+
+	t.Run("ListAllEmpty", func(t *testing.T) {
+		r := newRepo(t)
+		ls, err := r.ListAll(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ls == nil || len(ls) != 0 {
+			t.Fatalf("ListAll of an empty repository: want an empty list, got %v", ls)
+		}
+	})
+
+	t.Run("SetKeepsLatestPerDirection", func(t *testing.T) {
+		r := newRepo(t)
+		for _, lt := range []*worker.Latency{
+			newLatency("w1", "w2", 10*time.Millisecond),
+			newLatency("w2", "w1", 20*time.Millisecond),
+			newLatency("w1", "w2", 30*time.Millisecond),
+		} {
+			if err := r.Set(ctx, lt); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ls, err := r.ListAll(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertLatencies(t, "ListAll", ls, map[string]time.Duration{
+			"w1->w2": 30 * time.Millisecond,
+			"w2->w1": 20 * time.Millisecond,
+		})
+		if ls[0].MeasuredAt.IsZero() || !ls[0].MeasuredAt.Equal(baseTime) {
+			t.Errorf("ListAll: want measured at %v, got %v", baseTime, ls[0].MeasuredAt)
+		}
+	})
+
+	t.Run("DeleteByWorkerID", func(t *testing.T) {
+		r := newRepo(t)
+		for _, lt := range []*worker.Latency{
+			newLatency("w1", "w2", time.Millisecond),
+			newLatency("w2", "w1", 2*time.Millisecond),
+			newLatency("w2", "w3", 3*time.Millisecond),
+			newLatency("w3", "w1", 4*time.Millisecond),
+			newLatency("w3", "w2", 5*time.Millisecond),
+		} {
+			if err := r.Set(ctx, lt); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// 計測した分と、ワーカーまでの分の両方を消す
+		if err := r.DeleteByWorkerID(ctx, "w1"); err != nil {
+			t.Fatal(err)
+		}
+		ls, err := r.ListAll(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertLatencies(t, "ListAll after DeleteByWorkerID", ls, map[string]time.Duration{
+			"w2->w3": 3 * time.Millisecond,
+			"w3->w2": 5 * time.Millisecond,
+		})
+		if err := r.DeleteByWorkerID(ctx, "missing"); err != nil {
+			t.Errorf("DeleteByWorkerID of a worker without latencies must not fail: %v", err)
+		}
+	})
+}
+
+// ListAllの順は決めていないので、向き毎に比べる
+func assertLatencies(t *testing.T, name string, got []*worker.Latency, want map[string]time.Duration) {
+	t.Helper()
+	gotMap := make(map[string]time.Duration, len(got))
+	keys := make([]string, 0, len(got))
+	for _, lt := range got {
+		k := lt.FromID + "->" + lt.ToID
+		gotMap[k] = lt.Latency
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	if len(got) != len(want) {
+		t.Fatalf("%s: want %v, got %v", name, want, keys)
+	}
+	for k, d := range want {
+		if gd, ok := gotMap[k]; !ok || gd != d {
+			t.Fatalf("%s: want %s = %s, got %v", name, k, d, gotMap)
+		}
+	}
+}
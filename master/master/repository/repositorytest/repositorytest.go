@@ -0,0 +1,70 @@
+// Package repositorytest は、リポジトリの実装が満たすべき振る舞いを確かめるテスト
+// 各実装のテストから、空のリポジトリを作る関数を渡して呼ぶ
+//
+//	func TestWorker(t *testing.T) {
+//		repositorytest.TestWorker(t, func(t *testing.T) repository.Worker {
+//			return memory.NewWorker()
+//		})
+//	}
+package repositorytest
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/worker"
+)
+
+// 同時に書き込むgoroutineの数
+const concurrency = 8
+
+func newWorker(t *testing.T, name string, place domain.Place) *worker.Worker {
+	t.Helper()
+	u, err := url.Parse("http://" + name + ":4871")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &worker.Worker{
+		Name:   name,
+		Type:   domain.ImageTypeDocker,
+		Arch:   domain.ArchTypeAMD64,
+		Labels: []string{"zone=tokyo"},
+		URL:    u,
+		Place:  place,
+	}
+}
+
+func newWorkflow(name string, version int) *domain.Workflow {
+	return &domain.Workflow{
+		Version: version,
+		Name:    name,
+		Trigger: &domain.Trigger{Type: domain.TriggerTypeHTTP, Path: "/" + name},
+		Jobs:    []*domain.Job{{Name: "job"}},
+		Steps: []*domain.Step{
+			{ID: "step-1", Name: "first", JobName: "job"},
+			{ID: "step-2", Name: "second", JobName: "job", After: domain.StepNames{"first"}, AfterByID: []string{"step-1"}},
+		},
+	}
+}
+
+func ids(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("id-%02d", i)
+	}
+	return ids
+}
+
+func assertIDs(t *testing.T, name string, got, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("%s: want %v, got %v", name, want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("%s: want %v, got %v", name, want, got)
+		}
+	}
+}
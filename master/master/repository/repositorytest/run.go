@@ -0,0 +1,135 @@
+package repositorytest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/master/repository"
+)
+
+// 秒より細かい時刻の精度は実装によって違うので、ミリ秒までにする
+var baseTime = time.Date(2020, 12, 24, 10, 0, 0, 123000000, time.UTC)
+
+func newRun(id, workflowID string, startedAt time.Time) *domain.Run {
+	return &domain.Run{
+		ID:              id,
+		WorkflowID:      workflowID,
+		WorkflowVersion: 2,
+		Status:          domain.RunStatusRunning,
+		StartedAt:       startedAt,
+		Steps: []*domain.StepRun{
+			{StepID: "step-1", StepName: "first", WorkerID: "worker-1"},
+		},
+	}
+}
+
+// newRepoはサブテスト毎に呼ばれ、空のリポジトリを返す
+func TestRun(t *testing.T, newRepo func(t *testing.T) repository.Run) {
+	ctx := context.Background()
+
+	t.Run("GetNotFound", func(t *testing.T) {
+		r := newRepo(t)
+		if _, err := r.Get(ctx, "missing"); err != repository.ErrNotFound {
+			t.Fatalf("Get of a missing run: want ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("SetAndGet", func(t *testing.T) {
+		r := newRepo(t)
+		rn := newRun("run-1", "flow-1", baseTime)
+		if err := r.Set(ctx, rn); err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.Get(ctx, "run-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != rn.ID || got.WorkflowID != rn.WorkflowID || got.WorkflowVersion != rn.WorkflowVersion || got.Status != rn.Status {
+			t.Errorf("Get: want %+v, got %+v", rn, got)
+		}
+		if !got.StartedAt.Equal(rn.StartedAt) {
+			t.Errorf("Get: want started at %v, got %v", rn.StartedAt, got.StartedAt)
+		}
+		if len(got.Steps) != 1 || got.Steps[0].StepID != "step-1" || got.Steps[0].WorkerID != "worker-1" {
+			t.Errorf("Get: want steps %+v, got %+v", rn.Steps, got.Steps)
+		}
+	})
+
+	t.Run("SetUpserts", func(t *testing.T) {
+		r := newRepo(t)
+		rn := newRun("run-1", "flow-1", baseTime)
+		if err := r.Set(ctx, rn); err != nil {
+			t.Fatal(err)
+		}
+		rn.Status = domain.RunStatusSucceeded
+		rn.FinishedAt = baseTime.Add(time.Minute)
+		if err := r.Set(ctx, rn); err != nil {
+			t.Fatal(err)
+		}
+		runs, err := r.ListByWorkflowID(ctx, "flow-1", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(runs) != 1 {
+			t.Fatalf("Set twice with the same ID: want 1 run, got %d", len(runs))
+		}
+		if runs[0].Status != domain.RunStatusSucceeded || !runs[0].FinishedAt.Equal(rn.FinishedAt) {
+			t.Errorf("Set must replace the run: got %+v", runs[0])
+		}
+	})
+
+	t.Run("ListByWorkflowID", func(t *testing.T) {
+		r := newRepo(t)
+		runs, err := r.ListByWorkflowID(ctx, "flow-1", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runs == nil || len(runs) != 0 {
+			t.Fatalf("ListByWorkflowID of an empty repository: want an empty list, got %v", runs)
+		}
+		// IDの順とも開始時刻の順とも違う順に入れる
+		for _, rn := range []*domain.Run{
+			newRun("run-1", "flow-1", baseTime.Add(2*time.Second)),
+			newRun("run-2", "flow-1", baseTime),
+			newRun("run-3", "flow-2", baseTime.Add(5*time.Second)),
+			newRun("run-4", "flow-1", baseTime.Add(3*time.Second)),
+			newRun("run-5", "flow-1", baseTime.Add(time.Second)),
+		} {
+			if err := r.Set(ctx, rn); err != nil {
+				t.Fatal(err)
+			}
+		}
+		runs, err = r.ListByWorkflowID(ctx, "flow-1", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertIDs(t, "ListByWorkflowID", runIDs(runs), []string{"run-4", "run-1", "run-5", "run-2"})
+		runs, err = r.ListByWorkflowID(ctx, "flow-1", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertIDs(t, "ListByWorkflowID with limit", runIDs(runs), []string{"run-4", "run-1"})
+		runs, err = r.ListByWorkflowID(ctx, "flow-1", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertIDs(t, "ListByWorkflowID with a limit over the count", runIDs(runs), []string{"run-4", "run-1", "run-5", "run-2"})
+		runs, err = r.ListByWorkflowID(ctx, "missing", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runs == nil || len(runs) != 0 {
+			t.Fatalf("ListByWorkflowID of a workflow without runs: want an empty list, got %v", runs)
+		}
+	})
+}
+
+func runIDs(runs []*domain.Run) []string {
+	ids := make([]string, len(runs))
+	for i, rn := range runs {
+		ids[i] = rn.ID
+	}
+	return ids
+}
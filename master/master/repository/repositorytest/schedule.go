@@ -0,0 +1,65 @@
+package repositorytest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mobmob912/takuhai/master/master/repository"
+)
+
+// newRepoはサブテスト毎に呼ばれ、空のリポジトリを返す
+func TestSchedule(t *testing.T, newRepo func(t *testing.T) repository.Schedule) {
+	ctx := context.Background()
+
+	t.Run("GetNotFound", func(t *testing.T) {
+		r := newRepo(t)
+		if _, err := r.GetLastFiredAt(ctx, "missing"); err != repository.ErrNotFound {
+			t.Fatalf("GetLastFiredAt of a workflow never fired: want ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("SetAndGet", func(t *testing.T) {
+		r := newRepo(t)
+		if err := r.SetLastFiredAt(ctx, "flow-1", baseTime); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.SetLastFiredAt(ctx, "flow-2", baseTime.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.GetLastFiredAt(ctx, "flow-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(baseTime) {
+			t.Errorf("GetLastFiredAt: want %v, got %v", baseTime, got)
+		}
+		got, err = r.GetLastFiredAt(ctx, "flow-2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(baseTime.Add(time.Hour)) {
+			t.Errorf("GetLastFiredAt of another workflow: want %v, got %v", baseTime.Add(time.Hour), got)
+		}
+	})
+
+	t.Run("SetReplaces", func(t *testing.T) {
+		r := newRepo(t)
+		if err := r.SetLastFiredAt(ctx, "flow-1", baseTime); err != nil {
+			t.Fatal(err)
+		}
+		// masterの時計が戻っても、最後に保存した時刻にする
+		for _, at := range []time.Time{baseTime.Add(time.Minute), baseTime.Add(-time.Minute)} {
+			if err := r.SetLastFiredAt(ctx, "flow-1", at); err != nil {
+				t.Fatal(err)
+			}
+			got, err := r.GetLastFiredAt(ctx, "flow-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(at) {
+				t.Errorf("GetLastFiredAt after SetLastFiredAt: want %v, got %v", at, got)
+			}
+		}
+	})
+}
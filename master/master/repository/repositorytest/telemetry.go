@@ -0,0 +1,127 @@
+package repositorytest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/master/repository"
+)
+
+func newTelemetrySample(workflowID, runID string, recordedAt time.Time) *domain.TelemetrySample {
+	return &domain.TelemetrySample{
+		WorkflowID:      workflowID,
+		WorkflowVersion: 1,
+		RunID:           runID,
+		StepID:          "step-1",
+		StepName:        "first",
+		Attempt:         1,
+		FromWorkerID:    "worker-1",
+		ToWorkerName:    "worker-2",
+		Runtime:         200 * time.Millisecond,
+		TransferTime:    10 * time.Millisecond,
+		RAM:             12.5,
+		CPU:             0.5,
+		RecordedAt:      recordedAt,
+	}
+}
+
+// newRepoはサブテスト毎に呼ばれ、空のリポジトリを返す
+func TestTelemetry(t *testing.T, newRepo func(t *testing.T) repository.Telemetry) {
+	ctx := context.Background()
+
+	t.Run("ListEmpty", func(t *testing.T) {
+		r := newRepo(t)
+		ss, err := r.ListByWorkflowID(ctx, "missing", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ss == nil || len(ss) != 0 {
+			t.Fatalf("ListByWorkflowID of a workflow without samples: want an empty list, got %v", ss)
+		}
+	})
+
+	t.Run("AddAndList", func(t *testing.T) {
+		r := newRepo(t)
+		want := newTelemetrySample("flow-1", "run-1", baseTime)
+		if err := r.Add(ctx, want); err != nil {
+			t.Fatal(err)
+		}
+		ss, err := r.ListByWorkflowID(ctx, "flow-1", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ss) != 1 {
+			t.Fatalf("ListByWorkflowID: want 1 sample, got %d", len(ss))
+		}
+		got := ss[0]
+		if got.RunID != want.RunID || got.StepID != want.StepID || got.Attempt != want.Attempt || got.ToWorkerName != want.ToWorkerName {
+			t.Errorf("ListByWorkflowID: want %+v, got %+v", want, got)
+		}
+		if got.Runtime != want.Runtime || got.TransferTime != want.TransferTime || got.RAM != want.RAM || got.CPU != want.CPU {
+			t.Errorf("ListByWorkflowID: want %+v, got %+v", want, got)
+		}
+		if !got.RecordedAt.Equal(want.RecordedAt) {
+			t.Errorf("ListByWorkflowID: want recorded at %v, got %v", want.RecordedAt, got.RecordedAt)
+		}
+	})
+
+	t.Run("ListOrder", func(t *testing.T) {
+		r := newRepo(t)
+		// 記録時刻と違う順に入れる. 同じ時刻なら後から追加した方が先
+		for _, s := range []*domain.TelemetrySample{
+			newTelemetrySample("flow-1", "run-1", baseTime.Add(2*time.Second)),
+			newTelemetrySample("flow-1", "run-2", baseTime),
+			newTelemetrySample("flow-2", "run-3", baseTime.Add(5*time.Second)),
+			newTelemetrySample("flow-1", "run-4", baseTime.Add(3*time.Second)),
+			newTelemetrySample("flow-1", "run-5", baseTime.Add(2*time.Second)),
+		} {
+			if err := r.Add(ctx, s); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ss, err := r.ListByWorkflowID(ctx, "flow-1", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertIDs(t, "ListByWorkflowID", sampleRunIDs(ss), []string{"run-4", "run-5", "run-1", "run-2"})
+		ss, err = r.ListByWorkflowID(ctx, "flow-1", 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertIDs(t, "ListByWorkflowID with limit", sampleRunIDs(ss), []string{"run-4", "run-5", "run-1"})
+	})
+
+	t.Run("AddKeepsEverySample", func(t *testing.T) {
+		r := newRepo(t)
+		// 同じrunの同じステップでも、全て残す
+		for i := 0; i < concurrency; i++ {
+			s := newTelemetrySample("flow-1", "run-1", baseTime.Add(time.Duration(i)*time.Second))
+			s.Attempt = i + 1
+			if err := r.Add(ctx, s); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ss, err := r.ListByWorkflowID(ctx, "flow-1", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ss) != concurrency {
+			t.Fatalf("ListByWorkflowID: want %d samples, got %d", concurrency, len(ss))
+		}
+		for i, s := range ss {
+			if want := concurrency - i; s.Attempt != want {
+				t.Fatalf("ListByWorkflowID: want attempt %d at %d, got %d", want, i, s.Attempt)
+			}
+		}
+	})
+}
+
+func sampleRunIDs(ss []*domain.TelemetrySample) []string {
+	ids := make([]string, len(ss))
+	for i, s := range ss {
+		ids[i] = s.RunID
+	}
+	return ids
+}
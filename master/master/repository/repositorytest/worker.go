@@ -0,0 +1,235 @@
+package repositorytest
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"github.com/mobmob912/takuhai/master/worker"
+)
+
+// newRepoはサブテスト毎に呼ばれ、空のリポジトリを返す
+func TestWorker(t *testing.T, newRepo func(t *testing.T) repository.Worker) {
+	ctx := context.Background()
+
+	t.Run("GetNotFound", func(t *testing.T) {
+		r := newRepo(t)
+		if _, err := r.Get(ctx, "missing"); err != repository.ErrNotFound {
+			t.Fatalf("Get of a missing worker: want ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("SetAndGet", func(t *testing.T) {
+		r := newRepo(t)
+		w := newWorker(t, "worker-1", domain.PlaceEdge)
+		if err := r.Set(ctx, "id-1", w); err != nil {
+			t.Fatal(err)
+		}
+		if w.ID != "id-1" {
+			t.Errorf("Set must set the ID: got %q", w.ID)
+		}
+		got, err := r.Get(ctx, "id-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != "id-1" || got.Name != w.Name || got.Place != w.Place || got.Arch != w.Arch || got.Type != w.Type {
+			t.Errorf("Get: want %+v, got %+v", w, got)
+		}
+		if got.URL == nil || got.URL.String() != w.URL.String() {
+			t.Errorf("Get: want URL %v, got %v", w.URL, got.URL)
+		}
+		if len(got.Labels) != 1 || got.Labels[0] != "zone=tokyo" {
+			t.Errorf("Get: want labels %v, got %v", w.Labels, got.Labels)
+		}
+	})
+
+	t.Run("SetUpserts", func(t *testing.T) {
+		r := newRepo(t)
+		if err := r.Set(ctx, "id-1", newWorker(t, "worker-1", domain.PlaceEdge)); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Set(ctx, "id-1", newWorker(t, "worker-1", domain.PlaceCloud)); err != nil {
+			t.Fatal(err)
+		}
+		ws, err := r.ListAll(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ws) != 1 {
+			t.Fatalf("Set twice with the same ID: want 1 worker, got %d", len(ws))
+		}
+		if ws[0].Place != domain.PlaceCloud {
+			t.Errorf("Set must replace the worker: got place %s", ws[0].Place)
+		}
+	})
+
+	t.Run("GetReturnsCopy", func(t *testing.T) {
+		r := newRepo(t)
+		w := newWorker(t, "worker-1", domain.PlaceEdge)
+		if err := r.Set(ctx, "id-1", w); err != nil {
+			t.Fatal(err)
+		}
+		// 保存した後や取得した後に書き換えても、保存した値は変わらない
+		w.Name = "changed"
+		got, err := r.Get(ctx, "id-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got.Labels[0] = "changed"
+		got, err = r.Get(ctx, "id-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != "worker-1" || got.Labels[0] != "zone=tokyo" {
+			t.Errorf("stored worker was changed by the caller: got %+v", got)
+		}
+	})
+
+	t.Run("UpdateNotFound", func(t *testing.T) {
+		r := newRepo(t)
+		if err := r.Update(ctx, "missing", newWorker(t, "worker-1", domain.PlaceEdge)); err != repository.ErrNotFound {
+			t.Fatalf("Update of a missing worker: want ErrNotFound, got %v", err)
+		}
+		if _, err := r.Get(ctx, "missing"); err != repository.ErrNotFound {
+			t.Fatalf("Update must not add a worker: got %v", err)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		r := newRepo(t)
+		if err := r.Set(ctx, "id-1", newWorker(t, "worker-1", domain.PlaceEdge)); err != nil {
+			t.Fatal(err)
+		}
+		w := newWorker(t, "worker-1", domain.PlaceEdge)
+		w.AvailableMemory = 1024
+		if err := r.Update(ctx, "id-1", w); err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.Get(ctx, "id-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != "id-1" || got.AvailableMemory != 1024 {
+			t.Errorf("Update: got %+v", got)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		r := newRepo(t)
+		if err := r.Set(ctx, "id-1", newWorker(t, "worker-1", domain.PlaceEdge)); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Delete(ctx, "id-1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Get(ctx, "id-1"); err != repository.ErrNotFound {
+			t.Errorf("Get after Delete: want ErrNotFound, got %v", err)
+		}
+		if err := r.Delete(ctx, "id-1"); err != nil {
+			t.Errorf("Delete of a missing worker must not fail: %v", err)
+		}
+	})
+
+	t.Run("ListOrder", func(t *testing.T) {
+		r := newRepo(t)
+		ws, err := r.ListAll(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ws == nil || len(ws) != 0 {
+			t.Fatalf("ListAll of an empty repository: want an empty list, got %v", ws)
+		}
+		// IDの順と違う順に入れる
+		for _, id := range []string{"id-3", "id-1", "id-4", "id-2"} {
+			place := domain.PlaceEdge
+			if id == "id-1" || id == "id-4" {
+				place = domain.PlaceCloud
+			}
+			if err := r.Set(ctx, id, newWorker(t, "worker-"+id, place)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		ws, err = r.ListAll(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertIDs(t, "ListAll", workerIDs(ws), []string{"id-1", "id-2", "id-3", "id-4"})
+		clouds, err := r.ListClouds(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertIDs(t, "ListClouds", workerIDs(clouds), []string{"id-1", "id-4"})
+	})
+
+	t.Run("ConcurrentSet", func(t *testing.T) {
+		r := newRepo(t)
+		want := ids(concurrency)
+		errs := make(chan error, concurrency)
+		var wg sync.WaitGroup
+		for _, id := range want {
+			wg.Add(1)
+			go func(id string) {
+				defer wg.Done()
+				errs <- r.Set(ctx, id, newWorker(t, "worker-"+id, domain.PlaceEdge))
+			}(id)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		ws, err := r.ListAll(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertIDs(t, "ListAll after concurrent Set", workerIDs(ws), want)
+	})
+
+	t.Run("ConcurrentUpdate", func(t *testing.T) {
+		r := newRepo(t)
+		if err := r.Set(ctx, "id-1", newWorker(t, "worker-1", domain.PlaceEdge)); err != nil {
+			t.Fatal(err)
+		}
+		errs := make(chan error, concurrency)
+		var wg sync.WaitGroup
+		for i := 1; i <= concurrency; i++ {
+			wg.Add(1)
+			go func(memory uint64) {
+				defer wg.Done()
+				w := newWorker(t, "worker-1", domain.PlaceEdge)
+				w.AvailableMemory = memory
+				errs <- r.Update(ctx, "id-1", w)
+			}(uint64(i))
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		ws, err := r.ListAll(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ws) != 1 {
+			t.Fatalf("concurrent Update must not add workers: got %d", len(ws))
+		}
+		// どれか1つの更新がそのまま残る
+		if m := ws[0].AvailableMemory; m < 1 || m > concurrency {
+			t.Errorf("concurrent Update: got available memory %d", m)
+		}
+	})
+}
+
+func workerIDs(ws []*worker.Worker) []string {
+	ids := make([]string, len(ws))
+	for i, w := range ws {
+		ids[i] = w.ID
+	}
+	return ids
+}
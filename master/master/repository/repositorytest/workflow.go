@@ -0,0 +1,241 @@
+package repositorytest
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/master/repository"
+)
+
+// newRepoはサブテスト毎に呼ばれ、空のリポジトリを返す
+func TestWorkflow(t *testing.T, newRepo func(t *testing.T) repository.Workflow) {
+	ctx := context.Background()
+
+	t.Run("NotFound", func(t *testing.T) {
+		r := newRepo(t)
+		if _, err := r.Get(ctx, "missing"); err != repository.ErrNotFound {
+			t.Errorf("Get of a missing workflow: want ErrNotFound, got %v", err)
+		}
+		if _, err := r.GetByName(ctx, "missing"); err != repository.ErrNotFound {
+			t.Errorf("GetByName of a missing workflow: want ErrNotFound, got %v", err)
+		}
+		if _, err := r.GetStep(ctx, "missing", "step-1"); err != repository.ErrNotFound {
+			t.Errorf("GetStep of a missing workflow: want ErrNotFound, got %v", err)
+		}
+		if _, err := r.GetRevision(ctx, "missing", 1); err != repository.ErrNotFound {
+			t.Errorf("GetRevision of a missing workflow: want ErrNotFound, got %v", err)
+		}
+		ok, err := r.CheckExistByByName(ctx, "missing")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			t.Errorf("CheckExistByByName of a missing workflow: want false")
+		}
+	})
+
+	t.Run("SetAndGet", func(t *testing.T) {
+		r := newRepo(t)
+		wf := newWorkflow("flow", 1)
+		if err := r.Set(ctx, "id-1", wf); err != nil {
+			t.Fatal(err)
+		}
+		if wf.ID != "id-1" {
+			t.Errorf("Set must set the ID: got %q", wf.ID)
+		}
+		got, err := r.Get(ctx, "id-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertWorkflow(t, "Get", got, "id-1", "flow", 1)
+		got, err = r.GetByName(ctx, "flow")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertWorkflow(t, "GetByName", got, "id-1", "flow", 1)
+		ok, err := r.CheckExistByByName(ctx, "flow")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Errorf("CheckExistByByName: want true")
+		}
+	})
+
+	t.Run("GetStep", func(t *testing.T) {
+		r := newRepo(t)
+		if err := r.Set(ctx, "id-1", newWorkflow("flow", 1)); err != nil {
+			t.Fatal(err)
+		}
+		s, err := r.GetStep(ctx, "id-1", "step-2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.ID != "step-2" || s.Name != "second" || len(s.AfterByID) != 1 || s.AfterByID[0] != "step-1" {
+			t.Errorf("GetStep: got %+v", s)
+		}
+		if _, err := r.GetStep(ctx, "id-1", "missing"); err != repository.ErrNotFound {
+			t.Errorf("GetStep of a missing step: want ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("SetUpserts", func(t *testing.T) {
+		r := newRepo(t)
+		if err := r.Set(ctx, "id-1", newWorkflow("flow", 1)); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Set(ctx, "id-1", newWorkflow("flow", 2)); err != nil {
+			t.Fatal(err)
+		}
+		wfs, err := r.ListAll(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(wfs) != 1 {
+			t.Fatalf("Set twice with the same ID: want 1 workflow, got %d", len(wfs))
+		}
+		assertWorkflow(t, "ListAll", wfs[0], "id-1", "flow", 2)
+	})
+
+	t.Run("ListOrder", func(t *testing.T) {
+		r := newRepo(t)
+		wfs, err := r.ListAll(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wfs == nil || len(wfs) != 0 {
+			t.Fatalf("ListAll of an empty repository: want an empty list, got %v", wfs)
+		}
+		// IDの順と違う順に入れる
+		for _, id := range []string{"id-3", "id-1", "id-2"} {
+			if err := r.Set(ctx, id, newWorkflow("flow-"+id, 1)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		wfs, err = r.ListAll(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertIDs(t, "ListAll", workflowIDs(wfs), []string{"id-1", "id-2", "id-3"})
+	})
+
+	t.Run("Revisions", func(t *testing.T) {
+		r := newRepo(t)
+		revs, err := r.ListRevisions(ctx, "id-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revs == nil || len(revs) != 0 {
+			t.Fatalf("ListRevisions of a missing workflow: want an empty list, got %v", revs)
+		}
+		// バージョンの順と違う順に入れる
+		for _, v := range []int{2, 3, 1} {
+			wf := newWorkflow("flow", v)
+			wf.ID = "id-1"
+			if err := r.AddRevision(ctx, wf); err != nil {
+				t.Fatal(err)
+			}
+		}
+		other := newWorkflow("other", 1)
+		other.ID = "id-2"
+		if err := r.AddRevision(ctx, other); err != nil {
+			t.Fatal(err)
+		}
+		got, err := r.GetRevision(ctx, "id-1", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertWorkflow(t, "GetRevision", got, "id-1", "flow", 2)
+		if _, err := r.GetRevision(ctx, "id-1", 4); err != repository.ErrNotFound {
+			t.Errorf("GetRevision of a missing version: want ErrNotFound, got %v", err)
+		}
+		revs, err = r.ListRevisions(ctx, "id-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		versions := make([]int, len(revs))
+		for i, rev := range revs {
+			versions[i] = rev.Version
+		}
+		if len(versions) != 3 || versions[0] != 1 || versions[1] != 2 || versions[2] != 3 {
+			t.Errorf("ListRevisions: want versions [1 2 3], got %v", versions)
+		}
+	})
+
+	t.Run("ConcurrentSet", func(t *testing.T) {
+		r := newRepo(t)
+		want := ids(concurrency)
+		errs := make(chan error, concurrency)
+		var wg sync.WaitGroup
+		for _, id := range want {
+			wg.Add(1)
+			go func(id string) {
+				defer wg.Done()
+				errs <- r.Set(ctx, id, newWorkflow("flow-"+id, 1))
+			}(id)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		wfs, err := r.ListAll(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertIDs(t, "ListAll after concurrent Set", workflowIDs(wfs), want)
+	})
+
+	t.Run("ConcurrentSetSameID", func(t *testing.T) {
+		r := newRepo(t)
+		errs := make(chan error, concurrency)
+		var wg sync.WaitGroup
+		for i := 1; i <= concurrency; i++ {
+			wg.Add(1)
+			go func(version int) {
+				defer wg.Done()
+				errs <- r.Set(ctx, "id-1", newWorkflow("flow", version))
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		wfs, err := r.ListAll(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(wfs) != 1 {
+			t.Fatalf("concurrent Set with the same ID: want 1 workflow, got %d", len(wfs))
+		}
+		// どれか1つの書き込みがそのまま残る
+		if v := wfs[0].Version; v < 1 || v > concurrency {
+			t.Errorf("concurrent Set: got version %d", v)
+		}
+	})
+}
+
+func assertWorkflow(t *testing.T, name string, got *domain.Workflow, id, workflowName string, version int) {
+	t.Helper()
+	if got.ID != id || got.Name != workflowName || got.Version != version {
+		t.Errorf("%s: want %s %s v%d, got %s %s v%d", name, id, workflowName, version, got.ID, got.Name, got.Version)
+	}
+	if len(got.Steps) != 2 || got.Steps[0].ID != "step-1" || got.Steps[1].ID != "step-2" {
+		t.Errorf("%s: steps were not stored: got %v", name, got.Steps)
+	}
+}
+
+func workflowIDs(wfs []*domain.Workflow) []string {
+	ids := make([]string, len(wfs))
+	for i, wf := range wfs {
+		ids[i] = wf.ID
+	}
+	return ids
+}
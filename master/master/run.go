@@ -0,0 +1,45 @@
+package master
+
+import (
+	"context"
+	"log"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/master/repository"
+)
+
+// ワーカーから報告されたrunの出来事を実行履歴に反映する
+func (m *Master) RecordRunEvent(ctx context.Context, runID string, e *domain.RunEvent) error {
+	if e.Type == domain.RunEventStepFailed {
+		log.Printf("step failed. workflowID=%s, stepID=%s, runID=%s, workerID=%s, attempt=%d, final=%t: %s", e.WorkflowID, e.StepID, runID, e.WorkerID, e.Attempt, e.Final, e.Message)
+	}
+	// 複数のワーカーから同じrunの出来事が同時に届くので、読んでから書くまでをまとめてロックする
+	m.runMutex.Lock()
+	defer m.runMutex.Unlock()
+	run, err := m.runRepository.Get(ctx, runID)
+	if err == repository.ErrNotFound {
+		run = domain.NewRun(runID, e.WorkflowID, e.WorkflowVersion, e.Time)
+	} else if err != nil {
+		return err
+	}
+	run.Apply(e)
+	wf, err := m.workflowRepository.GetRevision(ctx, run.WorkflowID, run.WorkflowVersion)
+	if err != nil {
+		return err
+	}
+	run.UpdateStatus(wf)
+	return m.runRepository.Set(ctx, run)
+}
+
+func (m *Master) GetRun(ctx context.Context, id string) (*domain.Run, error) {
+	return m.runRepository.Get(ctx, id)
+}
+
+// 新しい順にlimit件まで
+func (m *Master) ListWorkflowRuns(ctx context.Context, workflowName string, limit int) ([]*domain.Run, error) {
+	wf, err := m.workflowRepository.GetByName(ctx, workflowName)
+	if err != nil {
+		return nil, err
+	}
+	return m.runRepository.ListByWorkflowID(ctx, wf.ID, limit)
+}
@@ -0,0 +1,243 @@
+package master
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mobmob912/takuhai/domain"
+
+	"github.com/mobmob912/takuhai/master/worker"
+)
+
+// ステップを配置するワーカーを決める時に、プラグインへ渡す情報
+type SchedulingContext struct {
+	WorkflowID string
+	Step       *domain.Step
+	// 直前のステップを実行したワーカー. 最初のステップなら空
+	PreviousJobWorkerID string
+	RunID               string
+	// ワーカー間のレイテンシ. PreviousJobWorkerIDが空ならnil
+	Latencies worker.LatencyMatrix
+	// ステップのplacementで、今試しているplace. 空ならステップのplaceに従う
+	Place domain.Place
+	// 全てのワーカーの親子関係
+	Topology *worker.Topology
+}
+
+// 条件を満たさないワーカーを候補から除くプラグイン
+type FilterPlugin interface {
+	Name() string
+	Filter(ctx context.Context, sc *SchedulingContext, w *worker.Worker) (bool, error)
+}
+
+// 候補のワーカーに点数をつけるプラグイン. 大きいほど良い
+// 点数は候補の中で0~1に正規化してから重みをかけて足し合わせる
+type ScorePlugin interface {
+	Name() string
+	Score(ctx context.Context, sc *SchedulingContext, w *worker.Worker) (float64, error)
+}
+
+// masterの設定ファイルのscheduler
+type SchedulerConfig struct {
+	// k=スコアプラグイン名, v=重み. 0ならそのプラグインを使わない
+	// 書かなかったプラグインはDefaultScoreWeightsの重みを使う
+	Weights map[string]float64 `yaml:"weights"`
+}
+
+// フィルタで候補を絞り、スコアの合計が最も高いワーカーを選ぶ
+type Scheduler struct {
+	filters []FilterPlugin
+	scores  []*weightedScorePlugin
+}
+
+type weightedScorePlugin struct {
+	plugin ScorePlugin
+	weight float64
+}
+
+type OptionsNewScheduler struct {
+	// 空ならDefaultFilterPlugins
+	Filters []FilterPlugin
+	// 空ならDefaultScorePlugins
+	Scores []ScorePlugin
+	Config *SchedulerConfig
+}
+
+func NewScheduler(opts *OptionsNewScheduler) (*Scheduler, error) {
+	filters := opts.Filters
+	if len(filters) == 0 {
+		filters = DefaultFilterPlugins()
+	}
+	scores := opts.Scores
+	if len(scores) == 0 {
+		scores = DefaultScorePlugins()
+	}
+	weights := make(map[string]float64, len(scores))
+	for name, weight := range DefaultScoreWeights() {
+		weights[name] = weight
+	}
+	known := make(map[string]bool, len(scores))
+	for _, p := range scores {
+		known[p.Name()] = true
+	}
+	if opts.Config != nil {
+		for name, weight := range opts.Config.Weights {
+			if !known[name] {
+				return nil, fmt.Errorf("unknown score plugin %s", name)
+			}
+			if weight < 0 {
+				return nil, fmt.Errorf("weight of %s must not be negative", name)
+			}
+			weights[name] = weight
+		}
+	}
+	s := &Scheduler{
+		filters: filters,
+		scores:  make([]*weightedScorePlugin, 0, len(scores)),
+	}
+	for _, p := range scores {
+		weight, ok := weights[p.Name()]
+		if !ok {
+			// 独自のプラグインは重みを書かなければ1
+			weight = 1
+		}
+		if weight == 0 {
+			continue
+		}
+		s.scores = append(s.scores, &weightedScorePlugin{plugin: p, weight: weight})
+	}
+	return s, nil
+}
+
+// ワーカー毎の配置の判断の内訳
+type WorkerExplanation struct {
+	WorkerID   string `json:"worker_id"`
+	WorkerName string `json:"worker_name"`
+	// 除外したフィルタ. 候補に残れば空
+	RejectedBy string `json:"rejected_by,omitempty"`
+	// スコアプラグイン毎の、正規化して重みをかけた点数
+	Scores map[string]float64 `json:"scores,omitempty"`
+	Total  float64            `json:"total"`
+	// place: anyのステップで、完了までの時間を予測できた時
+	Prediction *CompletionPrediction `json:"prediction,omitempty"`
+}
+
+func (s *Scheduler) Schedule(ctx context.Context, sc *SchedulingContext, wks []*worker.Worker) (*worker.Worker, error) {
+	wk, _, err := s.explain(ctx, sc, wks)
+	if err != nil {
+		return nil, err
+	}
+	if wk == nil {
+		return nil, ErrMatchedWorkerNotFound
+	}
+	return wk, nil
+}
+
+// 全てのワーカーについて、除外したフィルタか点数を返す. 候補が無ければワーカーはnil
+func (s *Scheduler) explain(ctx context.Context, sc *SchedulingContext, wks []*worker.Worker) (*worker.Worker, []*WorkerExplanation, error) {
+	explanations := make([]*WorkerExplanation, len(wks))
+	candidates := make([]*worker.Worker, 0, len(wks))
+	candidateExplanations := make([]*WorkerExplanation, 0, len(wks))
+	for i, w := range wks {
+		rejectedBy, err := s.rejectedBy(ctx, sc, w)
+		if err != nil {
+			return nil, nil, err
+		}
+		explanations[i] = &WorkerExplanation{
+			WorkerID:   w.ID,
+			WorkerName: w.Name,
+			RejectedBy: rejectedBy,
+		}
+		if rejectedBy == "" {
+			candidates = append(candidates, w)
+			candidateExplanations = append(candidateExplanations, explanations[i])
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, explanations, nil
+	}
+	if err := s.score(ctx, sc, candidates, candidateExplanations); err != nil {
+		return nil, nil, err
+	}
+	best := 0
+	for i, e := range candidateExplanations {
+		if e.Total > candidateExplanations[best].Total {
+			best = i
+		}
+	}
+	return candidates[best], explanations, nil
+}
+
+func (s *Scheduler) filter(ctx context.Context, sc *SchedulingContext, wks []*worker.Worker) ([]*worker.Worker, error) {
+	candidates := make([]*worker.Worker, 0, len(wks))
+	for _, w := range wks {
+		rejectedBy, err := s.rejectedBy(ctx, sc, w)
+		if err != nil {
+			return nil, err
+		}
+		if rejectedBy == "" {
+			candidates = append(candidates, w)
+		}
+	}
+	return candidates, nil
+}
+
+// ワーカーを除外したフィルタ名. 全て通れば空
+func (s *Scheduler) rejectedBy(ctx context.Context, sc *SchedulingContext, w *worker.Worker) (string, error) {
+	for _, f := range s.filters {
+		ok, err := f.Filter(ctx, sc, w)
+		if err != nil {
+			return "", fmt.Errorf("filter %s: %s", f.Name(), err.Error())
+		}
+		if !ok {
+			return f.Name(), nil
+		}
+	}
+	return "", nil
+}
+
+// 候補毎の点数をexplanationsに入れる. candidatesと同じ順番
+func (s *Scheduler) score(ctx context.Context, sc *SchedulingContext, candidates []*worker.Worker, explanations []*WorkerExplanation) error {
+	for _, e := range explanations {
+		e.Scores = make(map[string]float64, len(s.scores))
+	}
+	for _, ws := range s.scores {
+		raw := make([]float64, len(candidates))
+		for i, w := range candidates {
+			score, err := ws.plugin.Score(ctx, sc, w)
+			if err != nil {
+				return fmt.Errorf("score %s: %s", ws.plugin.Name(), err.Error())
+			}
+			raw[i] = score
+		}
+		for i, score := range normalizeScores(raw) {
+			explanations[i].Scores[ws.plugin.Name()] = score * ws.weight
+			explanations[i].Total += score * ws.weight
+		}
+	}
+	return nil
+}
+
+// 最小を0、最大を1にする. 全て同じなら全て最大の1
+func normalizeScores(raw []float64) []float64 {
+	min, max := raw[0], raw[0]
+	for _, v := range raw {
+		if v < min {
+			min = v
+		}
+		if v > max {
+			max = v
+		}
+	}
+	normalized := make([]float64, len(raw))
+	if max == min {
+		for i := range normalized {
+			normalized[i] = 1
+		}
+		return normalized
+	}
+	for i, v := range raw {
+		normalized[i] = (v - min) / (max - min)
+	}
+	return normalized
+}
@@ -0,0 +1,233 @@
+package master
+
+import (
+	"context"
+
+	"github.com/mobmob912/takuhai/domain"
+
+	"github.com/mobmob912/takuhai/master/worker"
+)
+
+func DefaultFilterPlugins() []FilterPlugin {
+	return []FilterPlugin{
+		&stateFilter{},
+		&cordonFilter{},
+		&typeArchFilter{},
+		&placeFilter{},
+		&labelsFilter{},
+		&resourcesFilter{},
+	}
+}
+
+func DefaultScorePlugins() []ScorePlugin {
+	return []ScorePlugin{
+		&memoryScore{},
+		&cpuScore{},
+		&cpuClockScore{},
+		&latencyScore{},
+		&transferLatencyScore{},
+		&previousWorkerScore{},
+		&upstreamScore{},
+	}
+}
+
+// 設定ファイルに書かなかった時の重み
+// edgeのステップは直前のワーカーでそのまま実行し、deviceの次はつながっているedgeを選ぶ
+// それ以外は空きメモリが多いワーカーを選ぶ
+func DefaultScoreWeights() map[string]float64 {
+	return map[string]float64{
+		"memory":           1,
+		"cpu":              0,
+		"cpu-clock":        0,
+		"latency":          0,
+		"transfer-latency": 1,
+		"previous-worker":  10,
+		"upstream":         5,
+	}
+}
+
+// リースが切れてunreachableかremovedになったワーカーは除く
+type stateFilter struct{}
+
+func (f *stateFilter) Name() string {
+	return "state"
+}
+
+func (f *stateFilter) Filter(ctx context.Context, sc *SchedulingContext, w *worker.Worker) (bool, error) {
+	return w.Schedulable(), nil
+}
+
+// メンテナンスのためにcordonしたワーカーは除く
+type cordonFilter struct{}
+
+func (f *cordonFilter) Name() string {
+	return "cordon"
+}
+
+func (f *cordonFilter) Filter(ctx context.Context, sc *SchedulingContext, w *worker.Worker) (bool, error) {
+	return !w.Cordoned, nil
+}
+
+// ジョブのイメージを実行できるtypeとarchのワーカー
+type typeArchFilter struct{}
+
+func (f *typeArchFilter) Name() string {
+	return "type-arch"
+}
+
+func (f *typeArchFilter) Filter(ctx context.Context, sc *SchedulingContext, w *worker.Worker) (bool, error) {
+	return sc.Step.Job.ImageFor(w.Type, w.Arch) != nil, nil
+}
+
+// cloudとdeviceのステップは同じplaceのワーカーで実行し、それ以外はdevice以外のワーカーで実行する
+// placementのあるステップは、試しているplaceのワーカーだけにする
+type placeFilter struct{}
+
+func (f *placeFilter) Name() string {
+	return "place"
+}
+
+func (f *placeFilter) Filter(ctx context.Context, sc *SchedulingContext, w *worker.Worker) (bool, error) {
+	place := sc.Step.Place
+	if sc.Place != "" {
+		if sc.Place != domain.PlaceAny {
+			return w.Place == sc.Place, nil
+		}
+		place = domain.PlaceAny
+	}
+	switch place {
+	case domain.PlaceCloud, domain.PlaceDevice:
+		return w.Place == place, nil
+	}
+	return w.Place != domain.PlaceDevice, nil
+}
+
+// ステップのlabelsとselectorを満たすラベルを持つワーカー
+type labelsFilter struct{}
+
+func (f *labelsFilter) Name() string {
+	return "labels"
+}
+
+func (f *labelsFilter) Filter(ctx context.Context, sc *SchedulingContext, w *worker.Worker) (bool, error) {
+	sel, err := sc.Step.LabelSelector()
+	if err != nil {
+		return false, err
+	}
+	return sel.Matches(domain.ParseLabels(w.Labels)), nil
+}
+
+// ジョブのメモリの上限より空きメモリが少ないワーカーは除く
+type resourcesFilter struct{}
+
+func (f *resourcesFilter) Name() string {
+	return "resources"
+}
+
+func (f *resourcesFilter) Filter(ctx context.Context, sc *SchedulingContext, w *worker.Worker) (bool, error) {
+	// deviceはリソースを報告しないので確かめられない
+	if w.Place == domain.PlaceDevice {
+		return true, nil
+	}
+	memory, err := sc.Step.Job.Limits.MemoryBytes()
+	if err != nil {
+		return false, err
+	}
+	return w.AvailableMemory >= uint64(memory), nil
+}
+
+// 空きメモリが多いほど高い
+type memoryScore struct{}
+
+func (s *memoryScore) Name() string {
+	return "memory"
+}
+
+func (s *memoryScore) Score(ctx context.Context, sc *SchedulingContext, w *worker.Worker) (float64, error) {
+	return float64(w.AvailableMemory), nil
+}
+
+// CPU使用率が低いほど高い
+type cpuScore struct{}
+
+func (s *cpuScore) Name() string {
+	return "cpu"
+}
+
+func (s *cpuScore) Score(ctx context.Context, sc *SchedulingContext, w *worker.Worker) (float64, error) {
+	return 100 - w.CPUUsagePercent, nil
+}
+
+// CPUのクロック数が高いほど高い
+type cpuClockScore struct{}
+
+func (s *cpuClockScore) Name() string {
+	return "cpu-clock"
+}
+
+func (s *cpuClockScore) Score(ctx context.Context, sc *SchedulingContext, w *worker.Worker) (float64, error) {
+	return w.CPUClockMhz, nil
+}
+
+// ワーカーのレイテンシが小さいほど高い
+type latencyScore struct{}
+
+func (s *latencyScore) Name() string {
+	return "latency"
+}
+
+func (s *latencyScore) Score(ctx context.Context, sc *SchedulingContext, w *worker.Worker) (float64, error) {
+	return -float64(w.Latency), nil
+}
+
+// 直前のステップのワーカーからのレイテンシが小さいほど高い
+// 計測されていない組み合わせは、計測された中で最も遅いものとして扱う
+type transferLatencyScore struct{}
+
+func (s *transferLatencyScore) Name() string {
+	return "transfer-latency"
+}
+
+func (s *transferLatencyScore) Score(ctx context.Context, sc *SchedulingContext, w *worker.Worker) (float64, error) {
+	if sc.PreviousJobWorkerID == "" || sc.Latencies == nil {
+		return 0, nil
+	}
+	d, ok := sc.Latencies.Get(sc.PreviousJobWorkerID, w.ID)
+	if !ok {
+		d = sc.Latencies.Max()
+	}
+	return -float64(d), nil
+}
+
+// edgeとdeviceのステップは、データを転送しなくて済むように直前のステップと同じワーカーを優先する
+type previousWorkerScore struct{}
+
+func (s *previousWorkerScore) Name() string {
+	return "previous-worker"
+}
+
+func (s *previousWorkerScore) Score(ctx context.Context, sc *SchedulingContext, w *worker.Worker) (float64, error) {
+	if (sc.Step.Place == domain.PlaceEdge || sc.Step.Place == domain.PlaceDevice) && sc.PreviousJobWorkerID != "" && w.ID == sc.PreviousJobWorkerID {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// 直前のステップのワーカーから親を辿って近い上流のワーカーほど高い
+// deviceの出力は、そのdeviceがつながっているedgeで処理する
+type upstreamScore struct{}
+
+func (s *upstreamScore) Name() string {
+	return "upstream"
+}
+
+func (s *upstreamScore) Score(ctx context.Context, sc *SchedulingContext, w *worker.Worker) (float64, error) {
+	if sc.PreviousJobWorkerID == "" || sc.Topology == nil {
+		return 0, nil
+	}
+	d, ok := sc.Topology.UpstreamDistance(sc.PreviousJobWorkerID, w.ID)
+	if !ok || d == 0 {
+		return 0, nil
+	}
+	return 1 / float64(d), nil
+}
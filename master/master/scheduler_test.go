@@ -0,0 +1,184 @@
+package master
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/worker"
+)
+
+const gib = 1 << 30
+
+func newSchedulingWorker(id string, place domain.Place, memory uint64) *worker.Worker {
+	return &worker.Worker{
+		ID:              id,
+		Name:            id,
+		Type:            domain.ImageTypeDocker,
+		Arch:            domain.ArchTypeAMD64,
+		Place:           place,
+		AvailableMemory: memory,
+	}
+}
+
+func newSchedulingStep(place domain.Place, memoryLimit string) *domain.Step {
+	return &domain.Step{
+		ID:    "step",
+		Name:  "step",
+		Place: place,
+		Job: &domain.Job{
+			Name:   "job",
+			Images: []*domain.Image{{Type: domain.ImageTypeDocker, Arch: domain.ArchTypeAMD64, Image: "job"}},
+			Limits: domain.Limits{Memory: memoryLimit},
+		},
+	}
+}
+
+func TestNormalizeScores(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  []float64
+		want []float64
+	}{
+		{name: "spread", raw: []float64{3, 1, 2}, want: []float64{1, 0, 0.5}},
+		{name: "negative", raw: []float64{-10, -30, -20}, want: []float64{1, 0, 0.5}},
+		// 差が無ければ、どの候補も満点にする
+		{name: "ties", raw: []float64{5, 5, 5}, want: []float64{1, 1, 1}},
+		{name: "single", raw: []float64{0}, want: []float64{1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := normalizeScores(tt.raw)
+			if len(got) != len(tt.want) {
+				t.Fatalf("want %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("want %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSchedulerSchedule(t *testing.T) {
+	unreachable := newSchedulingWorker("big", domain.PlaceEdge, 8*gib)
+	unreachable.SetState(worker.StateUnreachable, time.Now())
+	suspect := newSchedulingWorker("big", domain.PlaceEdge, 8*gib)
+	suspect.SetState(worker.StateSuspect, time.Now())
+	cordoned := newSchedulingWorker("big", domain.PlaceEdge, 8*gib)
+	cordoned.Cordoned = true
+	arm := newSchedulingWorker("big", domain.PlaceEdge, 8*gib)
+	arm.Arch = domain.ArchTypeARM64
+
+	tests := []struct {
+		name     string
+		step     *domain.Step
+		previous string
+		workers  []*worker.Worker
+		// 空ならErrMatchedWorkerNotFound
+		want string
+	}{
+		{
+			name:    "most free memory",
+			step:    newSchedulingStep(domain.PlaceEdge, ""),
+			workers: []*worker.Worker{newSchedulingWorker("small", domain.PlaceEdge, gib), newSchedulingWorker("big", domain.PlaceEdge, 8*gib)},
+			want:    "big",
+		},
+		{
+			name:    "unreachable is filtered",
+			step:    newSchedulingStep(domain.PlaceEdge, ""),
+			workers: []*worker.Worker{newSchedulingWorker("small", domain.PlaceEdge, gib), unreachable},
+			want:    "small",
+		},
+		{
+			name:    "suspect is still scheduled",
+			step:    newSchedulingStep(domain.PlaceEdge, ""),
+			workers: []*worker.Worker{newSchedulingWorker("small", domain.PlaceEdge, gib), suspect},
+			want:    "big",
+		},
+		{
+			name:    "cordoned is filtered",
+			step:    newSchedulingStep(domain.PlaceEdge, ""),
+			workers: []*worker.Worker{newSchedulingWorker("small", domain.PlaceEdge, gib), cordoned},
+			want:    "small",
+		},
+		{
+			name:    "arch without an image is filtered",
+			step:    newSchedulingStep(domain.PlaceEdge, ""),
+			workers: []*worker.Worker{newSchedulingWorker("small", domain.PlaceEdge, gib), arm},
+			want:    "small",
+		},
+		{
+			name:    "cloud step runs on cloud",
+			step:    newSchedulingStep(domain.PlaceCloud, ""),
+			workers: []*worker.Worker{newSchedulingWorker("cloud", domain.PlaceCloud, gib), newSchedulingWorker("big", domain.PlaceEdge, 8*gib)},
+			want:    "cloud",
+		},
+		{
+			name:    "edge step does not run on device",
+			step:    newSchedulingStep(domain.PlaceEdge, ""),
+			workers: []*worker.Worker{newSchedulingWorker("device", domain.PlaceDevice, 8*gib)},
+		},
+		{
+			name:    "memory limit",
+			step:    newSchedulingStep(domain.PlaceEdge, "2Gi"),
+			workers: []*worker.Worker{newSchedulingWorker("small", domain.PlaceEdge, gib)},
+		},
+		{
+			name:     "previous worker of an edge step",
+			step:     newSchedulingStep(domain.PlaceEdge, ""),
+			previous: "small",
+			workers:  []*worker.Worker{newSchedulingWorker("small", domain.PlaceEdge, gib), newSchedulingWorker("big", domain.PlaceEdge, 8*gib)},
+			want:     "small",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewScheduler(&OptionsNewScheduler{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sc := &SchedulingContext{Step: tt.step, PreviousJobWorkerID: tt.previous}
+			got, err := s.Schedule(context.Background(), sc, tt.workers)
+			if tt.want == "" {
+				if err != ErrMatchedWorkerNotFound {
+					t.Fatalf("want %v, got %v %v", ErrMatchedWorkerNotFound, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ID != tt.want {
+				t.Errorf("want %s, got %s", tt.want, got.ID)
+			}
+		})
+	}
+}
+
+// 差が無いプラグインの点数で、候補の間の順位が変わらない
+func TestSchedulerExplainTies(t *testing.T) {
+	s, err := NewScheduler(&OptionsNewScheduler{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wks := []*worker.Worker{
+		newSchedulingWorker("a", domain.PlaceEdge, 4*gib),
+		newSchedulingWorker("b", domain.PlaceEdge, 4*gib),
+	}
+	sc := &SchedulingContext{Step: newSchedulingStep(domain.PlaceEdge, "")}
+	_, explanations, err := s.explain(context.Background(), sc, wks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range explanations {
+		if got := e.Scores["memory"]; got != 1 {
+			t.Errorf("%s: want memory score 1 for a tie, got %v", e.WorkerID, got)
+		}
+		if e.Total != explanations[0].Total {
+			t.Errorf("%s: want total %v, got %v", e.WorkerID, explanations[0].Total, e.Total)
+		}
+	}
+}
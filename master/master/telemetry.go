@@ -0,0 +1,28 @@
+package master
+
+import (
+	"context"
+	"time"
+
+	"github.com/mobmob912/takuhai/domain"
+)
+
+func (m *Master) RecordTelemetry(ctx context.Context, sample *domain.TelemetrySample) error {
+	if sample.RecordedAt.IsZero() {
+		sample.RecordedAt = time.Now()
+	}
+	return m.telemetryRepository.Add(ctx, sample)
+}
+
+// 新しい方からlimit件の計測値で、ステップ毎の実行時間と転送時間のパーセンタイルを出す
+func (m *Master) GetWorkflowTelemetry(ctx context.Context, workflowName string, limit int) (*domain.WorkflowTelemetry, error) {
+	wf, err := m.workflowRepository.GetByName(ctx, workflowName)
+	if err != nil {
+		return nil, err
+	}
+	samples, err := m.telemetryRepository.ListByWorkflowID(ctx, wf.ID, limit)
+	if err != nil {
+		return nil, err
+	}
+	return domain.SummarizeTelemetry(wf, samples), nil
+}
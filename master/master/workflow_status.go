@@ -56,6 +56,10 @@ func (m *Master) ListWorkflowStatusesByWorkflowName(ctx context.Context, workflo
 	statuses := make([]*WorkerStepStatus, 0)
 	eg := errgroup.Group{}
 	for _, wk := range wks {
+		// 届かないワーカーに問い合わせると全体が失敗するので除く
+		if !wk.Schedulable() {
+			continue
+		}
 		wk := wk
 		eg.Go(func() error {
 			s, err := m.GetWorkerStepStatus(ctx, wk, wf.ID)
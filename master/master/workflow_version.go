@@ -0,0 +1,107 @@
+package master
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/master/repository"
+)
+
+var (
+	ErrWorkflowNameMismatch = errors.New("workflow name can not be changed")
+)
+
+// ワークフローを更新する
+// 名前の変わっていないステップはIDを引き継ぐので、実行中のrunはそのまま次のステップへ進める
+func (m *Master) UpdateWorkflow(ctx context.Context, name string, wf *domain.Workflow) error {
+	prev, err := m.workflowRepository.GetByName(ctx, name)
+	if err != nil {
+		return err
+	}
+	if wf.Name == "" {
+		wf.Name = name
+	}
+	if wf.Name != name {
+		return ErrWorkflowNameMismatch
+	}
+	if err := m.prepareWorkflow(ctx, wf, prev); err != nil {
+		return err
+	}
+	return m.saveWorkflowRevision(ctx, prev, wf)
+}
+
+func (m *Master) ListWorkflowRevisions(ctx context.Context, name string) ([]*domain.Workflow, error) {
+	wf, err := m.workflowRepository.GetByName(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return m.workflowRepository.ListRevisions(ctx, wf.ID)
+}
+
+// 過去のバージョンの内容を、新しいバージョンとして登録し直す
+func (m *Master) RollbackWorkflow(ctx context.Context, name string, version int) (*domain.Workflow, error) {
+	prev, err := m.workflowRepository.GetByName(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	wf, err := m.workflowRepository.GetRevision(ctx, prev.ID, version)
+	if err != nil {
+		return nil, err
+	}
+	// 登録時と同じく、今のワーカーで動くか検証してステップIDを引き継ぐ
+	if err := m.prepareWorkflow(ctx, wf, prev); err != nil {
+		return nil, err
+	}
+	if err := m.saveWorkflowRevision(ctx, prev, wf); err != nil {
+		return nil, err
+	}
+	return wf, nil
+}
+
+// バージョン管理の前に登録されたワークフローを、バージョン1として記録する
+func (m *Master) addInitialRevision(ctx context.Context, wf *domain.Workflow) error {
+	if wf.Version != 0 {
+		return nil
+	}
+	wf.Version = 1
+	if err := m.workflowRepository.Set(ctx, wf.ID, wf); err != nil {
+		return err
+	}
+	return m.workflowRepository.AddRevision(ctx, wf)
+}
+
+func (m *Master) saveWorkflowRevision(ctx context.Context, prev, wf *domain.Workflow) error {
+	wf.ID = prev.ID
+	wf.Version = prev.Version + 1
+	if err := m.workflowRepository.Set(ctx, wf.ID, wf); err != nil {
+		return err
+	}
+	if err := m.workflowRepository.AddRevision(ctx, wf); err != nil {
+		return err
+	}
+	if err := m.ApplyWorkflow(ctx, wf); err != nil {
+		return err
+	}
+	return m.NotifyWorkflowsToAllWorkers(ctx)
+}
+
+// runが開始された時のバージョンのステップを返す
+// バージョンが0か、そのバージョンが記録されていなければ現在のワークフローから探す
+func (m *Master) getStepOfVersion(ctx context.Context, workflowID, stepID string, version int) (*domain.Step, error) {
+	if version == 0 {
+		return m.workflowRepository.GetStep(ctx, workflowID, stepID)
+	}
+	wf, err := m.workflowRepository.GetRevision(ctx, workflowID, version)
+	if err == repository.ErrNotFound {
+		return m.workflowRepository.GetStep(ctx, workflowID, stepID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := wf.StepByID(stepID)
+	if s == nil {
+		return nil, repository.ErrNotFound
+	}
+	return s, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	// sqlのstoreで使うドライバ
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"github.com/mobmob912/takuhai/master/store"
+	"github.com/mobmob912/takuhai/master/store/bolt"
+	"github.com/mobmob912/takuhai/master/store/rdb"
+)
+
+const (
+	backendMongo = "mongo"
+	backendBolt  = "bolt"
+	backendSQL   = "sql"
+)
+
+type optionsNewRepositories struct {
+	// mongo、boltかsql. 空ならdbconfigがあればsql、無ければmongo
+	Backend  string
+	MongoURI string
+	// boltのデータを保存するファイル
+	BoltPath string
+	// sqlのdbconfigのファイルと、その中で使う環境の名前
+	DBConfigPath string
+	DBEnv        string
+}
+
+// 選んだバックエンドのリポジトリと、閉じる関数を返す
+func newRepositories(ctx context.Context, opts *optionsNewRepositories) (*repository.Repositories, func() error, error) {
+	switch backend(opts) {
+	case backendMongo:
+		c, err := mongo.NewClient(options.Client().ApplyURI(opts.MongoURI))
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := c.Connect(ctx); err != nil {
+			return nil, nil, err
+		}
+		return &repository.Repositories{
+			Worker:    store.NewWorker(c),
+			Workflow:  store.NewWorkflow(c),
+			Schedule:  store.NewSchedule(c),
+			Run:       store.NewRun(c),
+			Latency:   store.NewLatency(c),
+			Telemetry: store.NewTelemetry(c),
+		}, func() error { return c.Disconnect(context.Background()) }, nil
+	case backendBolt:
+		db, err := bolt.Open(opts.BoltPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &repository.Repositories{
+			Worker:    bolt.NewWorker(db),
+			Workflow:  bolt.NewWorkflow(db),
+			Schedule:  bolt.NewSchedule(db),
+			Run:       bolt.NewRun(db),
+			Latency:   bolt.NewLatency(db),
+			Telemetry: bolt.NewTelemetry(db),
+		}, db.Close, nil
+	case backendSQL:
+		db, err := openSQL(opts.DBConfigPath, opts.DBEnv)
+		if err != nil {
+			return nil, nil, err
+		}
+		return &repository.Repositories{
+			Worker:    rdb.NewWorker(db),
+			Workflow:  rdb.NewWorkflow(db),
+			Schedule:  rdb.NewSchedule(db),
+			Run:       rdb.NewRun(db),
+			Latency:   rdb.NewLatency(db),
+			Telemetry: rdb.NewTelemetry(db),
+		}, db.Close, nil
+	}
+	return nil, nil, fmt.Errorf("unknown store %s. store must be %s, %s or %s", opts.Backend, backendMongo, backendBolt, backendSQL)
+}
+
+// dbconfigを指定したら、そのdialectのsqlのstoreを使う
+func backend(opts *optionsNewRepositories) string {
+	if opts.Backend != "" {
+		return opts.Backend
+	}
+	if opts.DBConfigPath != "" {
+		return backendSQL
+	}
+	return backendMongo
+}
+
+// dbconfigのenvの設定で開いて、まだ当てていないマイグレーションを当てる
+func openSQL(path, env string) (*sqlx.DB, error) {
+	if path == "" {
+		return nil, errors.New("dbconfig is required for the sql store")
+	}
+	configs, err := store.NewDBConfigsFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c, ok := configs[env]
+	if !ok {
+		return nil, fmt.Errorf("environment %s is not found in %s", env, path)
+	}
+	db, err := c.OpenSQL()
+	if err != nil {
+		return nil, err
+	}
+	n, err := c.Migrate(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	log.Printf("applied %d migrations from %s", n, c.Dir)
+	return db, nil
+}
+
+func exportRepositories(ctx context.Context, r *repository.Repositories, path string) error {
+	d, err := repository.Export(ctx, r)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
+
+func importRepositories(ctx context.Context, r *repository.Repositories, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var d repository.Dump
+	if err := json.NewDecoder(f).Decode(&d); err != nil {
+		return err
+	}
+	return repository.Import(ctx, r, &d)
+}
@@ -0,0 +1,58 @@
+// Package bolt はmasterのリポジトリを、1つのファイルに保存するbboltで実装する
+// MongoDBを動かせないedgeのゲートウェイなどで、masterを単体で動かすのに使う
+package bolt
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"go.etcd.io/bbolt"
+)
+
+var buckets = [][]byte{
+	workflowBucket,
+	workflowRevisionBucket,
+	scheduleBucket,
+	runBucket,
+	workerBucket,
+	latencyBucket,
+	telemetryBucket,
+}
+
+// pathのファイルを開く. 無ければ作る
+func Open(path string) (*bbolt.DB, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, b := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func get(tx *bbolt.Tx, bucket, key []byte, v interface{}) error {
+	b := tx.Bucket(bucket).Get(key)
+	if b == nil {
+		return repository.ErrNotFound
+	}
+	return json.Unmarshal(b, v)
+}
+
+func put(tx *bbolt.Tx, bucket, key []byte, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket(bucket).Put(key, b)
+}
@@ -0,0 +1,68 @@
+package bolt
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.etcd.io/bbolt"
+
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"github.com/mobmob912/takuhai/master/master/repository/repositorytest"
+)
+
+// 一時ディレクトリに作り、テストが終わったら消す
+func openTestDB(t *testing.T) *bbolt.DB {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "takuhai-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err := Open(filepath.Join(dir, "takuhai.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		os.RemoveAll(dir)
+	})
+	return db
+}
+
+func TestWorker(t *testing.T) {
+	repositorytest.TestWorker(t, func(t *testing.T) repository.Worker {
+		return NewWorker(openTestDB(t))
+	})
+}
+
+func TestWorkflow(t *testing.T) {
+	repositorytest.TestWorkflow(t, func(t *testing.T) repository.Workflow {
+		return NewWorkflow(openTestDB(t))
+	})
+}
+
+func TestRun(t *testing.T) {
+	repositorytest.TestRun(t, func(t *testing.T) repository.Run {
+		return NewRun(openTestDB(t))
+	})
+}
+
+func TestSchedule(t *testing.T) {
+	repositorytest.TestSchedule(t, func(t *testing.T) repository.Schedule {
+		return NewSchedule(openTestDB(t))
+	})
+}
+
+func TestLatency(t *testing.T) {
+	repositorytest.TestLatency(t, func(t *testing.T) repository.Latency {
+		return NewLatency(openTestDB(t))
+	})
+}
+
+func TestTelemetry(t *testing.T) {
+	repositorytest.TestTelemetry(t, func(t *testing.T) repository.Telemetry {
+		return NewTelemetry(openTestDB(t))
+	})
+}
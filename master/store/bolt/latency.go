@@ -0,0 +1,80 @@
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"github.com/mobmob912/takuhai/master/worker"
+	"go.etcd.io/bbolt"
+)
+
+type latency struct {
+	db *bbolt.DB
+}
+
+var (
+	// k=計測したワーカーのID\x00相手のワーカーのID
+	latencyBucket = []byte("latency")
+)
+
+func NewLatency(db *bbolt.DB) repository.Latency {
+	return &latency{
+		db: db,
+	}
+}
+
+func latencyKey(fromID, toID string) []byte {
+	return []byte(fromID + "\x00" + toID)
+}
+
+func (l *latency) ListAll(ctx context.Context) ([]*worker.Latency, error) {
+	ls := make([]*worker.Latency, 0)
+	err := l.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(latencyBucket).ForEach(func(k, v []byte) error {
+			var lt worker.Latency
+			if err := json.Unmarshal(v, &lt); err != nil {
+				return err
+			}
+			ls = append(ls, &lt)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ls, nil
+}
+
+func (l *latency) Set(ctx context.Context, lt *worker.Latency) error {
+	return l.db.Update(func(tx *bbolt.Tx) error {
+		return put(tx, latencyBucket, latencyKey(lt.FromID, lt.ToID), lt)
+	})
+}
+
+func (l *latency) DeleteByWorkerID(ctx context.Context, workerID string) error {
+	return l.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(latencyBucket)
+		keys := make([][]byte, 0)
+		err := b.ForEach(func(k, v []byte) error {
+			var lt worker.Latency
+			if err := json.Unmarshal(v, &lt); err != nil {
+				return err
+			}
+			if lt.FromID == workerID || lt.ToID == workerID {
+				keys = append(keys, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		// ForEachの中では消せないので後からまとめて消す
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,68 @@
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"go.etcd.io/bbolt"
+)
+
+type run struct {
+	db *bbolt.DB
+}
+
+var (
+	runBucket = []byte("run")
+)
+
+func NewRun(db *bbolt.DB) repository.Run {
+	return &run{
+		db: db,
+	}
+}
+
+func (r *run) Get(ctx context.Context, id string) (*domain.Run, error) {
+	var rn domain.Run
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		return get(tx, runBucket, []byte(id), &rn)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &rn, nil
+}
+
+func (r *run) ListByWorkflowID(ctx context.Context, workflowID string, limit int) ([]*domain.Run, error) {
+	runs := make([]*domain.Run, 0)
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(runBucket).ForEach(func(k, v []byte) error {
+			var rn domain.Run
+			if err := json.Unmarshal(v, &rn); err != nil {
+				return err
+			}
+			if rn.WorkflowID == workflowID {
+				runs = append(runs, &rn)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].StartedAt.After(runs[j].StartedAt)
+	})
+	if limit > 0 && len(runs) > limit {
+		runs = runs[:limit]
+	}
+	return runs, nil
+}
+
+func (r *run) Set(ctx context.Context, rn *domain.Run) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		return put(tx, runBucket, []byte(rn.ID), rn)
+	})
+}
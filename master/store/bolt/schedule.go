@@ -0,0 +1,41 @@
+package bolt
+
+import (
+	"context"
+	"time"
+
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"go.etcd.io/bbolt"
+)
+
+type schedule struct {
+	db *bbolt.DB
+}
+
+var (
+	// k=ワークフローID, v=最終実行時刻
+	scheduleBucket = []byte("schedule")
+)
+
+func NewSchedule(db *bbolt.DB) repository.Schedule {
+	return &schedule{
+		db: db,
+	}
+}
+
+func (s *schedule) GetLastFiredAt(ctx context.Context, workflowID string) (time.Time, error) {
+	var t time.Time
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return get(tx, scheduleBucket, []byte(workflowID), &t)
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t, nil
+}
+
+func (s *schedule) SetLastFiredAt(ctx context.Context, workflowID string, t time.Time) error {
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		return put(tx, scheduleBucket, []byte(workflowID), t)
+	})
+}
@@ -0,0 +1,77 @@
+package bolt
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"go.etcd.io/bbolt"
+)
+
+type telemetry struct {
+	db *bbolt.DB
+}
+
+var (
+	// ワークフローID毎のバケットの中に、k=記録時刻と連番で古い順に並べる
+	telemetryBucket = []byte("telemetry")
+)
+
+func NewTelemetry(db *bbolt.DB) repository.Telemetry {
+	return &telemetry{
+		db: db,
+	}
+}
+
+func telemetryKey(sample *domain.TelemetrySample, seq uint64) []byte {
+	k := make([]byte, 16)
+	binary.BigEndian.PutUint64(k[:8], uint64(sample.RecordedAt.UnixNano()))
+	binary.BigEndian.PutUint64(k[8:], seq)
+	return k
+}
+
+func (t *telemetry) Add(ctx context.Context, sample *domain.TelemetrySample) error {
+	return t.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.Bucket(telemetryBucket).CreateBucketIfNotExists([]byte(sample.WorkflowID))
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		v, err := json.Marshal(sample)
+		if err != nil {
+			return err
+		}
+		return b.Put(telemetryKey(sample, seq), v)
+	})
+}
+
+func (t *telemetry) ListByWorkflowID(ctx context.Context, workflowID string, limit int) ([]*domain.TelemetrySample, error) {
+	samples := make([]*domain.TelemetrySample, 0)
+	err := t.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(telemetryBucket).Bucket([]byte(workflowID))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if limit > 0 && len(samples) >= limit {
+				break
+			}
+			var s domain.TelemetrySample
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			samples = append(samples, &s)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
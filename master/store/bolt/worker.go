@@ -0,0 +1,91 @@
+package bolt
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"github.com/mobmob912/takuhai/master/worker"
+	"go.etcd.io/bbolt"
+)
+
+type workerStore struct {
+	db *bbolt.DB
+}
+
+var (
+	workerBucket = []byte("worker")
+)
+
+func NewWorker(db *bbolt.DB) repository.Worker {
+	return &workerStore{
+		db: db,
+	}
+}
+
+func (w *workerStore) Get(ctx context.Context, id string) (*worker.Worker, error) {
+	var wk worker.Worker
+	err := w.db.View(func(tx *bbolt.Tx) error {
+		return get(tx, workerBucket, []byte(id), &wk)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &wk, nil
+}
+
+func (w *workerStore) ListAll(ctx context.Context) ([]*worker.Worker, error) {
+	ws := make([]*worker.Worker, 0)
+	err := w.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(workerBucket).ForEach(func(k, v []byte) error {
+			var wk worker.Worker
+			if err := json.Unmarshal(v, &wk); err != nil {
+				return err
+			}
+			ws = append(ws, &wk)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
+
+func (w *workerStore) ListClouds(ctx context.Context) ([]*worker.Worker, error) {
+	ws, err := w.ListAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	clouds := make([]*worker.Worker, 0, len(ws))
+	for _, wk := range ws {
+		if wk.Place == domain.PlaceCloud {
+			clouds = append(clouds, wk)
+		}
+	}
+	return clouds, nil
+}
+
+func (w *workerStore) Set(ctx context.Context, id string, wk *worker.Worker) error {
+	wk.ID = id
+	return w.db.Update(func(tx *bbolt.Tx) error {
+		return put(tx, workerBucket, []byte(id), wk)
+	})
+}
+
+func (w *workerStore) Update(ctx context.Context, id string, wk *worker.Worker) error {
+	wk.ID = id
+	return w.db.Update(func(tx *bbolt.Tx) error {
+		if tx.Bucket(workerBucket).Get([]byte(id)) == nil {
+			return repository.ErrNotFound
+		}
+		return put(tx, workerBucket, []byte(id), wk)
+	})
+}
+
+func (w *workerStore) Delete(ctx context.Context, id string) error {
+	return w.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(workerBucket).Delete([]byte(id))
+	})
+}
@@ -0,0 +1,138 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"go.etcd.io/bbolt"
+)
+
+type workflow struct {
+	db *bbolt.DB
+}
+
+var (
+	workflowBucket = []byte("workflow")
+	// k=ワークフローID/バージョン. バージョンの順に並ぶように0埋めする
+	workflowRevisionBucket = []byte("workflow_revision")
+)
+
+func NewWorkflow(db *bbolt.DB) repository.Workflow {
+	return &workflow{
+		db: db,
+	}
+}
+
+func revisionKey(id string, version int) []byte {
+	return []byte(fmt.Sprintf("%s/%010d", id, version))
+}
+
+func (w *workflow) Get(ctx context.Context, id string) (*domain.Workflow, error) {
+	var wf domain.Workflow
+	err := w.db.View(func(tx *bbolt.Tx) error {
+		return get(tx, workflowBucket, []byte(id), &wf)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &wf, nil
+}
+
+func (w *workflow) GetByName(ctx context.Context, name string) (*domain.Workflow, error) {
+	wfs, err := w.ListAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, wf := range wfs {
+		if wf.Name == name {
+			return wf, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (w *workflow) GetStep(ctx context.Context, workflowID, stepID string) (*domain.Step, error) {
+	wf, err := w.Get(ctx, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	s := wf.StepByID(stepID)
+	if s == nil {
+		return nil, repository.ErrNotFound
+	}
+	return s, nil
+}
+
+func (w *workflow) ListAll(ctx context.Context) ([]*domain.Workflow, error) {
+	wfs := make([]*domain.Workflow, 0)
+	err := w.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(workflowBucket).ForEach(func(k, v []byte) error {
+			var wf domain.Workflow
+			if err := json.Unmarshal(v, &wf); err != nil {
+				return err
+			}
+			wfs = append(wfs, &wf)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return wfs, nil
+}
+
+func (w *workflow) CheckExistByByName(ctx context.Context, name string) (bool, error) {
+	_, err := w.GetByName(ctx, name)
+	if err == repository.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (w *workflow) Set(ctx context.Context, id string, wf *domain.Workflow) error {
+	wf.ID = id
+	return w.db.Update(func(tx *bbolt.Tx) error {
+		return put(tx, workflowBucket, []byte(id), wf)
+	})
+}
+
+func (w *workflow) AddRevision(ctx context.Context, wf *domain.Workflow) error {
+	return w.db.Update(func(tx *bbolt.Tx) error {
+		return put(tx, workflowRevisionBucket, revisionKey(wf.ID, wf.Version), wf)
+	})
+}
+
+func (w *workflow) GetRevision(ctx context.Context, id string, version int) (*domain.Workflow, error) {
+	var wf domain.Workflow
+	err := w.db.View(func(tx *bbolt.Tx) error {
+		return get(tx, workflowRevisionBucket, revisionKey(id, version), &wf)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &wf, nil
+}
+
+func (w *workflow) ListRevisions(ctx context.Context, id string) ([]*domain.Workflow, error) {
+	wfs := make([]*domain.Workflow, 0)
+	prefix := []byte(id + "/")
+	err := w.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(workflowRevisionBucket).Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			var wf domain.Workflow
+			if err := json.Unmarshal(v, &wf); err != nil {
+				return err
+			}
+			wfs = append(wfs, &wf)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return wfs, nil
+}
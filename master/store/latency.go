@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"github.com/mobmob912/takuhai/master/worker"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type latency struct {
+	client *mongo.Client
+}
+
+const (
+	latencyCollection = "latency"
+)
+
+func NewLatency(c *mongo.Client) repository.Latency {
+	return &latency{
+		client: c,
+	}
+}
+
+func (l *latency) ListAll(ctx context.Context) ([]*worker.Latency, error) {
+	ls := make([]*worker.Latency, 0)
+	collection := l.client.Database(databaseName).Collection(latencyCollection)
+	cur, err := collection.Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var lt worker.Latency
+		if err := cur.Decode(&lt); err != nil {
+			return nil, err
+		}
+		ls = append(ls, &lt)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	return ls, nil
+}
+
+func (l *latency) Set(ctx context.Context, lt *worker.Latency) error {
+	collection := l.client.Database(databaseName).Collection(latencyCollection)
+	opts := options.Replace().SetUpsert(true)
+	filter := bson.D{{"fromid", lt.FromID}, {"toid", lt.ToID}}
+	if _, err := collection.ReplaceOne(ctx, filter, lt, opts); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (l *latency) DeleteByWorkerID(ctx context.Context, workerID string) error {
+	collection := l.client.Database(databaseName).Collection(latencyCollection)
+	filter := bson.D{{"$or", bson.A{
+		bson.D{{"fromid", workerID}},
+		bson.D{{"toid", workerID}},
+	}}}
+	if _, err := collection.DeleteMany(ctx, filter); err != nil {
+		return err
+	}
+	return nil
+}
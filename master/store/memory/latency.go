@@ -0,0 +1,55 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"github.com/mobmob912/takuhai/master/worker"
+)
+
+type latencyKey struct {
+	fromID string
+	toID   string
+}
+
+type latency struct {
+	mu        sync.RWMutex
+	latencies map[latencyKey]*worker.Latency
+}
+
+func NewLatency() repository.Latency {
+	return &latency{
+		latencies: make(map[latencyKey]*worker.Latency),
+	}
+}
+
+func (l *latency) ListAll(ctx context.Context) ([]*worker.Latency, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	ls := make([]*worker.Latency, 0, len(l.latencies))
+	for _, lt := range l.latencies {
+		c := *lt
+		ls = append(ls, &c)
+	}
+	return ls, nil
+}
+
+func (l *latency) Set(ctx context.Context, lt *worker.Latency) error {
+	c := *lt
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.latencies[latencyKey{fromID: lt.FromID, toID: lt.ToID}] = &c
+	return nil
+}
+
+func (l *latency) DeleteByWorkerID(ctx context.Context, workerID string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for k := range l.latencies {
+		if k.fromID == workerID || k.toID == workerID {
+			delete(l.latencies, k)
+		}
+	}
+	return nil
+}
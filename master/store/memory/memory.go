@@ -0,0 +1,16 @@
+// Package memory はリポジトリをメモリ上に実装する
+// repositorytestの基準になる実装で、masterを再起動するとデータは消える
+package memory
+
+import (
+	"encoding/json"
+)
+
+// 呼び出し側が書き換えても保存した値が変わらないように、JSONを通してコピーする
+func clone(src, dst interface{}) error {
+	b, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
@@ -0,0 +1,44 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"github.com/mobmob912/takuhai/master/master/repository/repositorytest"
+)
+
+func TestWorker(t *testing.T) {
+	repositorytest.TestWorker(t, func(t *testing.T) repository.Worker {
+		return NewWorker()
+	})
+}
+
+func TestWorkflow(t *testing.T) {
+	repositorytest.TestWorkflow(t, func(t *testing.T) repository.Workflow {
+		return NewWorkflow()
+	})
+}
+
+func TestRun(t *testing.T) {
+	repositorytest.TestRun(t, func(t *testing.T) repository.Run {
+		return NewRun()
+	})
+}
+
+func TestSchedule(t *testing.T) {
+	repositorytest.TestSchedule(t, func(t *testing.T) repository.Schedule {
+		return NewSchedule()
+	})
+}
+
+func TestLatency(t *testing.T) {
+	repositorytest.TestLatency(t, func(t *testing.T) repository.Latency {
+		return NewLatency()
+	})
+}
+
+func TestTelemetry(t *testing.T) {
+	repositorytest.TestTelemetry(t, func(t *testing.T) repository.Telemetry {
+		return NewTelemetry()
+	})
+}
@@ -0,0 +1,69 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/master/repository"
+)
+
+type run struct {
+	mu   sync.RWMutex
+	runs map[string]*domain.Run
+}
+
+func NewRun() repository.Run {
+	return &run{
+		runs: make(map[string]*domain.Run),
+	}
+}
+
+func (r *run) Get(ctx context.Context, id string) (*domain.Run, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rn, ok := r.runs[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	var c domain.Run
+	if err := clone(rn, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (r *run) ListByWorkflowID(ctx context.Context, workflowID string, limit int) ([]*domain.Run, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	runs := make([]*domain.Run, 0)
+	for _, rn := range r.runs {
+		if rn.WorkflowID != workflowID {
+			continue
+		}
+		var c domain.Run
+		if err := clone(rn, &c); err != nil {
+			return nil, err
+		}
+		runs = append(runs, &c)
+	}
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].StartedAt.After(runs[j].StartedAt)
+	})
+	if limit > 0 && len(runs) > limit {
+		runs = runs[:limit]
+	}
+	return runs, nil
+}
+
+func (r *run) Set(ctx context.Context, rn *domain.Run) error {
+	var c domain.Run
+	if err := clone(rn, &c); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.runs[rn.ID] = &c
+	return nil
+}
@@ -0,0 +1,38 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mobmob912/takuhai/master/master/repository"
+)
+
+type schedule struct {
+	mu sync.RWMutex
+	// k=ワークフローID, v=最終実行時刻
+	lastFiredAt map[string]time.Time
+}
+
+func NewSchedule() repository.Schedule {
+	return &schedule{
+		lastFiredAt: make(map[string]time.Time),
+	}
+}
+
+func (s *schedule) GetLastFiredAt(ctx context.Context, workflowID string) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.lastFiredAt[workflowID]
+	if !ok {
+		return time.Time{}, repository.ErrNotFound
+	}
+	return t, nil
+}
+
+func (s *schedule) SetLastFiredAt(ctx context.Context, workflowID string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastFiredAt[workflowID] = t
+	return nil
+}
@@ -0,0 +1,49 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/master/repository"
+)
+
+type telemetry struct {
+	mu sync.RWMutex
+	// k=ワークフローID, v=追加した順
+	samples map[string][]*domain.TelemetrySample
+}
+
+func NewTelemetry() repository.Telemetry {
+	return &telemetry{
+		samples: make(map[string][]*domain.TelemetrySample),
+	}
+}
+
+func (t *telemetry) Add(ctx context.Context, sample *domain.TelemetrySample) error {
+	c := *sample
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.samples[sample.WorkflowID] = append(t.samples[sample.WorkflowID], &c)
+	return nil
+}
+
+// 記録時刻が同じなら後から追加した方を先にする
+func (t *telemetry) ListByWorkflowID(ctx context.Context, workflowID string, limit int) ([]*domain.TelemetrySample, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	ss := t.samples[workflowID]
+	samples := make([]*domain.TelemetrySample, 0, len(ss))
+	for i := len(ss) - 1; i >= 0; i-- {
+		c := *ss[i]
+		samples = append(samples, &c)
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].RecordedAt.After(samples[j].RecordedAt)
+	})
+	if limit > 0 && len(samples) > limit {
+		samples = samples[:limit]
+	}
+	return samples, nil
+}
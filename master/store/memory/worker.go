@@ -0,0 +1,96 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"github.com/mobmob912/takuhai/master/worker"
+)
+
+type workerStore struct {
+	mu      sync.RWMutex
+	workers map[string]*worker.Worker
+}
+
+func NewWorker() repository.Worker {
+	return &workerStore{
+		workers: make(map[string]*worker.Worker),
+	}
+}
+
+func (w *workerStore) Get(ctx context.Context, id string) (*worker.Worker, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	wk, ok := w.workers[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	var c worker.Worker
+	if err := clone(wk, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (w *workerStore) ListAll(ctx context.Context) ([]*worker.Worker, error) {
+	return w.list(func(*worker.Worker) bool { return true })
+}
+
+func (w *workerStore) ListClouds(ctx context.Context) ([]*worker.Worker, error) {
+	return w.list(func(wk *worker.Worker) bool { return wk.Place == domain.PlaceCloud })
+}
+
+func (w *workerStore) list(match func(*worker.Worker) bool) ([]*worker.Worker, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	ws := make([]*worker.Worker, 0, len(w.workers))
+	for _, wk := range w.workers {
+		if !match(wk) {
+			continue
+		}
+		var c worker.Worker
+		if err := clone(wk, &c); err != nil {
+			return nil, err
+		}
+		ws = append(ws, &c)
+	}
+	sort.Slice(ws, func(i, j int) bool { return ws[i].ID < ws[j].ID })
+	return ws, nil
+}
+
+func (w *workerStore) Set(ctx context.Context, id string, wk *worker.Worker) error {
+	wk.ID = id
+	var c worker.Worker
+	if err := clone(wk, &c); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.workers[id] = &c
+	return nil
+}
+
+func (w *workerStore) Update(ctx context.Context, id string, wk *worker.Worker) error {
+	wk.ID = id
+	var c worker.Worker
+	if err := clone(wk, &c); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, ok := w.workers[id]; !ok {
+		return repository.ErrNotFound
+	}
+	w.workers[id] = &c
+	return nil
+}
+
+func (w *workerStore) Delete(ctx context.Context, id string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.workers, id)
+	return nil
+}
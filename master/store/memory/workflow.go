@@ -0,0 +1,139 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/master/repository"
+)
+
+type workflow struct {
+	mu        sync.RWMutex
+	workflows map[string]*domain.Workflow
+	// k=ワークフローID, v=追加した順
+	revisions map[string][]*domain.Workflow
+}
+
+func NewWorkflow() repository.Workflow {
+	return &workflow{
+		workflows: make(map[string]*domain.Workflow),
+		revisions: make(map[string][]*domain.Workflow),
+	}
+}
+
+func cloneWorkflow(wf *domain.Workflow) (*domain.Workflow, error) {
+	var c domain.Workflow
+	if err := clone(wf, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (w *workflow) Get(ctx context.Context, id string) (*domain.Workflow, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	wf, ok := w.workflows[id]
+	if !ok {
+		return nil, repository.ErrNotFound
+	}
+	return cloneWorkflow(wf)
+}
+
+func (w *workflow) GetByName(ctx context.Context, name string) (*domain.Workflow, error) {
+	wfs, err := w.ListAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, wf := range wfs {
+		if wf.Name == name {
+			return wf, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (w *workflow) GetStep(ctx context.Context, workflowID, stepID string) (*domain.Step, error) {
+	wf, err := w.Get(ctx, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	s := wf.StepByID(stepID)
+	if s == nil {
+		return nil, repository.ErrNotFound
+	}
+	return s, nil
+}
+
+func (w *workflow) ListAll(ctx context.Context) ([]*domain.Workflow, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	wfs := make([]*domain.Workflow, 0, len(w.workflows))
+	for _, wf := range w.workflows {
+		c, err := cloneWorkflow(wf)
+		if err != nil {
+			return nil, err
+		}
+		wfs = append(wfs, c)
+	}
+	sort.Slice(wfs, func(i, j int) bool { return wfs[i].ID < wfs[j].ID })
+	return wfs, nil
+}
+
+func (w *workflow) CheckExistByByName(ctx context.Context, name string) (bool, error) {
+	_, err := w.GetByName(ctx, name)
+	if err == repository.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (w *workflow) Set(ctx context.Context, id string, wf *domain.Workflow) error {
+	wf.ID = id
+	c, err := cloneWorkflow(wf)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.workflows[id] = c
+	return nil
+}
+
+func (w *workflow) AddRevision(ctx context.Context, wf *domain.Workflow) error {
+	c, err := cloneWorkflow(wf)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.revisions[wf.ID] = append(w.revisions[wf.ID], c)
+	return nil
+}
+
+func (w *workflow) GetRevision(ctx context.Context, id string, version int) (*domain.Workflow, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	for _, wf := range w.revisions[id] {
+		if wf.Version == version {
+			return cloneWorkflow(wf)
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+func (w *workflow) ListRevisions(ctx context.Context, id string) ([]*domain.Workflow, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	wfs := make([]*domain.Workflow, 0, len(w.revisions[id]))
+	for _, wf := range w.revisions[id] {
+		c, err := cloneWorkflow(wf)
+		if err != nil {
+			return nil, err
+		}
+		wfs = append(wfs, c)
+	}
+	sort.SliceStable(wfs, func(i, j int) bool { return wfs[i].Version < wfs[j].Version })
+	return wfs, nil
+}
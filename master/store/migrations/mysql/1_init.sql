@@ -0,0 +1,58 @@
+-- +migrate Up
+CREATE TABLE workflows (
+  id VARCHAR(64) NOT NULL PRIMARY KEY,
+  name VARCHAR(255) NOT NULL,
+  data LONGTEXT NOT NULL,
+  INDEX workflows_name (name)
+);
+
+CREATE TABLE workflow_revisions (
+  id VARCHAR(64) NOT NULL,
+  version INT NOT NULL,
+  data LONGTEXT NOT NULL,
+  PRIMARY KEY (id, version)
+);
+
+CREATE TABLE schedules (
+  workflow_id VARCHAR(64) NOT NULL PRIMARY KEY,
+  last_fired_at BIGINT NOT NULL
+);
+
+CREATE TABLE runs (
+  id VARCHAR(64) NOT NULL PRIMARY KEY,
+  workflow_id VARCHAR(64) NOT NULL,
+  started_at BIGINT NOT NULL,
+  data LONGTEXT NOT NULL,
+  INDEX runs_workflow_id_started_at (workflow_id, started_at)
+);
+
+CREATE TABLE workers (
+  id VARCHAR(64) NOT NULL PRIMARY KEY,
+  place VARCHAR(32) NOT NULL,
+  data LONGTEXT NOT NULL
+);
+
+CREATE TABLE latencies (
+  from_id VARCHAR(64) NOT NULL,
+  to_id VARCHAR(64) NOT NULL,
+  data TEXT NOT NULL,
+  PRIMARY KEY (from_id, to_id),
+  INDEX latencies_to_id (to_id)
+);
+
+CREATE TABLE telemetry (
+  id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  workflow_id VARCHAR(64) NOT NULL,
+  recorded_at BIGINT NOT NULL,
+  data TEXT NOT NULL,
+  INDEX telemetry_workflow_id_recorded_at (workflow_id, recorded_at)
+);
+
+-- +migrate Down
+DROP TABLE telemetry;
+DROP TABLE latencies;
+DROP TABLE workers;
+DROP TABLE runs;
+DROP TABLE schedules;
+DROP TABLE workflow_revisions;
+DROP TABLE workflows;
@@ -0,0 +1,58 @@
+-- +migrate Up
+CREATE TABLE workflows (
+  id TEXT NOT NULL PRIMARY KEY,
+  name TEXT NOT NULL,
+  data TEXT NOT NULL
+);
+CREATE INDEX workflows_name ON workflows (name);
+
+CREATE TABLE workflow_revisions (
+  id TEXT NOT NULL,
+  version INTEGER NOT NULL,
+  data TEXT NOT NULL,
+  PRIMARY KEY (id, version)
+);
+
+CREATE TABLE schedules (
+  workflow_id TEXT NOT NULL PRIMARY KEY,
+  last_fired_at INTEGER NOT NULL
+);
+
+CREATE TABLE runs (
+  id TEXT NOT NULL PRIMARY KEY,
+  workflow_id TEXT NOT NULL,
+  started_at INTEGER NOT NULL,
+  data TEXT NOT NULL
+);
+CREATE INDEX runs_workflow_id_started_at ON runs (workflow_id, started_at);
+
+CREATE TABLE workers (
+  id TEXT NOT NULL PRIMARY KEY,
+  place TEXT NOT NULL,
+  data TEXT NOT NULL
+);
+
+CREATE TABLE latencies (
+  from_id TEXT NOT NULL,
+  to_id TEXT NOT NULL,
+  data TEXT NOT NULL,
+  PRIMARY KEY (from_id, to_id)
+);
+CREATE INDEX latencies_to_id ON latencies (to_id);
+
+CREATE TABLE telemetry (
+  id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+  workflow_id TEXT NOT NULL,
+  recorded_at INTEGER NOT NULL,
+  data TEXT NOT NULL
+);
+CREATE INDEX telemetry_workflow_id_recorded_at ON telemetry (workflow_id, recorded_at);
+
+-- +migrate Down
+DROP TABLE telemetry;
+DROP TABLE latencies;
+DROP TABLE workers;
+DROP TABLE runs;
+DROP TABLE schedules;
+DROP TABLE workflow_revisions;
+DROP TABLE workflows;
@@ -0,0 +1,50 @@
+package rdb
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"github.com/mobmob912/takuhai/master/worker"
+)
+
+type latency struct {
+	db *sqlx.DB
+}
+
+func NewLatency(db *sqlx.DB) repository.Latency {
+	return &latency{
+		db: db,
+	}
+}
+
+func (l *latency) ListAll(ctx context.Context) ([]*worker.Latency, error) {
+	ls := make([]*worker.Latency, 0)
+	err := selectData(ctx, l.db, func(data []byte) error {
+		var lt worker.Latency
+		if err := json.Unmarshal(data, &lt); err != nil {
+			return err
+		}
+		ls = append(ls, &lt)
+		return nil
+	}, "SELECT data FROM latencies")
+	if err != nil {
+		return nil, err
+	}
+	return ls, nil
+}
+
+func (l *latency) Set(ctx context.Context, lt *worker.Latency) error {
+	data, err := marshal(lt)
+	if err != nil {
+		return err
+	}
+	_, err = l.db.ExecContext(ctx, "REPLACE INTO latencies (from_id, to_id, data) VALUES (?, ?, ?)", lt.FromID, lt.ToID, data)
+	return err
+}
+
+func (l *latency) DeleteByWorkerID(ctx context.Context, workerID string) error {
+	_, err := l.db.ExecContext(ctx, "DELETE FROM latencies WHERE from_id = ? OR to_id = ?", workerID, workerID)
+	return err
+}
@@ -0,0 +1,57 @@
+// Package rdb はmasterのリポジトリをMySQLかSQLiteで実装する
+// テーブルはstore/migrationsのマイグレーションで作り、ドメインのデータはdata列にJSONで持つ
+package rdb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mobmob912/takuhai/master/master/repository"
+)
+
+// 1行のdata列を読む
+func getData(ctx context.Context, db *sqlx.DB, v interface{}, query string, args ...interface{}) error {
+	var data string
+	if err := db.QueryRowContext(ctx, query, args...).Scan(&data); err != nil {
+		if err == sql.ErrNoRows {
+			return repository.ErrNotFound
+		}
+		return err
+	}
+	return json.Unmarshal([]byte(data), v)
+}
+
+// 全ての行のdata列を読んで、1行ずつfに渡す
+func selectData(ctx context.Context, db *sqlx.DB, f func(data []byte) error, query string, args ...interface{}) error {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		if err := f([]byte(data)); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func marshal(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// 時刻はunix nanoで持つ. MySQLとSQLiteで同じように並べられるように
+func toNano(t time.Time) int64 {
+	return t.UnixNano()
+}
@@ -0,0 +1,75 @@
+package rdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"github.com/mobmob912/takuhai/master/master/repository/repositorytest"
+	"github.com/mobmob912/takuhai/master/store"
+)
+
+// 一時ディレクトリのSQLiteにマイグレーションを当てて、テストが終わったら消す
+func openTestDB(t *testing.T) *sqlx.DB {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "takuhai-rdb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	c := &store.DBConfig{
+		Dialect:    "sqlite3",
+		Datasource: filepath.Join(dir, "takuhai.db"),
+		Dir:        "../migrations/sqlite3",
+	}
+	db, err := c.OpenSQL()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if _, err := c.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestWorker(t *testing.T) {
+	repositorytest.TestWorker(t, func(t *testing.T) repository.Worker {
+		return NewWorker(openTestDB(t))
+	})
+}
+
+func TestWorkflow(t *testing.T) {
+	repositorytest.TestWorkflow(t, func(t *testing.T) repository.Workflow {
+		return NewWorkflow(openTestDB(t))
+	})
+}
+
+func TestRun(t *testing.T) {
+	repositorytest.TestRun(t, func(t *testing.T) repository.Run {
+		return NewRun(openTestDB(t))
+	})
+}
+
+func TestSchedule(t *testing.T) {
+	repositorytest.TestSchedule(t, func(t *testing.T) repository.Schedule {
+		return NewSchedule(openTestDB(t))
+	})
+}
+
+func TestLatency(t *testing.T) {
+	repositorytest.TestLatency(t, func(t *testing.T) repository.Latency {
+		return NewLatency(openTestDB(t))
+	})
+}
+
+func TestTelemetry(t *testing.T) {
+	repositorytest.TestTelemetry(t, func(t *testing.T) repository.Telemetry {
+		return NewTelemetry(openTestDB(t))
+	})
+}
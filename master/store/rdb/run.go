@@ -0,0 +1,59 @@
+package rdb
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/master/repository"
+)
+
+type run struct {
+	db *sqlx.DB
+}
+
+func NewRun(db *sqlx.DB) repository.Run {
+	return &run{
+		db: db,
+	}
+}
+
+func (r *run) Get(ctx context.Context, id string) (*domain.Run, error) {
+	var rn domain.Run
+	if err := getData(ctx, r.db, &rn, "SELECT data FROM runs WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+	return &rn, nil
+}
+
+func (r *run) ListByWorkflowID(ctx context.Context, workflowID string, limit int) ([]*domain.Run, error) {
+	query := "SELECT data FROM runs WHERE workflow_id = ? ORDER BY started_at DESC"
+	args := []interface{}{workflowID}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	runs := make([]*domain.Run, 0)
+	err := selectData(ctx, r.db, func(data []byte) error {
+		var rn domain.Run
+		if err := json.Unmarshal(data, &rn); err != nil {
+			return err
+		}
+		runs = append(runs, &rn)
+		return nil
+	}, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+func (r *run) Set(ctx context.Context, rn *domain.Run) error {
+	data, err := marshal(rn)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, "REPLACE INTO runs (id, workflow_id, started_at, data) VALUES (?, ?, ?, ?)", rn.ID, rn.WorkflowID, toNano(rn.StartedAt), data)
+	return err
+}
@@ -0,0 +1,36 @@
+package rdb
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mobmob912/takuhai/master/master/repository"
+)
+
+type schedule struct {
+	db *sqlx.DB
+}
+
+func NewSchedule(db *sqlx.DB) repository.Schedule {
+	return &schedule{
+		db: db,
+	}
+}
+
+func (s *schedule) GetLastFiredAt(ctx context.Context, workflowID string) (time.Time, error) {
+	var nano int64
+	if err := s.db.QueryRowContext(ctx, "SELECT last_fired_at FROM schedules WHERE workflow_id = ?", workflowID).Scan(&nano); err != nil {
+		if err == sql.ErrNoRows {
+			return time.Time{}, repository.ErrNotFound
+		}
+		return time.Time{}, err
+	}
+	return time.Unix(0, nano), nil
+}
+
+func (s *schedule) SetLastFiredAt(ctx context.Context, workflowID string, t time.Time) error {
+	_, err := s.db.ExecContext(ctx, "REPLACE INTO schedules (workflow_id, last_fired_at) VALUES (?, ?)", workflowID, toNano(t))
+	return err
+}
@@ -0,0 +1,51 @@
+package rdb
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/master/repository"
+)
+
+type telemetry struct {
+	db *sqlx.DB
+}
+
+func NewTelemetry(db *sqlx.DB) repository.Telemetry {
+	return &telemetry{
+		db: db,
+	}
+}
+
+func (t *telemetry) Add(ctx context.Context, sample *domain.TelemetrySample) error {
+	data, err := marshal(sample)
+	if err != nil {
+		return err
+	}
+	_, err = t.db.ExecContext(ctx, "INSERT INTO telemetry (workflow_id, recorded_at, data) VALUES (?, ?, ?)", sample.WorkflowID, toNano(sample.RecordedAt), data)
+	return err
+}
+
+func (t *telemetry) ListByWorkflowID(ctx context.Context, workflowID string, limit int) ([]*domain.TelemetrySample, error) {
+	query := "SELECT data FROM telemetry WHERE workflow_id = ? ORDER BY recorded_at DESC, id DESC"
+	args := []interface{}{workflowID}
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+	samples := make([]*domain.TelemetrySample, 0)
+	err := selectData(ctx, t.db, func(data []byte) error {
+		var s domain.TelemetrySample
+		if err := json.Unmarshal(data, &s); err != nil {
+			return err
+		}
+		samples = append(samples, &s)
+		return nil
+	}, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
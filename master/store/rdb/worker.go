@@ -0,0 +1,93 @@
+package rdb
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"github.com/mobmob912/takuhai/master/worker"
+)
+
+type workerStore struct {
+	db *sqlx.DB
+}
+
+func NewWorker(db *sqlx.DB) repository.Worker {
+	return &workerStore{
+		db: db,
+	}
+}
+
+func (w *workerStore) Get(ctx context.Context, id string) (*worker.Worker, error) {
+	var wk worker.Worker
+	if err := getData(ctx, w.db, &wk, "SELECT data FROM workers WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+	return &wk, nil
+}
+
+func (w *workerStore) ListAll(ctx context.Context) ([]*worker.Worker, error) {
+	return w.list(ctx, "SELECT data FROM workers ORDER BY id")
+}
+
+func (w *workerStore) ListClouds(ctx context.Context) ([]*worker.Worker, error) {
+	return w.list(ctx, "SELECT data FROM workers WHERE place = ? ORDER BY id", domain.PlaceCloud)
+}
+
+func (w *workerStore) Set(ctx context.Context, id string, wk *worker.Worker) error {
+	wk.ID = id
+	data, err := marshal(wk)
+	if err != nil {
+		return err
+	}
+	_, err = w.db.ExecContext(ctx, "REPLACE INTO workers (id, place, data) VALUES (?, ?, ?)", id, wk.Place, data)
+	return err
+}
+
+// MySQLは値が変わらなければ更新した行数が0になるので、あるかどうかは先に確かめる
+func (w *workerStore) Update(ctx context.Context, id string, wk *worker.Worker) error {
+	wk.ID = id
+	data, err := marshal(wk)
+	if err != nil {
+		return err
+	}
+	tx, err := w.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	var n int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM workers WHERE id = ?", id).Scan(&n); err != nil {
+		return err
+	}
+	if n == 0 {
+		return repository.ErrNotFound
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE workers SET place = ?, data = ? WHERE id = ?", wk.Place, data, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (w *workerStore) Delete(ctx context.Context, id string) error {
+	_, err := w.db.ExecContext(ctx, "DELETE FROM workers WHERE id = ?", id)
+	return err
+}
+
+func (w *workerStore) list(ctx context.Context, query string, args ...interface{}) ([]*worker.Worker, error) {
+	ws := make([]*worker.Worker, 0)
+	err := selectData(ctx, w.db, func(data []byte) error {
+		var wk worker.Worker
+		if err := json.Unmarshal(data, &wk); err != nil {
+			return err
+		}
+		ws = append(ws, &wk)
+		return nil
+	}, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return ws, nil
+}
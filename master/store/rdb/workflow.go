@@ -0,0 +1,107 @@
+package rdb
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/master/repository"
+)
+
+type workflow struct {
+	db *sqlx.DB
+}
+
+func NewWorkflow(db *sqlx.DB) repository.Workflow {
+	return &workflow{
+		db: db,
+	}
+}
+
+func (w *workflow) Get(ctx context.Context, id string) (*domain.Workflow, error) {
+	var wf domain.Workflow
+	if err := getData(ctx, w.db, &wf, "SELECT data FROM workflows WHERE id = ?", id); err != nil {
+		return nil, err
+	}
+	return &wf, nil
+}
+
+func (w *workflow) GetByName(ctx context.Context, name string) (*domain.Workflow, error) {
+	var wf domain.Workflow
+	if err := getData(ctx, w.db, &wf, "SELECT data FROM workflows WHERE name = ? LIMIT 1", name); err != nil {
+		return nil, err
+	}
+	return &wf, nil
+}
+
+func (w *workflow) GetStep(ctx context.Context, workflowID, stepID string) (*domain.Step, error) {
+	wf, err := w.Get(ctx, workflowID)
+	if err != nil {
+		return nil, err
+	}
+	s := wf.StepByID(stepID)
+	if s == nil {
+		return nil, repository.ErrNotFound
+	}
+	return s, nil
+}
+
+func (w *workflow) ListAll(ctx context.Context) ([]*domain.Workflow, error) {
+	return w.list(ctx, "SELECT data FROM workflows ORDER BY id")
+}
+
+func (w *workflow) CheckExistByByName(ctx context.Context, name string) (bool, error) {
+	var n int
+	if err := w.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM workflows WHERE name = ?", name).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (w *workflow) Set(ctx context.Context, id string, wf *domain.Workflow) error {
+	wf.ID = id
+	data, err := marshal(wf)
+	if err != nil {
+		return err
+	}
+	_, err = w.db.ExecContext(ctx, "REPLACE INTO workflows (id, name, data) VALUES (?, ?, ?)", id, wf.Name, data)
+	return err
+}
+
+func (w *workflow) AddRevision(ctx context.Context, wf *domain.Workflow) error {
+	data, err := marshal(wf)
+	if err != nil {
+		return err
+	}
+	_, err = w.db.ExecContext(ctx, "INSERT INTO workflow_revisions (id, version, data) VALUES (?, ?, ?)", wf.ID, wf.Version, data)
+	return err
+}
+
+func (w *workflow) GetRevision(ctx context.Context, id string, version int) (*domain.Workflow, error) {
+	var wf domain.Workflow
+	if err := getData(ctx, w.db, &wf, "SELECT data FROM workflow_revisions WHERE id = ? AND version = ?", id, version); err != nil {
+		return nil, err
+	}
+	return &wf, nil
+}
+
+func (w *workflow) ListRevisions(ctx context.Context, id string) ([]*domain.Workflow, error) {
+	return w.list(ctx, "SELECT data FROM workflow_revisions WHERE id = ? ORDER BY version", id)
+}
+
+func (w *workflow) list(ctx context.Context, query string, args ...interface{}) ([]*domain.Workflow, error) {
+	wfs := make([]*domain.Workflow, 0)
+	err := selectData(ctx, w.db, func(data []byte) error {
+		var wf domain.Workflow
+		if err := json.Unmarshal(data, &wf); err != nil {
+			return err
+		}
+		wfs = append(wfs, &wf)
+		return nil
+	}, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return wfs, nil
+}
@@ -0,0 +1,72 @@
+package store
+
+import (
+	"context"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type run struct {
+	client *mongo.Client
+}
+
+const (
+	runCollection = "run"
+)
+
+func NewRun(c *mongo.Client) repository.Run {
+	return &run{
+		client: c,
+	}
+}
+
+func (r *run) Get(ctx context.Context, id string) (*domain.Run, error) {
+	var rn domain.Run
+	collection := r.client.Database(databaseName).Collection(runCollection)
+	if err := collection.FindOne(ctx, bson.D{{"id", id}}).Decode(&rn); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, repository.ErrNotFound
+		}
+		return nil, err
+	}
+	return &rn, nil
+}
+
+// bsonのキーはフィールド名の小文字になる
+func (r *run) ListByWorkflowID(ctx context.Context, workflowID string, limit int) ([]*domain.Run, error) {
+	runs := make([]*domain.Run, 0)
+	collection := r.client.Database(databaseName).Collection(runCollection)
+	opts := options.Find().SetSort(bson.D{{"startedat", -1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cur, err := collection.Find(ctx, bson.D{{"workflowid", workflowID}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var rn domain.Run
+		if err := cur.Decode(&rn); err != nil {
+			return nil, err
+		}
+		runs = append(runs, &rn)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+func (r *run) Set(ctx context.Context, rn *domain.Run) error {
+	collection := r.client.Database(databaseName).Collection(runCollection)
+	opts := options.Replace().SetUpsert(true)
+	if _, err := collection.ReplaceOne(ctx, bson.D{{"id", rn.ID}}, rn, opts); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type schedule struct {
+	client *mongo.Client
+}
+
+const (
+	scheduleCollection = "schedule"
+)
+
+type scheduleDocument struct {
+	WorkflowID  string    `bson:"workflow_id"`
+	LastFiredAt time.Time `bson:"last_fired_at"`
+}
+
+func NewSchedule(c *mongo.Client) repository.Schedule {
+	return &schedule{
+		client: c,
+	}
+}
+
+func (s *schedule) GetLastFiredAt(ctx context.Context, workflowID string) (time.Time, error) {
+	var doc scheduleDocument
+	collection := s.client.Database(databaseName).Collection(scheduleCollection)
+	if err := collection.FindOne(ctx, bson.D{{"workflow_id", workflowID}}).Decode(&doc); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return time.Time{}, repository.ErrNotFound
+		}
+		return time.Time{}, err
+	}
+	return doc.LastFiredAt, nil
+}
+
+func (s *schedule) SetLastFiredAt(ctx context.Context, workflowID string, t time.Time) error {
+	collection := s.client.Database(databaseName).Collection(scheduleCollection)
+	update := bson.D{{"$set", &scheduleDocument{WorkflowID: workflowID, LastFiredAt: t}}}
+	opts := options.Update().SetUpsert(true)
+	if _, err := collection.UpdateOne(ctx, bson.D{{"workflow_id", workflowID}}, update, opts); err != nil {
+		return err
+	}
+	return nil
+}
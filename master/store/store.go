@@ -33,6 +33,27 @@ func (c *DBConfig) Open() (*gorm.DB, error) {
 	return conn, nil
 }
 
+// リポジトリのrdbで使う. dialectはmysqlかsqlite3
+func (c *DBConfig) OpenSQL() (*sqlx.DB, error) {
+	db, err := sqlx.Open(c.Dialect, c.Datasource)
+	if err != nil {
+		return nil, err
+	}
+	// SQLiteは同時に書き込むとロックで失敗するので、接続を1つにして順番に書く
+	if c.Dialect == "sqlite3" {
+		db.SetMaxOpenConns(1)
+	}
+	return db, nil
+}
+
+// dirのマイグレーションのうち、まだ当てていないものを当てる. 当てた数を返す
+func (c *DBConfig) Migrate(db *sqlx.DB) (int, error) {
+	migrations := &migrate.FileMigrationSource{
+		Dir: c.Dir,
+	}
+	return migrate.Exec(db.DB, c.Dialect, migrations, migrate.Up)
+}
+
 func NewDBConfigsFromFile(path string) (DBConfigs, error) {
 	f, err := os.Open(path)
 	if err != nil {
@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"github.com/mobmob912/takuhai/master/master/repository/repositorytest"
+)
+
+// テスト用のMongoDB. takuhaiデータベースのコレクションを消すので、本番のものを指定しないこと
+const mongoURIEnv = "TAKUHAI_TEST_MONGO_URI"
+
+// 設定されていなければテストを飛ばす. 指定したコレクションを空にしてから返す
+func connectTestMongo(t *testing.T, collections ...string) *mongo.Client {
+	t.Helper()
+	uri := os.Getenv(mongoURIEnv)
+	if uri == "" {
+		t.Skipf("%s is not set", mongoURIEnv)
+	}
+	ctx := context.Background()
+	c, err := mongo.NewClient(options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Connect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Disconnect(context.Background()) })
+	for _, name := range collections {
+		if err := c.Database(databaseName).Collection(name).Drop(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func TestWorker(t *testing.T) {
+	repositorytest.TestWorker(t, func(t *testing.T) repository.Worker {
+		return NewWorker(connectTestMongo(t, workerCollection))
+	})
+}
+
+func TestWorkflow(t *testing.T) {
+	repositorytest.TestWorkflow(t, func(t *testing.T) repository.Workflow {
+		return NewWorkflow(connectTestMongo(t, workflowCollection, workflowRevisionCollection))
+	})
+}
+
+func TestRun(t *testing.T) {
+	repositorytest.TestRun(t, func(t *testing.T) repository.Run {
+		return NewRun(connectTestMongo(t, runCollection))
+	})
+}
+
+func TestSchedule(t *testing.T) {
+	repositorytest.TestSchedule(t, func(t *testing.T) repository.Schedule {
+		return NewSchedule(connectTestMongo(t, scheduleCollection))
+	})
+}
+
+func TestLatency(t *testing.T) {
+	repositorytest.TestLatency(t, func(t *testing.T) repository.Latency {
+		return NewLatency(connectTestMongo(t, latencyCollection))
+	})
+}
+
+func TestTelemetry(t *testing.T) {
+	repositorytest.TestTelemetry(t, func(t *testing.T) repository.Telemetry {
+		return NewTelemetry(connectTestMongo(t, telemetryCollection))
+	})
+}
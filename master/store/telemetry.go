@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/master/repository"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type telemetry struct {
+	client *mongo.Client
+}
+
+const (
+	telemetryCollection = "telemetry"
+)
+
+func NewTelemetry(c *mongo.Client) repository.Telemetry {
+	return &telemetry{
+		client: c,
+	}
+}
+
+func (t *telemetry) Add(ctx context.Context, sample *domain.TelemetrySample) error {
+	collection := t.client.Database(databaseName).Collection(telemetryCollection)
+	if _, err := collection.InsertOne(ctx, sample); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (t *telemetry) ListByWorkflowID(ctx context.Context, workflowID string, limit int) ([]*domain.TelemetrySample, error) {
+	samples := make([]*domain.TelemetrySample, 0)
+	collection := t.client.Database(databaseName).Collection(telemetryCollection)
+	// 同じ時刻なら後から追加した方が先
+	opts := options.Find().SetSort(bson.D{{"recordedat", -1}, {"_id", -1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+	cur, err := collection.Find(ctx, bson.D{{"workflowid", workflowID}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var s domain.TelemetrySample
+		if err := cur.Decode(&s); err != nil {
+			return nil, err
+		}
+		samples = append(samples, &s)
+	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/mobmob912/takuhai/master/master/repository"
 	"github.com/mobmob912/takuhai/master/worker"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type workerStore struct {
@@ -31,33 +32,26 @@ func (w *workerStore) Get(ctx context.Context, id string) (*worker.Worker, error
 	wk := &worker.Worker{}
 	collection := w.client.Database(databaseName).Collection(workerCollection)
 	if err := collection.FindOne(ctx, bson.D{{"id", id}}).Decode(&wk); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, repository.ErrNotFound
+		}
 		return nil, err
 	}
 	return wk, nil
 }
 
 func (w *workerStore) ListAll(ctx context.Context) ([]*worker.Worker, error) {
-	var ns []*worker.Worker
-	collection := w.client.Database(databaseName).Collection(workerCollection)
-	cur, err := collection.Find(ctx, bson.D{})
-	if err != nil {
-		return nil, err
-	}
-	defer cur.Close(ctx)
-	for cur.Next(ctx) {
-		var n worker.Worker
-		if err := cur.Decode(&n); err != nil {
-			return nil, err
-		}
-		ns = append(ns, &n)
-	}
-	return ns, nil
+	return w.list(ctx, bson.D{})
 }
 
 func (w *workerStore) ListClouds(ctx context.Context) ([]*worker.Worker, error) {
-	var ns []*worker.Worker
+	return w.list(ctx, bson.D{{"place", domain.PlaceCloud}})
+}
+
+func (w *workerStore) list(ctx context.Context, filter bson.D) ([]*worker.Worker, error) {
+	ns := make([]*worker.Worker, 0)
 	collection := w.client.Database(databaseName).Collection(workerCollection)
-	cur, err := collection.Find(ctx, bson.D{{"place", domain.PlaceCloud}})
+	cur, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{"id", 1}}))
 	if err != nil {
 		return nil, err
 	}
@@ -69,6 +63,9 @@ func (w *workerStore) ListClouds(ctx context.Context) ([]*worker.Worker, error)
 		}
 		ns = append(ns, &n)
 	}
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
 	return ns, nil
 }
 
@@ -76,18 +73,24 @@ func (w *workerStore) Set(ctx context.Context, id string, wf *worker.Worker) err
 	wf.ID = id
 	log.Println(wf)
 	collection := w.client.Database(databaseName).Collection(workerCollection)
-	if _, err := collection.InsertOne(ctx, wf); err != nil {
+	opts := options.Replace().SetUpsert(true)
+	if _, err := collection.ReplaceOne(ctx, bson.D{{"id", id}}, wf, opts); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (w *workerStore) Update(ctx context.Context, id string, wk *worker.Worker) error {
+	wk.ID = id
 	update := bson.D{{"$set", wk}}
 	collection := w.client.Database(databaseName).Collection(workerCollection)
-	if _, err := collection.UpdateOne(ctx, bson.D{{"id", id}}, update); err != nil {
+	res, err := collection.UpdateOne(ctx, bson.D{{"id", id}}, update)
+	if err != nil {
 		return err
 	}
+	if res.MatchedCount == 0 {
+		return repository.ErrNotFound
+	}
 	return nil
 }
 
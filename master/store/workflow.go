@@ -7,6 +7,7 @@ import (
 	"github.com/mobmob912/takuhai/master/master/repository"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type workflow struct {
@@ -14,7 +15,8 @@ type workflow struct {
 }
 
 const (
-	workflowCollection = "workflow"
+	workflowCollection         = "workflow"
+	workflowRevisionCollection = "workflow_revision"
 )
 
 func NewWorkflow(c *mongo.Client) repository.Workflow {
@@ -27,6 +29,9 @@ func (w *workflow) Get(ctx context.Context, id string) (*domain.Workflow, error)
 	var wf domain.Workflow
 	collection := w.client.Database(databaseName).Collection(workflowCollection)
 	if err := collection.FindOne(ctx, bson.D{{"id", id}}).Decode(&wf); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, repository.ErrNotFound
+		}
 		return nil, err
 	}
 	return &wf, nil
@@ -36,6 +41,9 @@ func (w *workflow) GetByName(ctx context.Context, name string) (*domain.Workflow
 	var wf domain.Workflow
 	collection := w.client.Database(databaseName).Collection(workflowCollection)
 	if err := collection.FindOne(ctx, bson.D{{"name", name}}).Decode(&wf); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, repository.ErrNotFound
+		}
 		return nil, err
 	}
 	return &wf, nil
@@ -45,23 +53,26 @@ func (w *workflow) GetStep(ctx context.Context, workflowID, stepID string) (*dom
 	var wf domain.Workflow
 	collection := w.client.Database(databaseName).Collection(workflowCollection)
 	if err := collection.FindOne(ctx, bson.D{{"id", workflowID}}).Decode(&wf); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, repository.ErrNotFound
+		}
 		return nil, err
 	}
-	for _, s := range wf.Steps {
-		if s.ID == stepID {
-			return s, nil
-		}
+	s := wf.StepByID(stepID)
+	if s == nil {
+		return nil, repository.ErrNotFound
 	}
-	return nil, repository.ErrNotFound
+	return s, nil
 }
 
 func (w *workflow) ListAll(ctx context.Context) ([]*domain.Workflow, error) {
-	var wfs []*domain.Workflow
+	wfs := make([]*domain.Workflow, 0)
 	collection := w.client.Database(databaseName).Collection(workflowCollection)
-	cur, err := collection.Find(ctx, bson.M{})
+	cur, err := collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{"id", 1}}))
 	if err != nil {
 		return nil, err
 	}
+	defer cur.Close(ctx)
 	for cur.Next(ctx) {
 		var wf domain.Workflow
 		if err := cur.Decode(&wf); err != nil {
@@ -84,17 +95,55 @@ func (w *workflow) CheckExistByByName(ctx context.Context, name string) (bool, e
 	return true, err
 }
 
+// 探してから追加すると、同時に呼ばれた時に重複するのでupsertする
 func (w *workflow) Set(ctx context.Context, id string, wf *domain.Workflow) error {
 	wf.ID = id
 	collection := w.client.Database(databaseName).Collection(workflowCollection)
-	if collection.FindOne(ctx, bson.D{{"id", id}}).Err() == mongo.ErrNoDocuments {
-		if _, err := collection.InsertOne(ctx, wf); err != nil {
-			return err
+	opts := options.Replace().SetUpsert(true)
+	if _, err := collection.ReplaceOne(ctx, bson.D{{"id", id}}, wf, opts); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (w *workflow) AddRevision(ctx context.Context, wf *domain.Workflow) error {
+	collection := w.client.Database(databaseName).Collection(workflowRevisionCollection)
+	if _, err := collection.InsertOne(ctx, wf); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (w *workflow) GetRevision(ctx context.Context, id string, version int) (*domain.Workflow, error) {
+	var wf domain.Workflow
+	collection := w.client.Database(databaseName).Collection(workflowRevisionCollection)
+	if err := collection.FindOne(ctx, bson.D{{"id", id}, {"version", version}}).Decode(&wf); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, repository.ErrNotFound
 		}
-	} else {
-		if _, err := collection.UpdateOne(ctx, bson.D{{"id", id}}, bson.D{{"$set", wf}}); err != nil {
-			return err
+		return nil, err
+	}
+	return &wf, nil
+}
+
+func (w *workflow) ListRevisions(ctx context.Context, id string) ([]*domain.Workflow, error) {
+	wfs := make([]*domain.Workflow, 0)
+	collection := w.client.Database(databaseName).Collection(workflowRevisionCollection)
+	opts := options.Find().SetSort(bson.D{{"version", 1}})
+	cur, err := collection.Find(ctx, bson.D{{"id", id}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var wf domain.Workflow
+		if err := cur.Decode(&wf); err != nil {
+			return nil, err
 		}
+		wfs = append(wfs, &wf)
 	}
-	return nil
+	if err := cur.Err(); err != nil {
+		return nil, err
+	}
+	return wfs, nil
 }
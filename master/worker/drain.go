@@ -0,0 +1,11 @@
+package worker
+
+// drainの進み具合. drainしていなければ空
+type DrainState string
+
+const (
+	// 実行中のジョブが終わるのを待っている
+	DrainStateDraining DrainState = "draining"
+	// ジョブを全てアンデプロイした
+	DrainStateDrained DrainState = "drained"
+)
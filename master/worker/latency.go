@@ -0,0 +1,52 @@
+package worker
+
+import (
+	"time"
+)
+
+// ワーカー間のレイテンシ. FromIDのワーカーが計測した、ToIDのワーカーまでの片道の時間
+type Latency struct {
+	FromID     string        `json:"from_id"`
+	ToID       string        `json:"to_id"`
+	Latency    time.Duration `json:"latency"`
+	MeasuredAt time.Time     `json:"measured_at"`
+}
+
+// k=計測したワーカーのID, k=相手のワーカーのID
+type LatencyMatrix map[string]map[string]time.Duration
+
+func NewLatencyMatrix(ls []*Latency) LatencyMatrix {
+	m := make(LatencyMatrix)
+	for _, l := range ls {
+		if _, ok := m[l.FromID]; !ok {
+			m[l.FromID] = make(map[string]time.Duration)
+		}
+		m[l.FromID][l.ToID] = l.Latency
+	}
+	return m
+}
+
+// 同じワーカーなら0. 片方向しか計測されていなければ逆向きの値を使う
+func (m LatencyMatrix) Get(fromID, toID string) (time.Duration, bool) {
+	if fromID == toID {
+		return 0, true
+	}
+	if d, ok := m[fromID][toID]; ok {
+		return d, true
+	}
+	d, ok := m[toID][fromID]
+	return d, ok
+}
+
+// 計測されたレイテンシの最大値
+func (m LatencyMatrix) Max() time.Duration {
+	var max time.Duration
+	for _, tos := range m {
+		for _, d := range tos {
+			if d > max {
+				max = d
+			}
+		}
+	}
+	return max
+}
@@ -0,0 +1,60 @@
+package worker
+
+import (
+	"time"
+)
+
+// ハートビートのリースで決まるワーカーの状態
+type State string
+
+const (
+	// リースが切れていない
+	StateReady State = "ready"
+	// ハートビートが途切れているが、一時的なこともあるのでまだ配置する
+	StateSuspect State = "suspect"
+	// 配置しない. health checkは続け、ハートビートが戻ればreadyに戻る
+	StateUnreachable State = "unreachable"
+	// health checkもやめた. 戻すにはワーカーを登録し直す
+	StateRemoved State = "removed"
+)
+
+// GET /workersで見せる状態の変化の数. 古いものから消す
+const stateTransitionLimit = 10
+
+type StateTransition struct {
+	From State     `json:"from"`
+	To   State     `json:"to"`
+	At   time.Time `json:"at"`
+}
+
+// 状態を持つ前に保存したワーカーは空なのでreadyとして扱う
+func (w *Worker) CurrentState() State {
+	if w.State == "" {
+		return StateReady
+	}
+	return w.State
+}
+
+// ステップを配置できるか
+func (w *Worker) Schedulable() bool {
+	switch w.CurrentState() {
+	case StateReady, StateSuspect:
+		return true
+	}
+	return false
+}
+
+// 変わった時だけ記録する. 変わったらtrue
+func (w *Worker) SetState(s State, at time.Time) bool {
+	from := w.CurrentState()
+	w.State = s
+	if from == s {
+		return false
+	}
+	w.StateChangedAt = at
+	w.Transitions = append(w.Transitions, &StateTransition{From: from, To: s, At: at})
+	if len(w.Transitions) > stateTransitionLimit {
+		w.Transitions = w.Transitions[len(w.Transitions)-stateTransitionLimit:]
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package worker
+
+// ワーカーの親子関係. device→edge→cloudの順に、親は上流のワーカー
+type Topology struct {
+	byID   map[string]*Worker
+	byName map[string]*Worker
+}
+
+func NewTopology(wks []*Worker) *Topology {
+	t := &Topology{
+		byID:   make(map[string]*Worker, len(wks)),
+		byName: make(map[string]*Worker, len(wks)),
+	}
+	for _, w := range wks {
+		t.byID[w.ID] = w
+		t.byName[w.Name] = w
+	}
+	return t
+}
+
+// 親が無いか、まだ登録されていなければnil
+func (t *Topology) Parent(w *Worker) *Worker {
+	if w.Parent == "" {
+		return nil
+	}
+	return t.byName[w.Parent]
+}
+
+// fromIDのワーカーから親を辿ってtoIDのワーカーまでの段数. 同じワーカーなら0
+// toIDのワーカーがfromIDのワーカーの上流でなければfalse
+func (t *Topology) UpstreamDistance(fromID, toID string) (int, bool) {
+	w, ok := t.byID[fromID]
+	if !ok {
+		return 0, false
+	}
+	visited := make(map[string]bool)
+	for d := 0; w != nil; d++ {
+		if w.ID == toID {
+			return d, true
+		}
+		// 親子関係が循環していても止まるように
+		if visited[w.ID] {
+			return 0, false
+		}
+		visited[w.ID] = true
+		w = t.Parent(w)
+	}
+	return 0, false
+}
@@ -2,6 +2,7 @@ package worker
 
 import (
 	"errors"
+	"fmt"
 	"net/url"
 	"time"
 
@@ -19,21 +20,43 @@ type Worker struct {
 	Errors []error `json:"-"`
 
 	Place domain.Place `json:"place"`
+	// 上流のワーカーの名前 (ex: deviceならつながっているedge). 無ければ空
+	Parent string `json:"parent"`
 
 	CPUUsagePercent float64       `json:"cpu_usage_percent"`
 	CPUClockMhz     float64       `json:"cpu_clock_mhz"`
 	AvailableMemory uint64        `json:"available_memory"`
 	Latency         time.Duration `json:"latency"`
+
+	State State `json:"state"`
+	// 最後にhealth checkかリソースの報告が届いた時刻
+	LastHeartbeatAt time.Time          `json:"last_heartbeat_at"`
+	StateChangedAt  time.Time          `json:"state_changed_at"`
+	Transitions     []*StateTransition `json:"transitions"`
+
+	// メンテナンスのために配置しないようにしている
+	Cordoned bool       `json:"cordoned"`
+	Drain    DrainState `json:"drain,omitempty"`
 }
 
 func (n *Worker) Validate(ns []*Worker) error {
 	if n.Name == "" {
 		return errors.New("name is required")
 	}
+	if !n.Place.IsWorkerPlace() {
+		return fmt.Errorf("unknown place %s. place must be device, edge or cloud", n.Place)
+	}
+	if n.Parent == n.Name {
+		return errors.New("worker can not be its own parent")
+	}
 	for i := range ns {
 		if n.URL == ns[i].URL {
 			return errors.New("duplicate worker URL")
 		}
+		// 親が後から登録されることもあるので、登録済みの時だけ確かめる
+		if ns[i].Name == n.Parent && ns[i].Place.Tier() <= n.Place.Tier() {
+			return fmt.Errorf("parent %s (%s) must be upstream of %s", ns[i].Name, ns[i].Place, n.Place)
+		}
 	}
 	return nil
 }
@@ -64,6 +64,12 @@ func (s *server) Serve() error {
 	// TriggerTypeHTTPのワークフローを開始する
 	r.Post("/trigger/*", s.startWorkflow)
 
+	// 同期トリガーのワークフローの結果を受け取る 結果を出力したワーカーから叩かれる
+	r.Post("/runs/{runID}/result", s.receiveRunResult)
+
+	// cronなど、masterからワークフローを開始する
+	r.Post("/workflows/{workflowID}/start", s.startWorkflowByID)
+
 	//// jobをデプロイする from master
 	//r.Post("/workflows/{workflowID}/steps/{stepID}/deploy", s.deployJob)
 
@@ -78,6 +84,12 @@ func (s *server) Serve() error {
 
 	r.Get("/workflows/{workflowID}/steps/status", s.listStepStatus)
 
+	// masterがワーカーをdrainする時に叩かれる. 実行中のジョブが終わるのを待ってから全てアンデプロイする
+	r.Post("/jobs/undeploy", s.undeployJobs)
+	// drainの間、新しいrunを受け付けないようにする. uncordonされたらDELETE
+	r.Post("/drain", s.startDrain)
+	r.Delete("/drain", s.stopDrain)
+
 	log.SetPrefix("[External-API]: ")
 	log.Println("Serving...")
 	s.isServing = true
@@ -100,7 +112,58 @@ func (s *server) startWorkflow(w http.ResponseWriter, r *http.Request) {
 		respondError(w, err, http.StatusBadRequest)
 		return
 	}
-	if err := s.workerService.StartJobByTriggerHTTPPath(ctx, triggerPath, body); err != nil {
+	result, err := s.workerService.StartJobByTriggerHTTPPath(ctx, triggerPath, body)
+	if err == worker.ErrRunResultTimeout {
+		respondError(w, err, http.StatusGatewayTimeout)
+		return
+	}
+	if err == worker.ErrDraining {
+		respondError(w, err, http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		respondError(w, err, http.StatusInternalServerError)
+		return
+	}
+	if result.Sync {
+		sendResponse(w, http.StatusOK, result.Output)
+		return
+	}
+	respondSuccess(w, http.StatusCreated, nil)
+}
+
+func (s *server) receiveRunResult(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	runID := chi.URLParam(r, "runID")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		respondError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := s.workerService.ReceiveRunResult(ctx, runID, body); err != nil {
+		if err == worker.ErrNotFoundRunResult {
+			respondError(w, err, http.StatusNotFound)
+			return
+		}
+		respondError(w, err, http.StatusInternalServerError)
+		return
+	}
+	respondSuccess(w, http.StatusNoContent, nil)
+}
+
+func (s *server) startWorkflowByID(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	workflowID := chi.URLParam(r, "workflowID")
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		respondError(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := s.workerService.StartWorkflow(ctx, workflowID, body); err != nil {
+		if err == worker.ErrDraining {
+			respondError(w, err, http.StatusServiceUnavailable)
+			return
+		}
 		respondError(w, err, http.StatusInternalServerError)
 		return
 	}
@@ -112,12 +175,18 @@ func (s *server) runJob(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	workflowID := chi.URLParam(r, "workflowID")
 	stepID := chi.URLParam(r, "stepID")
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
+	var payload domain.Payload
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
 		respondError(w, err, http.StatusBadRequest)
 		return
 	}
-	if err := s.workerService.RunJob(ctx, workflowID, stepID, body); err != nil {
+	run := worker.RunFromHeader(r.Header)
+	fromStepID := r.Header.Get(worker.HeaderFromStepID)
+	if err := s.workerService.ReceiveStep(ctx, workflowID, stepID, fromStepID, run, &payload); err != nil {
+		if err == worker.ErrDraining {
+			respondError(w, err, http.StatusServiceUnavailable)
+			return
+		}
 		respondError(w, err, http.StatusInternalServerError)
 		return
 	}
@@ -137,8 +206,9 @@ func (s *server) updateWorkflows(w http.ResponseWriter, r *http.Request) {
 		respondError(w, err, http.StatusInternalServerError)
 		return
 	}
-	for _, f := range flows {
-		s.workflowStore.Set(ctx, f.ID, f)
+	if err := s.workerService.UpdateWorkflows(ctx, flows); err != nil {
+		respondError(w, err, http.StatusInternalServerError)
+		return
 	}
 	log.Println("Update workflows")
 	log.Println(flows)
@@ -168,6 +238,28 @@ func (s *server) listStepStatus(w http.ResponseWriter, r *http.Request) {
 	}
 	respondSuccess(w, http.StatusOK, ss)
 }
+func (s *server) undeployJobs(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if err := s.workerService.UndeployAllJobs(ctx); err != nil {
+		respondError(w, err, http.StatusInternalServerError)
+		return
+	}
+	log.Println("Undeploy all jobs")
+	respondSuccess(w, http.StatusNoContent, nil)
+}
+
+func (s *server) startDrain(w http.ResponseWriter, r *http.Request) {
+	s.workerService.SetDraining(true)
+	log.Println("Start draining")
+	respondSuccess(w, http.StatusNoContent, nil)
+}
+
+func (s *server) stopDrain(w http.ResponseWriter, r *http.Request) {
+	s.workerService.SetDraining(false)
+	log.Println("Stop draining")
+	respondSuccess(w, http.StatusNoContent, nil)
+}
+
 func (s *server) registerWorker(w http.ResponseWriter, r *http.Request) {
 	//ctx := r.Context()
 	var flows worker.ToNode
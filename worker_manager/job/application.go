@@ -11,4 +11,5 @@ type Job interface {
 
 	// 時間かかるのでgoroutineで呼ぶべき
 	Deploy(ctx context.Context) error
+	Undeploy(ctx context.Context) error
 }
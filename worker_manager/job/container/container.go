@@ -22,29 +22,35 @@ import (
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
+	"github.com/mobmob912/takuhai/domain"
 	"github.com/mobmob912/takuhai/worker_manager/job"
 )
 
 type container struct {
 	stepID           string // stepID
+	key              string // store.JobKey. 同じステップの別のバージョンのジョブとコンテナ名が被らないようにする
 	workflowID       string
 	client           *client.Client
 	jobName          string
 	image            string
+	limits           *domain.Limits
 	deployed         bool
 	addr             *url.URL
+	containerID      string
 	managerLocalAddr *net.IP
 	hostIP           net.IP
 	err              error
 }
 
-func New(cli *client.Client, id, workflowID, jobName, image string, managerLocalAddr *net.IP) job.Job {
+func New(cli *client.Client, id, key, workflowID, jobName, image string, limits *domain.Limits, managerLocalAddr *net.IP) job.Job {
 	return &container{
 		stepID:           id,
+		key:              key,
 		workflowID:       workflowID,
 		client:           cli,
 		jobName:          jobName,
 		image:            image,
+		limits:           limits,
 		managerLocalAddr: managerLocalAddr,
 	}
 }
@@ -58,6 +64,12 @@ func (c *container) Name() string {
 }
 
 func (c *container) Deploy(ctx context.Context) error {
+	resources, err := c.resources()
+	if err != nil {
+		c.err = err
+		return err
+	}
+
 	log.Println("pulling image...")
 	resp, err := c.client.ImagePull(ctx, c.image, types.ImagePullOptions{})
 	if err != nil {
@@ -78,7 +90,7 @@ func (c *container) Deploy(ctx context.Context) error {
 		return err
 	}
 
-	containerName := fmt.Sprintf("%s-%s", c.jobName, c.stepID)
+	containerName := fmt.Sprintf("%s-%s", c.jobName, c.key)
 
 	cs, err := c.client.ContainerList(ctx, types.ContainerListOptions{
 		Filters: filters.NewArgs(filters.Arg("name", containerName)),
@@ -109,6 +121,7 @@ func (c *container) Deploy(ctx context.Context) error {
 		&docker_container.HostConfig{
 			PortBindings: portBindings,
 			ExtraHosts:   []string{fmt.Sprintf("takuhai_host:%s", c.managerLocalAddr.String())},
+			Resources:    resources,
 		},
 		&network.NetworkingConfig{},
 		containerName,
@@ -122,6 +135,7 @@ func (c *container) Deploy(ctx context.Context) error {
 		return err
 	}
 	log.Println("container starting success")
+	c.containerID = body.ID
 
 	go c.Logging(context.Background(), body.ID)
 
@@ -129,6 +143,33 @@ func (c *container) Deploy(ctx context.Context) error {
 	return nil
 }
 
+// ジョブのlimitsをコンテナのリソース制限にする
+func (c *container) resources() (docker_container.Resources, error) {
+	var r docker_container.Resources
+	memory, err := c.limits.MemoryBytes()
+	if err != nil {
+		return r, err
+	}
+	milliCPU, err := c.limits.MilliCPU()
+	if err != nil {
+		return r, err
+	}
+	if memory > 0 {
+		r.Memory = memory
+		// swapも含めて同じ上限にする
+		r.MemorySwap = memory
+	}
+	r.NanoCPUs = milliCPU * 1000 * 1000
+	return r, nil
+}
+
+func (c *container) Undeploy(ctx context.Context) error {
+	if c.containerID == "" {
+		return nil
+	}
+	return c.client.ContainerRemove(ctx, c.containerID, types.ContainerRemoveOptions{Force: true})
+}
+
 // TODO いい感じの場所へログをはく
 func (c *container) Logging(ctx context.Context, containerID string) error {
 	reader, err := c.client.ContainerLogs(ctx, containerID, types.ContainerLogsOptions{ShowStdout: true, ShowStderr: true, Follow: true})
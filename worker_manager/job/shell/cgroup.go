@@ -0,0 +1,81 @@
+package shell
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	cgroupRoot   = "/sys/fs/cgroup"
+	cgroupParent = "takuhai"
+	// cpu.maxの周期 (マイクロ秒)
+	cgroupCPUPeriod = 100000
+)
+
+var (
+	errCgroupUnavailable = errors.New("cgroup v2 is not available")
+)
+
+// cgroup v2でシェルのジョブのメモリとCPUを制限する
+type cgroup struct {
+	path string
+}
+
+func newCgroup(name string, memory, milliCPU int64) (*cgroup, error) {
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return nil, errCgroupUnavailable
+	}
+	controllers := make([]string, 0, 2)
+	if memory > 0 {
+		controllers = append(controllers, "+memory")
+	}
+	if milliCPU > 0 {
+		controllers = append(controllers, "+cpu")
+	}
+	parent := filepath.Join(cgroupRoot, cgroupParent)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return nil, err
+	}
+	// 子のcgroupでコントローラを使えるようにする
+	for _, dir := range []string{cgroupRoot, parent} {
+		if err := writeCgroupFile(dir, "cgroup.subtree_control", strings.Join(controllers, " ")); err != nil {
+			return nil, err
+		}
+	}
+	cg := &cgroup{path: filepath.Join(parent, name)}
+	if err := os.MkdirAll(cg.path, 0755); err != nil {
+		return nil, err
+	}
+	if memory > 0 {
+		if err := writeCgroupFile(cg.path, "memory.max", strconv.FormatInt(memory, 10)); err != nil {
+			cg.remove()
+			return nil, err
+		}
+	}
+	if milliCPU > 0 {
+		quota := milliCPU * cgroupCPUPeriod / 1000
+		if err := writeCgroupFile(cg.path, "cpu.max", fmt.Sprintf("%d %d", quota, cgroupCPUPeriod)); err != nil {
+			cg.remove()
+			return nil, err
+		}
+	}
+	return cg, nil
+}
+
+func (c *cgroup) add(pid int) error {
+	return writeCgroupFile(c.path, "cgroup.procs", strconv.Itoa(pid))
+}
+
+// プロセスが全て終了していないと消せない
+func (c *cgroup) remove() error {
+	return os.Remove(c.path)
+}
+
+func writeCgroupFile(dir, file, value string) error {
+	return ioutil.WriteFile(filepath.Join(dir, file), []byte(value), 0644)
+}
@@ -14,27 +14,34 @@ import (
 	"os/exec"
 	"time"
 
+	"github.com/mobmob912/takuhai/domain"
 	"github.com/mobmob912/takuhai/worker_manager/job"
 )
 
 type shell struct {
 	stepID      string
+	key         string // store.JobKey. 同じステップの別のバージョンのジョブとファイルやcgroupが被らないようにする
 	workflowID  string
 	jobName     string
 	shell       string
+	limits      *domain.Limits
+	cgroup      *cgroup
 	addr        *url.URL
+	cmd         *exec.Cmd
 	deployed    bool
 	managerAddr *net.IP
 	hostIP      net.IP
 	err         error
 }
 
-func New(id, workflowID, jobName, sh string, managerAddr *net.IP) job.Job {
+func New(id, key, workflowID, jobName, sh string, limits *domain.Limits, managerAddr *net.IP) job.Job {
 	return &shell{
 		stepID:      id,
+		key:         key,
 		workflowID:  workflowID,
 		jobName:     jobName,
 		shell:       sh,
+		limits:      limits,
 		managerAddr: managerAddr,
 	}
 }
@@ -56,14 +63,18 @@ func (c *shell) Deploy(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-	file, err := os.Create(c.stepID)
+	script, err := c.applyLimits()
+	if err != nil {
+		return err
+	}
+	file, err := os.Create(c.key)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
-	file.Write([]byte(c.shell))
-	defer os.Remove(c.stepID)
-	c1 := exec.Command("cat", c.stepID)
+	file.Write([]byte(script))
+	defer os.Remove(c.key)
+	c1 := exec.Command("cat", c.key)
 	c2 := exec.Command("sh")
 	c2.Env = append(os.Environ(),
 		"takuhaiJobPort="+portStr,
@@ -89,6 +100,13 @@ func (c *shell) Deploy(ctx context.Context) error {
 	if err := c2.Start(); err != nil {
 		return err
 	}
+	c.cmd = c2
+	if c.cgroup != nil {
+		if err := c.cgroup.add(c2.Process.Pid); err != nil {
+			_ = c2.Process.Kill()
+			return err
+		}
+	}
 	if err := c1.Wait(); err != nil {
 		return err
 	}
@@ -99,6 +117,49 @@ func (c *shell) Deploy(ctx context.Context) error {
 	return nil
 }
 
+// cgroup v2が使えればcgroupで制限する
+// 使えない時はulimitでメモリだけ制限する. CPUは制限できない
+func (c *shell) applyLimits() (string, error) {
+	memory, err := c.limits.MemoryBytes()
+	if err != nil {
+		return "", err
+	}
+	milliCPU, err := c.limits.MilliCPU()
+	if err != nil {
+		return "", err
+	}
+	if memory == 0 && milliCPU == 0 {
+		return c.shell, nil
+	}
+	cg, err := newCgroup(c.key, memory, milliCPU)
+	if err == nil {
+		c.cgroup = cg
+		return c.shell, nil
+	}
+	log.Printf("could not limit resources by cgroup. %s", err)
+	if milliCPU > 0 {
+		log.Printf("cpu limit of %s is not applied", c.jobName)
+	}
+	if memory == 0 {
+		return c.shell, nil
+	}
+	return fmt.Sprintf("ulimit -v %d\n%s", memory/1024, c.shell), nil
+}
+
+func (c *shell) Undeploy(ctx context.Context) error {
+	if c.cmd == nil || c.cmd.Process == nil {
+		return nil
+	}
+	if err := c.cmd.Process.Kill(); err != nil {
+		return err
+	}
+	if c.cgroup != nil {
+		_ = c.cmd.Wait()
+		return c.cgroup.remove()
+	}
+	return nil
+}
+
 // TODO いい感じの場所へログをはく
 func (c *shell) Logging(ctx context.Context, stdout, stderr io.ReadCloser) error {
 	streamReader := func(scanner *bufio.Scanner, outputChan chan string, doneChan chan bool) {
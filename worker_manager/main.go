@@ -44,7 +44,7 @@ func run() error {
 	log.Println("|                                                                            |")
 	log.Println("==============================================================================\n")
 
-	var name, argWorkerGlobalIP, argWorkerLocalIP, workerPort, argMasterIP, masterPort, workerType, place, labelsStr string
+	var name, argWorkerGlobalIP, argWorkerLocalIP, workerPort, argMasterIP, masterPort, workerType, place, parent, labelsStr, arch string
 	flag.StringVar(&name, "name", "", "worker name")
 	flag.StringVar(&argWorkerGlobalIP, "workerGlobalIP", "", "worker global ip addr")
 	flag.StringVar(&argWorkerLocalIP, "workerLocalIP", "", "worker local ip addr")
@@ -52,14 +52,23 @@ func run() error {
 	flag.StringVar(&argMasterIP, "masterIP", "", "master ip addr")
 	flag.StringVar(&masterPort, "masterPort", "3000", "master server port")
 	flag.StringVar(&workerType, "workerType", "docker", "worker type (ex: docker, shell")
+	flag.StringVar(&arch, "arch", runtime.GOARCH, "worker architecture. arm needs its variant to run images for arm/v7 (ex: amd64, arm64, arm/v7, armv6l)")
 	flag.StringVar(&place, "place", "edge", "worker place (edge or cloud or device)")
-	flag.StringVar(&labelsStr, "labels", "", "worker labels. comma split")
+	flag.StringVar(&parent, "parent", "", "name of the upstream worker this worker is attached to (ex: the edge of a device)")
+	flag.StringVar(&labelsStr, "labels", "", "worker labels. comma split key=value or key (ex: zone=tokyo,ssd)")
+	var stepTimeout, joinTimeout time.Duration
+	flag.DurationVar(&stepTimeout, "stepTimeout", 0, "default timeout of steps without timeout (ex: 5m). 0 means no timeout")
+	flag.DurationVar(&joinTimeout, "joinTimeout", time.Hour, "how long a step after multiple steps waits for the rest of them. 0 means forever")
 	flag.Parse()
 
 	if name == "" {
 		return errors.New("name is missing")
 	}
 
+	if !domain.Place(place).IsWorkerPlace() {
+		return fmt.Errorf("unknown place %s", place)
+	}
+
 	if argMasterIP == "" {
 		return errors.New("master ip addr is missing")
 	}
@@ -84,25 +93,40 @@ func run() error {
 	if err != nil {
 		return err
 	}
+	workerURL, err := url.Parse(workerGlobalAddr)
+	if err != nil {
+		return err
+	}
 
 	log.Printf("masterAddr: %s, workerLocalAddr: %s", masterAddr, workerLocalAddr)
 
-	labels := strings.Split(labelsStr, ",")
+	// 空の--labelsで[""]にならないように、空の要素は除く
+	labels := make([]string, 0)
+	for _, l := range strings.Split(labelsStr, ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			labels = append(labels, l)
+		}
+	}
 
 	m := &worker.MasterInfo{
 		URL: u,
 	}
 	js := store.NewJob()
 	ws := store.NewWorkflow()
+	jns := store.NewJoin(joinTimeout)
 	w := worker.New(&worker.OptionsNew{
-		Type:          domain.ImageType(workerType),
-		Arch:          domain.ArchType(runtime.GOARCH),
-		Place:         domain.Place(place),
-		Labels:        labels,
-		MasterInfo:    m,
-		IPAddr:        &workerLocalIP,
-		JobStore:      js,
-		WorkflowStore: ws,
+		Type:               domain.ImageType(workerType),
+		Arch:               domain.ArchType(arch).Canonical(),
+		Place:              domain.Place(place),
+		Parent:             parent,
+		Labels:             labels,
+		MasterInfo:         m,
+		URL:                workerURL,
+		IPAddr:             &workerLocalIP,
+		JobStore:           js,
+		WorkflowStore:      ws,
+		JoinStore:          jns,
+		DefaultStepTimeout: stepTimeout,
 	})
 
 	ctx := context.Background()
@@ -128,12 +152,13 @@ func run() error {
 	}
 	w.ID = id
 
-	if place != "device" {
+	if domain.Place(place) != domain.PlaceDevice {
 		// TODO: 定期的にワーカーのリソース情報送る ↑と同じく、複数情報送れるように
 		go w.PeriodicNotifyResourceInformationToMaster(ctx)
 	}
 
 	go w.PeriodicGetWorkflows(ctx)
+	go w.PeriodicGetWorkerLatency(ctx)
 	go w.PeriodicCheckErrors(ctx)
 
 	return internalServer.Serve()
@@ -236,6 +261,7 @@ func registerWorkerToMaster(name, workerAddr, masterAddr string, wk *worker.Work
 		Type:   wk.Type,
 		Arch:   wk.Arch,
 		Place:  wk.Place,
+		Parent: wk.Parent,
 		Labels: wk.Labels,
 	}
 	body, err := json.Marshal(&workerInfo)
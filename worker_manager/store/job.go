@@ -2,16 +2,23 @@ package store
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"sync"
+	"time"
 
 	"github.com/rs/xid"
 
+	"github.com/mobmob912/takuhai/domain"
 	"github.com/mobmob912/takuhai/worker_manager/job"
 )
 
 var (
 	ErrNotFound = errors.New("not found")
+	ErrRunning  = errors.New("job is running")
+	ErrPending  = errors.New("job is pending")
 )
 
 // そのノードで配置されているアプリケーション
@@ -19,121 +26,218 @@ var (
 // pending - デプロイ中のjob
 // ready - デプロイ完了し、待機中
 // working - 稼働中
+// デプロイしたジョブはJobKeyで区別する. ワークフローが更新されても、古いバージョンのrunのジョブと混ざらない
 type Job interface {
-	ListAll(ctx context.Context) ([]job.Job, error)
-	GetFromReady(ctx context.Context, stepID string) (job.Job, error)
-	GetFromPending(ctx context.Context, stepID string) (job.Job, error)
-	SetPending(ctx context.Context, stepID string, job job.Job) error
+	// k=JobKey. デプロイ中とデプロイ済みのジョブ
+	ListAll(ctx context.Context) (map[string]job.Job, error)
+	GetFromReady(ctx context.Context, key string) (job.Job, error)
+	GetFromPending(ctx context.Context, key string) (job.Job, error)
+	// 同じステップのジョブがデプロイ中ならErrPending
+	SetPending(ctx context.Context, key string, job job.Job) error
+	// ジョブからの通知はステップIDしか持たないので、そのステップのデプロイ中のジョブをreadyにする
 	SetReadyFromPending(ctx context.Context, stepID string) error
-	SetRunningFromReady(ctx context.Context, stepID string) (jobID string, err error)
+	SetRunningFromReady(ctx context.Context, key string, rj *RunningJob) (jobID string, err error)
+	GetRunningJob(ctx context.Context, jobID string) (*RunningJob, error)
+	// 既に終了(タイムアウト)していたらErrNotFound
 	DeleteRunningJob(ctx context.Context, jobID string) error
-	IsReady(ctx context.Context, stepID string) (bool, error)
-	IsPending(ctx context.Context, stepID string) (bool, error)
-	IsRunning(ctx context.Context, stepID string) (bool, error)
+	// 実行中のジョブがあればErrRunning, デプロイ中ならErrPending. 確認と削除は同じロックの中で行う
+	DeleteReadyIfIdle(ctx context.Context, key string) (job.Job, error)
+	IsReady(ctx context.Context, key string) (bool, error)
+	IsPending(ctx context.Context, key string) (bool, error)
+	IsRunning(ctx context.Context, key string) (bool, error)
+}
+
+// デプロイしたジョブのキー. ステップのジョブの中身が変わると別のキーになる
+func JobKey(stepID string, j *domain.Job) string {
+	b, _ := json.Marshal(j)
+	sum := sha256.Sum256(b)
+	return fmt.Sprintf("%s-%x", stepID, sum[:6])
+}
+
+// 実行中のジョブがどのワークフローの実行(run)のものか
+type Run struct {
+	ID string
+	// run開始時のワークフローのバージョン. 途中でワークフローが更新されても同じバージョンで最後まで進める
+	WorkflowVersion int
+	// 同期トリガーの時、ワークフローの結果を返す先のワーカーのURL. 非同期なら空
+	ResultURL string
+}
+
+// 実行中のジョブ1つ分の情報
+type RunningJob struct {
+	Run *Run
+	// 実行しているジョブのJobKey
+	JobKey string
+	// ジョブが受け取ったデータ. 名前付きの出力を次のステップへ引き継ぐ
+	Payload *domain.Payload
+	// 何回目の実行か. 1から
+	Attempt int
+	// 過ぎたらタイムアウトとして失敗にする. ゼロ値ならタイムアウト無し
+	Deadline time.Time
 }
 
 type jobStore struct {
-	mutex       *sync.Mutex
-	allJobs     []job.Job
+	mutex *sync.Mutex
+	// k=jobID
 	runningJobs map[string]job.Job
-	readyJobs   map[string]job.Job
+	// k=JobKey
+	readyJobs map[string]job.Job
+
+	// k=jobID
+	runningInfos map[string]*RunningJob
 
-	// k=jobName
+	// k=JobKey
 	pendingJobs map[string]job.Job
 }
 
 func NewJob() Job {
 	return &jobStore{
-		mutex:       new(sync.Mutex),
-		allJobs:     make([]job.Job, 0),
-		runningJobs: make(map[string]job.Job),
-		readyJobs:   make(map[string]job.Job),
-		pendingJobs: make(map[string]job.Job),
+		mutex:        new(sync.Mutex),
+		runningJobs:  make(map[string]job.Job),
+		readyJobs:    make(map[string]job.Job),
+		runningInfos: make(map[string]*RunningJob),
+		pendingJobs:  make(map[string]job.Job),
 	}
 }
 
-func (a *jobStore) ListAll(ctx context.Context) ([]job.Job, error) {
-	return a.allJobs, nil
+// 削除と同時に呼ばれても良いようにコピーを返す
+func (a *jobStore) ListAll(ctx context.Context) (map[string]job.Job, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	jobs := make(map[string]job.Job, len(a.pendingJobs)+len(a.readyJobs))
+	for k, j := range a.pendingJobs {
+		jobs[k] = j
+	}
+	for k, j := range a.readyJobs {
+		jobs[k] = j
+	}
+	return jobs, nil
 }
 
-func (a *jobStore) GetFromReady(ctx context.Context, stepID string) (job.Job, error) {
+func (a *jobStore) GetFromReady(ctx context.Context, key string) (job.Job, error) {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
-	j, ok := a.readyJobs[stepID]
+	j, ok := a.readyJobs[key]
 	if !ok {
 		return nil, ErrNotFound
 	}
 	return j, nil
 }
 
-func (a *jobStore) GetFromPending(ctx context.Context, stepID string) (job.Job, error) {
+func (a *jobStore) GetFromPending(ctx context.Context, key string) (job.Job, error) {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
-	j, ok := a.pendingJobs[stepID]
+	j, ok := a.pendingJobs[key]
 	if !ok {
 		return nil, ErrNotFound
 	}
 	return j, nil
 }
 
-func (a *jobStore) SetPending(ctx context.Context, stepID string, job job.Job) error {
+func (a *jobStore) SetPending(ctx context.Context, key string, job job.Job) error {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
-	a.allJobs = append(a.allJobs, job)
-	a.pendingJobs[stepID] = job
+	for _, pj := range a.pendingJobs {
+		if pj.StepID() == job.StepID() {
+			return ErrPending
+		}
+	}
+	a.pendingJobs[key] = job
 	return nil
 }
 
 func (a *jobStore) SetReadyFromPending(ctx context.Context, stepID string) error {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
-	j, ok := a.pendingJobs[stepID]
-	if !ok {
-		return ErrNotFound
+	for key, j := range a.pendingJobs {
+		if j.StepID() != stepID {
+			continue
+		}
+		a.readyJobs[key] = j
+		delete(a.pendingJobs, key)
+		return nil
 	}
-	a.readyJobs[stepID] = j
-	delete(a.pendingJobs, stepID)
-	return nil
+	return ErrNotFound
 }
 
-func (a *jobStore) SetRunningFromReady(ctx context.Context, stepID string) (string, error) {
+func (a *jobStore) SetRunningFromReady(ctx context.Context, key string, rj *RunningJob) (string, error) {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
-	j, ok := a.readyJobs[stepID]
+	j, ok := a.readyJobs[key]
 	if !ok {
 		return "", ErrNotFound
 	}
 	jobID := xid.New().String()
 	a.runningJobs[jobID] = j
+	a.runningInfos[jobID] = rj
 	return jobID, nil
 }
 
-func (a *jobStore) IsPending(ctx context.Context, stepID string) (bool, error) {
+func (a *jobStore) GetRunningJob(ctx context.Context, jobID string) (*RunningJob, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	rj, ok := a.runningInfos[jobID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return rj, nil
+}
+
+func (a *jobStore) IsPending(ctx context.Context, key string) (bool, error) {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
-	_, ok := a.pendingJobs[stepID]
+	_, ok := a.pendingJobs[key]
 	return ok, nil
 }
-func (a *jobStore) IsReady(ctx context.Context, stepID string) (bool, error) {
+func (a *jobStore) IsReady(ctx context.Context, key string) (bool, error) {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
-	_, ok := a.readyJobs[stepID]
+	_, ok := a.readyJobs[key]
 	return ok, nil
 }
-func (a *jobStore) IsRunning(ctx context.Context, stepID string) (bool, error) {
+func (a *jobStore) IsRunning(ctx context.Context, key string) (bool, error) {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
+	j, ok := a.readyJobs[key]
+	if !ok {
+		return false, nil
+	}
+	return a.isRunning(j), nil
+}
+
+func (a *jobStore) isRunning(j job.Job) bool {
 	for _, rj := range a.runningJobs {
-		if rj.StepID() == stepID {
-			return true, nil
+		if rj == j {
+			return true
 		}
 	}
-	return false, nil
+	return false
 }
 
 func (a *jobStore) DeleteRunningJob(ctx context.Context, jobID string) error {
 	a.mutex.Lock()
 	defer a.mutex.Unlock()
+	if _, ok := a.runningJobs[jobID]; !ok {
+		return ErrNotFound
+	}
 	delete(a.runningJobs, jobID)
+	delete(a.runningInfos, jobID)
 	return nil
 }
+
+func (a *jobStore) DeleteReadyIfIdle(ctx context.Context, key string) (job.Job, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	j, ok := a.readyJobs[key]
+	if !ok {
+		if _, ok := a.pendingJobs[key]; ok {
+			return nil, ErrPending
+		}
+		return nil, ErrNotFound
+	}
+	if a.isRunning(j) {
+		return nil, ErrRunning
+	}
+	delete(a.readyJobs, key)
+	return j, nil
+}
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/mobmob912/takuhai/domain"
+)
+
+// Addの後の待ち合わせの状態
+type JoinState int
+
+const (
+	// まだ全ての親から届いていない
+	JoinWaiting JoinState = iota
+	// 全ての親から届いた
+	JoinReady
+	// 失敗した親が届いた. 最初の1回だけ返し、残りの親が届くまではJoinWaiting
+	JoinFailed
+)
+
+// 合流するステップ(afterが複数)の待ち合わせ
+// 全ての親ステップから結果が届くまで、runごとに途中の結果を保持する
+// 親が届かないまま残らないように、最初の結果からttl経つと捨てる
+type Join interface {
+	// 親ステップの結果を追加する。全ての親から揃ったら、親ステップID毎の結果とJoinReadyを返す
+	// 失敗した親が届いたら、保持していた結果は捨てて、揃っても実行しない
+	Add(ctx context.Context, runID, stepID, fromStepID string, payload *domain.Payload, parentCount int) (map[string]*domain.Payload, JoinState, error)
+}
+
+type join struct {
+	mutex   *sync.Mutex
+	ttl     time.Duration
+	partial map[string]*partialJoin
+}
+
+type partialJoin struct {
+	payloads map[string]*domain.Payload
+	// 届いた親ステップ. 失敗した後も数える
+	arrived map[string]bool
+	failed  bool
+}
+
+// ttlが0なら捨てない
+func NewJoin(ttl time.Duration) Join {
+	return &join{
+		mutex:   new(sync.Mutex),
+		ttl:     ttl,
+		partial: make(map[string]*partialJoin),
+	}
+}
+
+func (j *join) Add(ctx context.Context, runID, stepID, fromStepID string, payload *domain.Payload, parentCount int) (map[string]*domain.Payload, JoinState, error) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	key := runID + "/" + stepID
+	pj, ok := j.partial[key]
+	if !ok {
+		pj = &partialJoin{
+			payloads: make(map[string]*domain.Payload, parentCount),
+			arrived:  make(map[string]bool, parentCount),
+		}
+		j.partial[key] = pj
+		j.expireAfter(key, pj, parentCount)
+	}
+	pj.arrived[fromStepID] = true
+	state := JoinWaiting
+	switch {
+	case payload.Failed && !pj.failed:
+		pj.failed = true
+		pj.payloads = nil
+		state = JoinFailed
+	case !pj.failed:
+		pj.payloads[fromStepID] = payload
+	}
+	if len(pj.arrived) < parentCount {
+		return nil, state, nil
+	}
+	delete(j.partial, key)
+	if pj.failed {
+		return nil, state, nil
+	}
+	return pj.payloads, JoinReady, nil
+}
+
+func (j *join) expireAfter(key string, pj *partialJoin, parentCount int) {
+	if j.ttl <= 0 {
+		return
+	}
+	time.AfterFunc(j.ttl, func() {
+		j.mutex.Lock()
+		defer j.mutex.Unlock()
+		// 揃った後に同じキーで作り直されたものは消さない
+		if j.partial[key] != pj {
+			return
+		}
+		delete(j.partial, key)
+		log.Printf("join expired after %s. %s received %d of %d parents", j.ttl, key, len(pj.arrived), parentCount)
+	})
+}
@@ -10,15 +10,22 @@ import (
 // workflowの保存（オンメモリキャッシュ）
 type Workflow interface {
 	Get(ctx context.Context, id string) (*domain.Workflow, error)
+	// 指定したバージョンのワークフローを返す. 保持していなければ最新のものを返す
+	GetRevision(ctx context.Context, id string, version int) (*domain.Workflow, error)
 	GetJob(ctx context.Context, workflowID, stepID string) (*domain.Job, error)
 	GetByTriggerHTTPPath(ctx context.Context, triggerPath string) (*domain.Workflow, error)
 	Set(ctx context.Context, id string, workflow *domain.Workflow) error
 	UpdateAll(ctx context.Context, ws []*domain.Workflow) error
 }
 
+// 実行中のrunのために、ワークフロー毎に保持しておく過去バージョンの数
+const maxRevisions = 8
+
 type workflow struct {
 	mutex     *sync.Mutex
 	workflows map[string]*domain.Workflow
+	// k=workflowID, v=過去バージョンも含めたワークフロー (古い順)
+	revisions map[string][]*domain.Workflow
 }
 
 func (s *workflow) Get(ctx context.Context, id string) (*domain.Workflow, error) {
@@ -27,6 +34,17 @@ func (s *workflow) Get(ctx context.Context, id string) (*domain.Workflow, error)
 	return s.workflows[id], nil
 }
 
+func (s *workflow) GetRevision(ctx context.Context, id string, version int) (*domain.Workflow, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, wf := range s.revisions[id] {
+		if wf.Version == version {
+			return wf, nil
+		}
+	}
+	return s.workflows[id], nil
+}
+
 func (s *workflow) GetJob(ctx context.Context, workflowID, stepID string) (*domain.Job, error) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -34,12 +52,11 @@ func (s *workflow) GetJob(ctx context.Context, workflowID, stepID string) (*doma
 	if wk == nil {
 		return nil, ErrNotFound
 	}
-	for _, f := range wk.Steps {
-		if f.ID == stepID {
-			return f.Job, nil
-		}
+	step := wk.StepByID(stepID)
+	if step == nil {
+		return nil, ErrNotFound
 	}
-	return nil, ErrNotFound
+	return step.Job, nil
 }
 
 func (s *workflow) GetByTriggerHTTPPath(ctx context.Context, triggerPath string) (*domain.Workflow, error) {
@@ -57,6 +74,7 @@ func (s *workflow) Set(ctx context.Context, id string, v *domain.Workflow) error
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 	s.workflows[id] = v
+	s.addRevision(v)
 	return nil
 }
 
@@ -66,14 +84,31 @@ func (s *workflow) UpdateAll(ctx context.Context, ws []*domain.Workflow) error {
 	s.workflows = make(map[string]*domain.Workflow, len(ws))
 	for _, w := range ws {
 		s.workflows[w.ID] = w
+		s.addRevision(w)
 	}
 	return nil
 }
 
+func (s *workflow) addRevision(v *domain.Workflow) {
+	rs := s.revisions[v.ID]
+	for i, r := range rs {
+		if r.Version == v.Version {
+			rs[i] = v
+			return
+		}
+	}
+	rs = append(rs, v)
+	if len(rs) > maxRevisions {
+		rs = rs[len(rs)-maxRevisions:]
+	}
+	s.revisions[v.ID] = rs
+}
+
 func NewWorkflow() Workflow {
 	return &workflow{
 		mutex:     new(sync.Mutex),
 		workflows: make(map[string]*domain.Workflow),
+		revisions: make(map[string][]*domain.Workflow),
 	}
 }
 
@@ -0,0 +1,36 @@
+package worker
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	ErrDraining = errors.New("worker is draining")
+)
+
+// masterにdrainされている間は、新しいrunと、デプロイが必要なステップを受け付けない
+// 全てアンデプロイした後に、ジョブがデプロイし直されないようにする
+type drainState struct {
+	mutex    *sync.Mutex
+	draining bool
+}
+
+func newDrainState() *drainState {
+	return &drainState{
+		mutex: new(sync.Mutex),
+	}
+}
+
+// masterがdrainを始める時にtrue、uncordonした時にfalseにする
+func (w *Worker) SetDraining(draining bool) {
+	w.drain.mutex.Lock()
+	defer w.drain.mutex.Unlock()
+	w.drain.draining = draining
+}
+
+func (w *Worker) IsDraining() bool {
+	w.drain.mutex.Lock()
+	defer w.drain.mutex.Unlock()
+	return w.drain.draining
+}
@@ -0,0 +1,108 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/master/api"
+	"github.com/mobmob912/takuhai/worker_manager/store"
+)
+
+// 失敗したジョブを、ステップのリトライ設定に従ってもう一度実行する
+// リトライを使い切ったらfailureのステップへ進める
+func (w *Worker) handleStepFailure(ctx context.Context, workflowID, stepID string, rj *store.RunningJob, reason domain.RetryReason, body []byte) error {
+	wf, err := w.getWorkflowOfRun(ctx, workflowID, rj.Run)
+	if err != nil {
+		return err
+	}
+	step := wf.StepByID(stepID)
+	if step == nil {
+		return ErrNotFoundStep
+	}
+	retry := step.Retry.ShouldRetry(reason, rj.Attempt)
+	w.reportRunEvent(rj.Run, &domain.RunEvent{
+		Type:       domain.RunEventStepFailed,
+		WorkflowID: workflowID,
+		StepID:     stepID,
+		Attempt:    rj.Attempt,
+		Message:    fmt.Sprintf("%s: %s", reason, body),
+		Final:      !retry,
+	})
+	if !retry {
+		return w.failStep(ctx, wf, step, rj.Run, rj.Payload.Next("", body))
+	}
+	backoff := step.Retry.BackoffAfter(rj.Attempt)
+	log.Printf("retry step %s (%d/%d) after %s. reason: %s", step.Name, rj.Attempt+1, step.Retry.MaxAttempts, backoff, reason)
+	next := &store.RunningJob{
+		Run:     rj.Run,
+		Payload: rj.Payload,
+		Attempt: rj.Attempt + 1,
+	}
+	time.AfterFunc(backoff, func() {
+		err := w.runJob(context.Background(), workflowID, stepID, next)
+		if err == ErrDraining {
+			err = w.retryStepElsewhere(context.Background(), wf, step, next)
+		}
+		if err != nil {
+			w.AddError(err)
+		}
+	})
+	return nil
+}
+
+// drain中にジョブがアンデプロイされていたら、masterが選んだ他のワーカーでやり直す
+// 合流するステップは親ステップの結果を集め直せないので、失敗にする
+func (w *Worker) retryStepElsewhere(ctx context.Context, wf *domain.Workflow, step *domain.Step, rj *store.RunningJob) error {
+	if step.IsJoin() {
+		return w.failStep(ctx, wf, step, rj.Run, rj.Payload)
+	}
+	log.Printf("retry step %s on another worker because this worker is draining", step.Name)
+	_, _, err := w.requestDoStep(ctx, wf.ID, "", rj.Run, step, rj.Payload)
+	return err
+}
+
+// failureのステップへ進める. failureのステップが無ければ記録だけする
+// 後続のステップは実行されないので、合流するステップの待ち合わせを捨てさせる
+func (w *Worker) failStep(ctx context.Context, wf *domain.Workflow, step *domain.Step, run *store.Run, payload *domain.Payload) error {
+	if err := w.abandonNextSteps(ctx, wf, step, run); err != nil {
+		w.AddError(err)
+	}
+	if step.Failure == nil {
+		w.AddError(fmt.Errorf("step %s failed and has no failure step. run id: %s", step.Name, run.ID))
+		return nil
+	}
+	_, _, err := w.requestDoStep(ctx, wf.ID, step.ID, run, step.Failure, payload)
+	return err
+}
+
+// 次のステップのワーカーへデータを渡す
+// 渡せなかった時は次のステップのリトライ設定に従ってやり直し、使い切ったらfailureのステップへ進める
+func (w *Worker) transferStep(ctx context.Context, wf *domain.Workflow, fromStepID string, run *store.Run, step *domain.Step, payload *domain.Payload) (*api.ResponseWorker, time.Duration, error) {
+	for attempt := 1; ; attempt++ {
+		wk, delay, err := w.requestDoStep(ctx, wf.ID, fromStepID, run, step, payload)
+		if err == nil {
+			return wk, delay, nil
+		}
+		if !step.Retry.ShouldRetry(domain.RetryOnTransfer, attempt) {
+			// 次のステップは開始していないので、諦めた時だけ報告する
+			w.reportRunEvent(run, &domain.RunEvent{
+				Type:       domain.RunEventStepFailed,
+				WorkflowID: wf.ID,
+				StepID:     step.ID,
+				Attempt:    attempt,
+				Message:    fmt.Sprintf("%s: %s", domain.RetryOnTransfer, err),
+				Final:      true,
+			})
+			if ferr := w.failStep(ctx, wf, step, run, payload.Next("", []byte(err.Error()))); ferr != nil {
+				w.AddError(ferr)
+			}
+			return nil, 0, err
+		}
+		backoff := step.Retry.BackoffAfter(attempt)
+		log.Printf("retry transfer to step %s (%d/%d) after %s. %s", step.Name, attempt+1, step.Retry.MaxAttempts, backoff, err)
+		time.Sleep(backoff)
+	}
+}
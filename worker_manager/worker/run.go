@@ -0,0 +1,201 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/worker_manager/store"
+)
+
+// ワーカー間でステップを受け渡す時に、ワークフローの実行(run)を識別するためのヘッダ
+const (
+	HeaderRunID           = "takuhai-run-id"
+	HeaderWorkflowVersion = "takuhai-workflow-version"
+	HeaderFromStepID      = "takuhai-from-step-id"
+	HeaderResultURL       = "takuhai-result-url"
+)
+
+func setRunHeader(req *http.Request, run *store.Run, fromStepID string) {
+	req.Header.Set(HeaderRunID, run.ID)
+	req.Header.Set(HeaderWorkflowVersion, strconv.Itoa(run.WorkflowVersion))
+	req.Header.Set(HeaderFromStepID, fromStepID)
+	if run.ResultURL != "" {
+		req.Header.Set(HeaderResultURL, run.ResultURL)
+	}
+}
+
+func RunFromHeader(h http.Header) *store.Run {
+	version, _ := strconv.Atoi(h.Get(HeaderWorkflowVersion))
+	return &store.Run{
+		ID:              h.Get(HeaderRunID),
+		WorkflowVersion: version,
+		ResultURL:       h.Get(HeaderResultURL),
+	}
+}
+
+// masterへrunの出来事を報告する. 届かなくてもステップの実行は止めない
+func (w *Worker) reportRunEvent(run *store.Run, e *domain.RunEvent) {
+	e.WorkflowVersion = run.WorkflowVersion
+	e.WorkerID = w.ID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	go func() {
+		reqBody, err := json.Marshal(e)
+		if err != nil {
+			w.AddError(err)
+			return
+		}
+		u := *w.MasterInfo.URL
+		u.Path = fmt.Sprintf("/runs/%s/events", run.ID)
+		resp, err := http.Post(u.String(), "application/json", bytes.NewReader(reqBody))
+		if err != nil {
+			w.AddError(err)
+			return
+		}
+		resp.Body.Close()
+	}()
+}
+
+// runが開始された時のバージョンのワークフローを返す
+func (w *Worker) getWorkflowOfRun(ctx context.Context, workflowID string, run *store.Run) (*domain.Workflow, error) {
+	wf, err := w.WorkflowStore.GetRevision(ctx, workflowID, run.WorkflowVersion)
+	if err != nil {
+		return nil, err
+	}
+	if wf == nil {
+		return nil, ErrNotFoundWorkflow
+	}
+	return wf, nil
+}
+
+// 他のワーカー(または自分)からステップの実行依頼を受け取る
+// 合流するステップは全ての親ステップの結果が揃うまで待ってから実行する
+func (w *Worker) ReceiveStep(ctx context.Context, workflowID, stepID, fromStepID string, run *store.Run, payload *domain.Payload) error {
+	wf, err := w.getWorkflowOfRun(ctx, workflowID, run)
+	if err != nil {
+		return err
+	}
+	step := wf.StepByID(stepID)
+	if step == nil {
+		return ErrNotFoundStep
+	}
+	if !step.IsJoin() {
+		if payload.Skipped {
+			return w.skipStep(ctx, wf, step, run)
+		}
+		return w.RunJob(ctx, workflowID, stepID, run, payload)
+	}
+	payloads, state, err := w.JoinStore.Add(ctx, run.ID, stepID, fromStepID, payload, len(step.AfterByID))
+	if err != nil {
+		return err
+	}
+	switch state {
+	case store.JoinWaiting:
+		return nil
+	case store.JoinFailed:
+		log.Printf("step %s will not run because a parent step failed. run id: %s", step.Name, run.ID)
+		return w.abandonNextSteps(ctx, wf, step, run)
+	}
+	// 実行されなかった親ステップの結果は含めない. 全ての親が実行されなかったら、このステップも実行しない
+	for parentID, p := range payloads {
+		if p.Skipped {
+			delete(payloads, parentID)
+		}
+	}
+	if len(payloads) == 0 {
+		return w.skipStep(ctx, wf, step, run)
+	}
+	merged, err := mergeJoinedPayloads(wf, step, payloads)
+	if err != nil {
+		return err
+	}
+	if !shouldRunStep(step, merged) {
+		return w.skipStep(ctx, wf, step, run)
+	}
+	return w.RunJob(ctx, workflowID, stepID, run, merged)
+}
+
+// whenを評価する. 評価できない時は実行しない
+func shouldRunStep(step *domain.Step, payload *domain.Payload) bool {
+	ok, err := step.ShouldRun(payload)
+	if err != nil {
+		log.Printf("failed to evaluate when of step %s. %s", step.Name, err)
+		return false
+	}
+	return ok
+}
+
+// ステップを実行せずに飛ばす
+// 後続の合流するステップは全ての親を待っているので、実行されなかったことを伝える
+func (w *Worker) skipStep(ctx context.Context, wf *domain.Workflow, step *domain.Step, run *store.Run) error {
+	log.Printf("skip step %s", step.Name)
+	w.reportRunEvent(run, &domain.RunEvent{
+		Type:       domain.RunEventStepSkipped,
+		WorkflowID: wf.ID,
+		StepID:     step.ID,
+	})
+	eg := errgroup.Group{}
+	for _, s := range wf.NextStepsByCurrentStepID(step.ID) {
+		s := s
+		if !s.IsJoin() {
+			eg.Go(func() error {
+				return w.skipStep(ctx, wf, s, run)
+			})
+			continue
+		}
+		eg.Go(func() error {
+			_, _, err := w.requestDoStep(ctx, wf.ID, step.ID, run, s, domain.SkippedPayload())
+			return err
+		})
+	}
+	return eg.Wait()
+}
+
+// 失敗して実行されないステップの後続へ進める
+// 後続の合流するステップが残りの親を待ち続けないように、失敗したことを伝える
+func (w *Worker) abandonNextSteps(ctx context.Context, wf *domain.Workflow, step *domain.Step, run *store.Run) error {
+	eg := errgroup.Group{}
+	for _, s := range wf.NextStepsByCurrentStepID(step.ID) {
+		s := s
+		if !s.IsJoin() {
+			eg.Go(func() error {
+				return w.abandonNextSteps(ctx, wf, s, run)
+			})
+			continue
+		}
+		eg.Go(func() error {
+			_, _, err := w.requestDoStep(ctx, wf.ID, step.ID, run, s, domain.FailedPayload())
+			return err
+		})
+	}
+	return eg.Wait()
+}
+
+// 親ステップ名をキーにして結果をまとめる
+func mergeJoinedPayloads(wf *domain.Workflow, step *domain.Step, payloads map[string]*domain.Payload) (*domain.Payload, error) {
+	names := make([]string, 0, len(payloads))
+	ps := make([]*domain.Payload, 0, len(payloads))
+	for _, parentID := range step.AfterByID {
+		parent := wf.StepByID(parentID)
+		if parent == nil {
+			return nil, ErrNotFoundStep
+		}
+		p, ok := payloads[parentID]
+		if !ok {
+			continue
+		}
+		names = append(names, parent.Name)
+		ps = append(ps, p)
+	}
+	return domain.MergePayloads(names, ps)
+}
@@ -0,0 +1,108 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/worker_manager/store"
+)
+
+var (
+	ErrRunResultTimeout  = errors.New("timeout waiting for workflow result")
+	ErrNotFoundRunResult = errors.New("not found run waiting for result")
+)
+
+// HTTPトリガーでワークフローを開始した結果. 同期トリガーの時だけOutputが入る
+type TriggerResult struct {
+	Sync   bool
+	Output []byte
+}
+
+// 同期トリガーで結果を待っているrun
+type runResults struct {
+	mu sync.Mutex
+	// k=runID
+	waiters map[string]chan []byte
+}
+
+func newRunResults() *runResults {
+	return &runResults{
+		waiters: make(map[string]chan []byte),
+	}
+}
+
+func (r *runResults) wait(runID string) chan []byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ch := make(chan []byte, 1)
+	r.waiters[runID] = ch
+	return ch
+}
+
+func (r *runResults) cancel(runID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.waiters, runID)
+}
+
+func (r *runResults) deliver(runID string, output []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ch, ok := r.waiters[runID]
+	if !ok {
+		return ErrNotFoundRunResult
+	}
+	delete(r.waiters, runID)
+	ch <- output
+	return nil
+}
+
+// trigger.resultが届くまで待つ. 来なければErrRunResultTimeout
+func (w *Worker) startWorkflowSync(ctx context.Context, wf *domain.Workflow, body []byte) ([]byte, error) {
+	run := newRun(wf)
+	u := *w.URL
+	u.Path = fmt.Sprintf("/runs/%s/result", run.ID)
+	run.ResultURL = u.String()
+
+	ch := w.results.wait(run.ID)
+	defer w.results.cancel(run.ID)
+	if err := w.startWorkflow(ctx, wf, run, body); err != nil {
+		return nil, err
+	}
+	select {
+	case output := <-ch:
+		return output, nil
+	case <-time.After(wf.Trigger.TimeoutDuration()):
+		return nil, ErrRunResultTimeout
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// 他のワーカーから届いた同期トリガーの結果を、待っているリクエストへ渡す
+func (w *Worker) ReceiveRunResult(ctx context.Context, runID string, output []byte) error {
+	return w.results.deliver(runID, output)
+}
+
+// ワークフローの結果を、同期トリガーを受けたワーカーへ返す
+func (w *Worker) sendRunResult(ctx context.Context, run *store.Run, output []byte) error {
+	req, err := http.NewRequest(http.MethodPost, run.ResultURL, bytes.NewReader(output))
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("send run result error. status: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/worker_manager/store"
+)
+
+func (w *Worker) stepTimeout(step *domain.Step) time.Duration {
+	if d := step.TimeoutDuration(); d > 0 {
+		return d
+	}
+	return w.DefaultStepTimeout
+}
+
+// ジョブを実行中にする. タイムアウトがあれば期限を記録して、過ぎたら失敗として扱う
+func (w *Worker) startRunningJob(ctx context.Context, workflowID string, step *domain.Step, rj *store.RunningJob) (string, error) {
+	timeout := w.stepTimeout(step)
+	if timeout > 0 {
+		rj.Deadline = time.Now().Add(timeout)
+	}
+	rj.JobKey = store.JobKey(step.ID, step.Job)
+	jobID, err := w.JobStore.SetRunningFromReady(ctx, rj.JobKey, rj)
+	if err != nil {
+		return "", err
+	}
+	w.reportRunEvent(rj.Run, &domain.RunEvent{
+		Type:       domain.RunEventStepStarted,
+		WorkflowID: workflowID,
+		StepID:     step.ID,
+		Attempt:    rj.Attempt,
+	})
+	if timeout > 0 {
+		time.AfterFunc(timeout, func() {
+			w.expireJob(context.Background(), workflowID, step.ID, jobID, timeout)
+		})
+	}
+	return jobID, nil
+}
+
+// 期限までにnext, fail, finishが呼ばれなかったジョブを失敗にする
+func (w *Worker) expireJob(ctx context.Context, workflowID, stepID, jobID string, timeout time.Duration) {
+	rj, err := w.JobStore.GetRunningJob(ctx, jobID)
+	if err == store.ErrNotFound {
+		return
+	}
+	if err != nil {
+		w.AddError(err)
+		return
+	}
+	// 同時に終了したジョブは、先にDeleteRunningJobした方が処理する
+	if err := w.JobStore.DeleteRunningJob(ctx, jobID); err == store.ErrNotFound {
+		return
+	} else if err != nil {
+		w.AddError(err)
+		return
+	}
+	w.undeployJobIfOutdated(ctx, workflowID, stepID, rj.JobKey)
+	msg := fmt.Sprintf("step %s timed out after %s. job id: %s", stepID, timeout, jobID)
+	w.AddError(fmt.Errorf("%s", msg))
+	if err := w.handleStepFailure(ctx, workflowID, stepID, rj, domain.RetryOnTimeout, []byte(msg)); err != nil {
+		w.AddError(err)
+	}
+}
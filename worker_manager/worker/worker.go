@@ -11,6 +11,8 @@ import (
 	"net/http"
 	"net/url"
 	"io/ioutil"
+	"strconv"
+	"sync"
 	"time"
 
 	"golang.org/x/sync/errgroup"
@@ -32,22 +34,12 @@ import (
 	"github.com/shirou/gopsutil/mem"
 	"github.com/mobmob912/takuhai/master/api"
 )
-var nowstep *domain.Step
 type MasterInfo struct {
 	URL *url.URL
 }
 type WorkerResponse struct {
 	Time time.Time
 }
-type ToWorker struct {
-	Name string
-	Time time.Duration
-
-}
-type WorkerResponse2 struct {
-	FromID string
-	ToWorkers []ToWorker
-}
 
 
 type ToNode  struct {
@@ -62,24 +54,37 @@ type Worker struct {
 	Type          domain.ImageType
 	Arch          domain.ArchType
 	Place         domain.Place
+	Parent        string
 	Labels        []string
 	OtherWorkers  []ToNode
 	MasterInfo    *MasterInfo
+	URL           *url.URL
 	LocalIPAddr   *net.IP
 	Errors        []error
 	JobStore      store.Job
 	WorkflowStore store.Workflow
+	JoinStore     store.Join
+	// timeoutが無いステップの実行時間の上限. 0ならタイムアウト無し
+	DefaultStepTimeout time.Duration
+
+	results *runResults
+	drain   *drainState
 }
 
 type OptionsNew struct {
 	Type          domain.ImageType
 	Arch          domain.ArchType
 	Place         domain.Place
+	Parent        string
 	Labels        []string
 	MasterInfo    *MasterInfo
+	URL           *url.URL
 	IPAddr        *net.IP
 	JobStore      store.Job
 	WorkflowStore store.Workflow
+	JoinStore     store.Join
+	// timeoutが無いステップの実行時間の上限. 0ならタイムアウト無し
+	DefaultStepTimeout time.Duration
 }
 type Content struct {
 	Body           []byte        
@@ -91,17 +96,23 @@ type Content struct {
 
 func New(opts *OptionsNew) *Worker {
 	return &Worker{
-		ID:            "",
-		Type:          opts.Type,
-		Arch:          opts.Arch,
-		Place:         opts.Place,
-		Labels:        opts.Labels,
-		OtherWorkers:   nil,
-		MasterInfo:    opts.MasterInfo,
-		LocalIPAddr:   opts.IPAddr,
-		Errors:        nil,
-		JobStore:      opts.JobStore,
-		WorkflowStore: opts.WorkflowStore,
+		ID:                 "",
+		Type:               opts.Type,
+		Arch:               opts.Arch,
+		Place:              opts.Place,
+		Parent:             opts.Parent,
+		Labels:             opts.Labels,
+		OtherWorkers:       nil,
+		MasterInfo:         opts.MasterInfo,
+		URL:                opts.URL,
+		LocalIPAddr:        opts.IPAddr,
+		Errors:             nil,
+		JobStore:           opts.JobStore,
+		WorkflowStore:      opts.WorkflowStore,
+		JoinStore:          opts.JoinStore,
+		DefaultStepTimeout: opts.DefaultStepTimeout,
+		results:            newRunResults(),
+		drain:              newDrainState(),
 	}
 }
 
@@ -137,8 +148,7 @@ func (w *Worker) PeriodicNotifyResourceInformationToMaster(ctx context.Context)
 		if err != nil {
 			w.AddError(err)
 		}
-		client := http.DefaultClient
-		client.Timeout = 3 * time.Second
+		client := &http.Client{Timeout: masterRequestTimeout}
 		req, err := http.NewRequest("PUT", fmt.Sprintf("%s/workers/%s", w.MasterInfo.URL.String(), w.ID), bytes.NewReader(reqBody))
 		if err != nil {
 			w.AddError(err)
@@ -151,8 +161,7 @@ func (w *Worker) PeriodicNotifyResourceInformationToMaster(ctx context.Context)
 
 func (w *Worker) PeriodicGetWorkflows(ctx context.Context) {
 	for {
-		c := http.DefaultClient
-		c.Timeout = 3 * time.Second
+		c := &http.Client{Timeout: masterRequestTimeout}
 		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/workflows", w.MasterInfo.URL.String()), nil)
 		if err != nil {
 			w.AddError(err)
@@ -168,7 +177,7 @@ func (w *Worker) PeriodicGetWorkflows(ctx context.Context) {
 			w.AddError(err)
 			continue
 		}
-		if err := w.WorkflowStore.UpdateAll(ctx, ws); err != nil {
+		if err := w.UpdateWorkflows(ctx, ws); err != nil {
 			w.AddError(err)
 			continue
 		}
@@ -218,6 +227,8 @@ func (w *Worker) RegisterDeployedJob(ctx context.Context, stepID string) (string
 
 var (
 	ErrNotFoundSatisfiedImage = errors.New("not found satisfied image")
+	ErrNotFoundWorkflow       = errors.New("not found workflow")
+	ErrNotFoundStep           = errors.New("not found step")
 )
 
 // 時間かかるのでgoroutineで呼ぶべき
@@ -228,27 +239,35 @@ func (w *Worker) DeployJob(ctx context.Context, workflowID, stepID string) error
 	if err != nil {
 		return err
 	}
+	return w.deployJob(ctx, workflowID, stepID, jobInfo)
+}
 
-	for _, img := range jobInfo.Images {
-		if img.Type == w.Type && img.Arch == w.Arch {
-			return w.deployJobByType(ctx, &optionsDeployJobByType{
-				imageType:  img.Type,
-				stepID:     stepID,
-				name:       jobInfo.Name,
-				image:      img.Image,
-				workflowID: workflowID,
-			})
-		}
-	}
-	return ErrNotFoundSatisfiedImage
+// runのバージョンのステップから取ったジョブをデプロイする
+func (w *Worker) deployJob(ctx context.Context, workflowID, stepID string, jobInfo *domain.Job) error {
+	// masterが配置を決めた時と同じ判定で、最も具体的に合うイメージを選ぶ
+	img := jobInfo.ImageFor(w.Type, w.Arch)
+	if img == nil {
+		return ErrNotFoundSatisfiedImage
+	}
+	return w.deployJobByType(ctx, &optionsDeployJobByType{
+		imageType:  w.Type,
+		stepID:     stepID,
+		key:        store.JobKey(stepID, jobInfo),
+		name:       jobInfo.Name,
+		image:      img.Image,
+		workflowID: workflowID,
+		limits:     &jobInfo.Limits,
+	})
 }
 
 type optionsDeployJobByType struct {
 	imageType  domain.ImageType
 	stepID     string
+	key        string
 	name       string
 	image      string
 	workflowID string
+	limits     *domain.Limits
 }
 
 func (w *Worker) deployJobByType(ctx context.Context, opts *optionsDeployJobByType) error {
@@ -260,24 +279,51 @@ func (w *Worker) deployJobByType(ctx context.Context, opts *optionsDeployJobByTy
 		if err != nil {
 			return err
 		}
-		j = container.New(cli, opts.stepID, opts.workflowID, opts.name, opts.image, w.LocalIPAddr)
+		j = container.New(cli, opts.stepID, opts.key, opts.workflowID, opts.name, opts.image, opts.limits, w.LocalIPAddr)
 		log.Println("container found")
 	case domain.ImageTypeShell:
-		j = shell.New(opts.stepID, opts.workflowID, opts.name, opts.image, w.LocalIPAddr)
+		j = shell.New(opts.stepID, opts.key, opts.workflowID, opts.name, opts.image, opts.limits, w.LocalIPAddr)
 		log.Println("shell found")
 	default:
 		return errors.New("invalid jobType")
 	}
+	// デプロイ完了の通知より先にpendingにしておく
+	if err := w.JobStore.SetPending(ctx, opts.key, j); err != nil {
+		return err
+	}
 	log.Println("deploy start")
 	go j.Deploy(context.Background())
-	return w.JobStore.SetPending(ctx, opts.stepID, j)
+	return nil
 }
 
-func (w *Worker) RunJobAfterJobIsReady(ctx context.Context, stepID string, body []byte) error {
+// 同じステップの他のジョブがデプロイ中なら、終わるまで待ってからデプロイする
+// 待っている間に同じジョブがデプロイされていれば、それを使う
+func (w *Worker) deployJobAfterStepIsNotPending(ctx context.Context, workflowID string, step *domain.Step) error {
+	key := store.JobKey(step.ID, step.Job)
+	for {
+		ready, err := w.JobStore.IsReady(ctx, key)
+		if err != nil {
+			return err
+		}
+		pending, err := w.JobStore.IsPending(ctx, key)
+		if err != nil {
+			return err
+		}
+		if ready || pending {
+			return nil
+		}
+		if err := w.deployJob(ctx, workflowID, step.ID, step.Job); err != store.ErrPending {
+			return err
+		}
+		time.Sleep(1 * time.Second)
+	}
+}
+
+func (w *Worker) RunJobAfterJobIsReady(ctx context.Context, workflowID string, step *domain.Step, rj *store.RunningJob, input []byte) error {
 	for {
 		log.Println("run job after job is ready...")
 		time.Sleep(1 * time.Second)
-		j, err := w.JobStore.GetFromReady(ctx, stepID)
+		j, err := w.JobStore.GetFromReady(ctx, store.JobKey(step.ID, step.Job))
 		if err != nil {
 			if err != store.ErrNotFound {
 				log.Println(err)
@@ -287,11 +333,11 @@ func (w *Worker) RunJobAfterJobIsReady(ctx context.Context, stepID string, body
 		}
 		log.Println("deployed. do")
 		// job deployed
-		jobID, err := w.JobStore.SetRunningFromReady(ctx, stepID)
+		jobID, err := w.startRunningJob(ctx, workflowID, step, rj)
 		if err != nil {
 			return err
 		}
-		if err := j.Do(ctx, jobID, body); err != nil {
+		if err := j.Do(ctx, jobID, input); err != nil {
 			log.Println(err)
 			return err
 		}
@@ -300,67 +346,65 @@ func (w *Worker) RunJobAfterJobIsReady(ctx context.Context, stepID string, body
 }
 
 func (w *Worker) NextJob(ctx context.Context, workflowID, currentStepID, currentJobID string, body []byte) error {
-	wf, err := w.WorkflowStore.Get(ctx, workflowID)
+	rj, err := w.JobStore.GetRunningJob(ctx, currentJobID)
+	if err != nil {
+		return err
+	}
+	wf, err := w.getWorkflowOfRun(ctx, workflowID, rj.Run)
 	if err != nil {
 		return err
 	}
-
 	if err := w.JobStore.DeleteRunningJob(ctx, currentJobID); err != nil {
 		return err
 	}
-	nextSteps := wf.NextStepsByCurrentStepID(currentStepID)
-	nowstep = wf.StepByCurrentStepID(currentStepID)
-	// TODO workflowが終了した時
-	if len(nextSteps) == 0 {	
+	w.undeployJobIfOutdated(ctx, workflowID, currentStepID, rj.JobKey)
+	w.reportRunEvent(rj.Run, &domain.RunEvent{
+		Type:       domain.RunEventStepSucceeded,
+		WorkflowID: workflowID,
+		StepID:     currentStepID,
+		Attempt:    rj.Attempt,
+	})
 	wkr := &Content{}
 	if err = json.Unmarshal(body, wkr); err != nil {
 		return err
-		
 	}
-	rbody := wkr.Body
-	log.Println(string(rbody))
+	log.Println(string(wkr.Body))
 	log.Printf("worker runtime")
 	log.Println(wkr.Runtime)
 	log.Printf("worker ram")
 	log.Println(wkr.RAM)
 	log.Printf("worker cpu")
 	log.Println(wkr.CPU)
-	
-	
-	var delay time.Duration 
-	delay = 0
-	log.Println("Come4!!")
-	
-    	log.Println(delay)
-    	c := http.DefaultClient
-	u := *w.MasterInfo.URL
-    	
-	info := &api.DelayInfo {
-	JobName:      nowstep.Name,
-	FromWorkerID: w.ID,
-	ToWorkerName: "Home",
-	RAM:          wkr.RAM,
-	CPU:          wkr.CPU,
-	Runtime:      wkr.Runtime,
-	Time:         delay,
-	}
-		reqBody, err := json.Marshal(&info)
-		
-		if err != nil {
-		return  err
-	}
 
-	u.Path = fmt.Sprintf("/delayinfo")
-	
-	req2, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(reqBody))
-	if err != nil {
-		return  err
+	current := wf.StepByID(currentStepID)
+	if current == nil {
+		return ErrNotFoundStep
 	}
-	_, err = c.Do(req2)
-	if err != nil {
-		return  err
+	outputName := current.OutputName()
+	payload := rj.Payload.Next(outputName, wkr.Body)
+	// 同期トリガーのrunなら、ワークフローの結果を受けたワーカーへ返す
+	if rj.Run.ResultURL != "" && outputName != "" && outputName == wf.Trigger.Result {
+		if err := w.sendRunResult(ctx, rj.Run, wkr.Body); err != nil {
+			w.AddError(err)
+		}
 	}
-		log.Println("\n\n\nworkflow end\n\n\n")
+	info := &api.DelayInfo{
+		WorkflowID:      workflowID,
+		WorkflowVersion: rj.Run.WorkflowVersion,
+		RunID:           rj.Run.ID,
+		StepID:          currentStepID,
+		Attempt:         rj.Attempt,
+		JobName:         current.Name,
+		FromWorkerID:    w.ID,
+		RAM:             wkr.RAM,
+		CPU:             wkr.CPU,
+		Runtime:         wkr.Runtime,
+	}
+
+	nextSteps := wf.NextStepsByCurrentStepID(currentStepID)
+	if len(nextSteps) == 0 {
+		w.reportDelayInfo(info)
+		log.Println("workflow end")
 		return nil
 	}
 
@@ -369,172 +413,230 @@ func (w *Worker) NextJob(ctx context.Context, workflowID, currentStepID, current
 	for _, s := range nextSteps {
 		s := s
 		eg.Go(func() error {
-		
-			return w.requestDoStep(ctx, workflowID, s, body)
+			// 合流するステップのwhenは、全ての親の結果が揃ってから評価する
+			if !s.IsJoin() && !shouldRunStep(s, payload) {
+				return w.skipStep(ctx, wf, s, rj.Run)
+			}
+			wk, delay, err := w.transferStep(ctx, wf, currentStepID, rj.Run, s, payload)
+			if err != nil {
+				return err
+			}
+			transferred := *info
+			transferred.ToWorkerName = wk.Name
+			transferred.Time = delay
+			w.reportDelayInfo(&transferred)
+			return nil
 		})
 	}
 	return eg.Wait()
 }
+
+// masterへステップの実行時間と転送時間を送る. 届かなくてもステップの実行は止めない
+func (w *Worker) reportDelayInfo(info *api.DelayInfo) {
+	go func() {
+		reqBody, err := json.Marshal(info)
+		if err != nil {
+			w.AddError(err)
+			return
+		}
+		u := *w.MasterInfo.URL
+		u.Path = "/delayinfo"
+		resp, err := http.Post(u.String(), "application/json", bytes.NewReader(reqBody))
+		if err != nil {
+			w.AddError(err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			w.AddError(fmt.Errorf("send delay info error. status: %d", resp.StatusCode))
+		}
+	}()
+}
+
+// 他のワーカーとのレイテンシを定期的に計測してmasterへ送る
 func (w *Worker) PeriodicGetWorkerLatency(ctx context.Context) {
-for {
-	time.Sleep(10 * time.Second)
-	client := http.DefaultClient
-	var send WorkerResponse2
-	send.FromID = w.ID
-    for _ , i := range w.OtherWorkers {
-	 	fmt.Println(i.Name)
-		var addwo ToWorker
-		var flows string ="hello"
-		reqBody2, _ := json.Marshal(flows)
-	
-	req2, _ := http.NewRequest("POST", i.URL.String()+"/reply", bytes.NewReader(reqBody2))
-	
-	start := time.Now() 
-	res, _ := client.Do(req2)
-	
-	delay := time.Since(start)
-	resBody, _ := ioutil.ReadAll(res.Body)
-	log.Println(string(resBody))	
-	addwo.Name = i.Name
-	addwo.Time = delay / 2
-	send.ToWorkers = append(send.ToWorkers,addwo)
-	}
-	
-	reqBody, err := json.Marshal(send)
-	if err != nil {
-		w.AddError(err)
-	}
-	req, err := http.NewRequest("POST", fmt.Sprintf("%s/workers/response", w.MasterInfo.URL.String()), bytes.NewReader(reqBody))
-	if err != nil {
-		w.AddError(err)
-	}
-	if _, err := client.Do(req); err != nil {
-		w.AddError(errors.New(fmt.Sprintf(err.Error())))
-	}
+	for {
+		time.Sleep(10 * time.Second)
+		if err := w.measureWorkerLatencies(ctx); err != nil {
+			w.AddError(err)
+		}
 	}
 }
 
-func (w *Worker) requestDoStep(ctx context.Context, workflowID string,   step *domain.Step, body []byte) error {
-	c := http.DefaultClient
-	u := *w.MasterInfo.URL
-	u.Path = fmt.Sprintf("/workflows/%s/steps/%s/worker/%s", workflowID, step.ID, w.ID)
-	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+func (w *Worker) measureWorkerLatencies(ctx context.Context) error {
+	wks, err := w.listWorkers(ctx)
 	if err != nil {
 		return err
 	}
-	resp, err := c.Do(req)
+	reqBody := &api.WorkerLatenciesRequest{
+		Latencies: make([]*api.WorkerLatency, 0, len(wks)),
+	}
+	for _, wk := range wks {
+		if wk.ID == w.ID {
+			continue
+		}
+		d, err := measureLatency(wk.URL)
+		if err != nil {
+			w.AddError(err)
+			continue
+		}
+		reqBody.Latencies = append(reqBody.Latencies, &api.WorkerLatency{
+			WorkerID: wk.ID,
+			Latency:  d,
+		})
+	}
+	b, err := json.Marshal(reqBody)
 	if err != nil {
 		return err
 	}
-	var wk api.ResponseWorker
-	if err := json.NewDecoder(resp.Body).Decode(&wk); err != nil {
+	u := *w.MasterInfo.URL
+	u.Path = fmt.Sprintf("/workers/%s/latencies", w.ID)
+	resp, err := http.Post(u.String(), "application/json", bytes.NewReader(b))
+	if err != nil {
 		return err
 	}
-	wkr := &Content{}
-	if err = json.Unmarshal(body, wkr); err != nil {
-		return err
-		
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("send latencies error. status: %d", resp.StatusCode)
 	}
-	rbody := wkr.Body
-	log.Println(string(rbody))
-	
-	wkURL := fmt.Sprintf("%s/workflows/%s/steps/%s", wk.URL, workflowID, step.ID)
-	req, err = http.NewRequest(http.MethodPost, wkURL, bytes.NewReader(rbody))
+	return nil
+}
+
+func (w *Worker) listWorkers(ctx context.Context) ([]*api.WorkerInfoResponse, error) {
+	u := *w.MasterInfo.URL
+	u.Path = "/workers"
+	resp, err := http.Get(u.String())
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("list workers error. status: %d", resp.StatusCode)
 	}
-	if  wk.ID != w.ID{
+	var wks []*api.WorkerInfoResponse
+	if err := json.NewDecoder(resp.Body).Decode(&wks); err != nil {
+		return nil, err
+	}
+	return wks, nil
+}
+
+// 往復にかかった時間の半分を片道のレイテンシとする
+func measureLatency(workerURL string) (time.Duration, error) {
 	start := time.Now()
-	resp2, _ := c.Do(req)
-	delay := time.Since(start)
-	var resBody WorkerResponse
-	if err := json.NewDecoder(resp2.Body).Decode(&resBody); err != nil {
-		return err
+	resp, err := http.Post(workerURL+"/reply", "application/json", nil)
+	if err != nil {
+		return 0, err
 	}
-    	
-    	
-	info := &api.DelayInfo {
-	JobName:      nowstep.Name,
-	FromWorkerID: w.ID,
-	ToWorkerName: wk.Name,
-	RAM:          wkr.RAM,
-	CPU:          wkr.CPU,
-	Runtime:      wkr.Runtime,
-	Time:         delay,
-	}
-		reqBody, err := json.Marshal(&info)
-		
-		if err != nil {
-		return  err
+	defer resp.Body.Close()
+	if _, err := ioutil.ReadAll(resp.Body); err != nil {
+		return 0, err
 	}
+	return time.Since(start) / 2, nil
+}
 
-	u.Path = fmt.Sprintf("/delayinfo")
-	
-	req2, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(reqBody))
+const (
+	// masterへの定期的なリクエストのタイムアウト
+	masterRequestTimeout = 3 * time.Second
+	// ステップの実行依頼のタイムアウト. placementの待ち時間は含まない
+	requestDoStepTimeout = 30 * time.Second
+)
+
+// masterに決めてもらったワーカーへステップの実行を依頼する
+// 依頼先のワーカーと、自分以外のワーカーへ渡すのにかかった時間を返す
+func (w *Worker) requestDoStep(ctx context.Context, workflowID, fromStepID string, run *store.Run, step *domain.Step, payload *domain.Payload) (*api.ResponseWorker, time.Duration, error) {
+	// placementがqueueのステップは、masterがワーカーを見つけるまで返ってこないので、その分も待つ
+	c := &http.Client{Timeout: step.Placement.QueueTimeout() + requestDoStepTimeout}
+	wu := *w.MasterInfo.URL
+	wu.Path = fmt.Sprintf("/workflows/%s/steps/%s/worker", workflowID, step.ID)
+	q := url.Values{}
+	q.Set("previousJobWorkerID", w.ID)
+	q.Set("runID", run.ID)
+	q.Set("workflowVersion", strconv.Itoa(run.WorkflowVersion))
+	wu.RawQuery = q.Encode()
+	req, err := http.NewRequest(http.MethodGet, wu.String(), nil)
 	if err != nil {
-		return  err
+		return nil, 0, err
 	}
-	_, err = c.Do(req2)
+	resp, err := c.Do(req)
 	if err != nil {
-		return  err
-	}
-	}
-	if wk.ID == w.ID{
-	var ded time.Duration = 0
-    	
-	info := &api.DelayInfo {
-	JobName:      nowstep.Name,
-	FromWorkerID: w.ID,
-	ToWorkerName: wk.Name,
-	RAM:          wkr.RAM,
-	CPU:          wkr.CPU,
-	Runtime:      wkr.Runtime,
-	Time:         ded,
-	}
-		reqBody2, err := json.Marshal(&info)
-		
-		if err != nil {
-		return  err
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("failed to determine worker of step %s. status: %d", step.ID, resp.StatusCode)
+	}
+	var wk api.ResponseWorker
+	if err := json.NewDecoder(resp.Body).Decode(&wk); err != nil {
+		return nil, 0, err
 	}
 
-	u.Path = fmt.Sprintf("/delayinfo")
-	
-	req2, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(reqBody2))
+	reqBody, err := json.Marshal(payload)
 	if err != nil {
-		return  err
+		return nil, 0, err
 	}
-	_, err = c.Do(req2)
+	wkURL := fmt.Sprintf("%s/workflows/%s/steps/%s", wk.URL, workflowID, step.ID)
+	req, err = http.NewRequest(http.MethodPost, wkURL, bytes.NewReader(reqBody))
 	if err != nil {
-		return  err
+		return nil, 0, err
 	}
-	wkURL = fmt.Sprintf("%s/workflows/%s/steps/%s", wk.URL, workflowID, step.ID)
-	req, err = http.NewRequest(http.MethodPost, wkURL, bytes.NewReader(rbody))
-	_, err = c.Do(req)
+	setRunHeader(req, run, fromStepID)
+	start := time.Now()
+	resp2, err := c.Do(req)
 	if err != nil {
-		return  err
+		return nil, 0, err
 	}
-
+	resp2.Body.Close()
+	if resp2.StatusCode >= http.StatusBadRequest {
+		return nil, 0, fmt.Errorf("failed to request step %s to worker %s. status: %d", step.ID, wk.Name, resp2.StatusCode)
 	}
-	
-	return nil
+	if wk.ID == w.ID {
+		return &wk, 0, nil
+	}
+	return &wk, time.Since(start), nil
 }
 
-func (w *Worker) RunJob(ctx context.Context, workflowID, stepID string, body []byte) error {
-	j, err := w.JobStore.GetFromReady(ctx, stepID)
+func (w *Worker) RunJob(ctx context.Context, workflowID, stepID string, run *store.Run, payload *domain.Payload) error {
+	return w.runJob(ctx, workflowID, stepID, &store.RunningJob{
+		Run:     run,
+		Payload: payload,
+		Attempt: 1,
+	})
+}
+
+func (w *Worker) runJob(ctx context.Context, workflowID, stepID string, rj *store.RunningJob) error {
+	wf, err := w.getWorkflowOfRun(ctx, workflowID, rj.Run)
+	if err != nil {
+		return err
+	}
+	step := wf.StepByID(stepID)
+	if step == nil {
+		return ErrNotFoundStep
+	}
+	input, err := step.Input(rj.Payload)
+	if err != nil {
+		return err
+	}
+	// runのバージョンのジョブを使う. ワークフローが更新されていても新しいジョブとは混ざらない
+	key := store.JobKey(stepID, step.Job)
+	j, err := w.JobStore.GetFromReady(ctx, key)
 	switch err {
 	case store.ErrNotFound:
-		_, err := w.JobStore.GetFromPending(ctx, stepID)
+		_, err := w.JobStore.GetFromPending(ctx, key)
 		if err != nil {
 			if err != store.ErrNotFound {
 				return err
 			}
+			// drain中はデプロイし直さない. 送った側で他のワーカーへ送り直す
+			if w.IsDraining() {
+				return ErrDraining
+			}
 			// ジョブがデプロイされてない、かつデプロイ中でもない時
 			go func(ctx context.Context) {
 				if err := func(ctx context.Context) error {
-					if err := w.DeployJob(ctx, workflowID, stepID); err != nil {
+					if err := w.deployJobAfterStepIsNotPending(ctx, workflowID, step); err != nil {
 						return err
 					}
-					return w.RunJobAfterJobIsReady(ctx, stepID, body)
+					return w.RunJobAfterJobIsReady(ctx, workflowID, step, rj, input)
 				}(ctx); err != nil {
 					// TODO error notify
 					log.Println(err)
@@ -544,7 +646,7 @@ func (w *Worker) RunJob(ctx context.Context, workflowID, stepID string, body []b
 		}
 		// ジョブがデプロイされていないが、デプロイ中で完了待ちの時
 		go func() {
-			if err := w.RunJobAfterJobIsReady(context.Background(), stepID, body); err != nil {
+			if err := w.RunJobAfterJobIsReady(context.Background(), workflowID, step, rj, input); err != nil {
 				// TODO error notify
 				log.Println(err)
 			}
@@ -556,12 +658,12 @@ func (w *Worker) RunJob(ctx context.Context, workflowID, stepID string, body []b
 	}
 
 	// デプロイ済みの時
-	jobID, err := w.JobStore.SetRunningFromReady(ctx, stepID)
+	jobID, err := w.startRunningJob(ctx, workflowID, step, rj)
 	if err != nil {
 		return err
 	}
 	start := time.Now()
-	go j.Do(context.Background(), jobID, body)
+	go j.Do(context.Background(), jobID, input)
 	time1 := time.Since(start)
 	log.Println("run job is finished.......")
 	log.Println(time.Now())
@@ -570,19 +672,71 @@ func (w *Worker) RunJob(ctx context.Context, workflowID, stepID string, body []b
 	return nil
 }
 
-func (w *Worker) StartJobByTriggerHTTPPath(ctx context.Context, triggerPath string, body []byte) error {
+func (w *Worker) StartJobByTriggerHTTPPath(ctx context.Context, triggerPath string, body []byte) (*TriggerResult, error) {
+	if w.IsDraining() {
+		return nil, ErrDraining
+	}
 	wf, err := w.WorkflowStore.GetByTriggerHTTPPath(ctx, triggerPath)
+	if err != nil {
+		return nil, err
+	}
+	if !wf.Trigger.Sync {
+		return &TriggerResult{}, w.startWorkflow(ctx, wf, newRun(wf), body)
+	}
+	output, err := w.startWorkflowSync(ctx, wf, body)
+	if err != nil {
+		return nil, err
+	}
+	return &TriggerResult{
+		Sync:   true,
+		Output: output,
+	}, nil
+}
+
+// cronトリガーなど、masterからワークフローの開始を依頼された時
+func (w *Worker) StartWorkflow(ctx context.Context, workflowID string, body []byte) error {
+	if w.IsDraining() {
+		return ErrDraining
+	}
+	wf, err := w.WorkflowStore.Get(ctx, workflowID)
 	if err != nil {
 		return err
 	}
+	if wf == nil {
+		return ErrNotFoundWorkflow
+	}
+	return w.startWorkflow(ctx, wf, newRun(wf), body)
+}
+
+func newRun(wf *domain.Workflow) *store.Run {
+	return &store.Run{
+		ID:              xid.New().String(),
+		WorkflowVersion: wf.Version,
+	}
+}
+
+func (w *Worker) startWorkflow(ctx context.Context, wf *domain.Workflow, run *store.Run, body []byte) error {
+	// triggerのデータは名前付きの出力としても後続のステップへ渡す
+	var outputName string
+	if wf.Trigger != nil {
+		outputName = wf.Trigger.Output
+	}
+	payload := domain.NewPayload(nil).Next(outputName, body)
+	w.reportRunEvent(run, &domain.RunEvent{
+		Type:       domain.RunEventStarted,
+		WorkflowID: wf.ID,
+	})
 	eg := errgroup.Group{}
 	for _, s := range wf.Steps {
-		if s.After != "" {
+		if !s.IsRoot() {
 			continue
 		}
 		s := s
 		eg.Go(func() error {
-			return w.RunJob(ctx, wf.ID, s.ID, body)
+			if !shouldRunStep(s, payload) {
+				return w.skipStep(ctx, wf, s, run)
+			}
+			return w.RunJob(ctx, wf.ID, s.ID, run, payload)
 		})
 	}
 	return eg.Wait()
@@ -600,14 +754,25 @@ func (w *Worker) ListStepStatuses(ctx context.Context, workflowID string) ([]*Wo
 	if err != nil {
 		return nil, err
 	}
+	// まだ受け取っていないワークフローのジョブは無い
+	if wf == nil {
+		return []*WorkerStepStatus{}, nil
+	}
 	jobs, err := w.JobStore.ListAll(ctx)
 	if err != nil {
 		return nil, err
 	}
 	eg := errgroup.Group{}
+	// goroutineから追加するので、mutexで守る
+	mutex := new(sync.Mutex)
 	statuses := make([]*WorkerStepStatus, 0)
-	for _, j := range jobs {
-		j := j
+	appendStatus := func(s *WorkerStepStatus) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		statuses = append(statuses, s)
+	}
+	for key, j := range jobs {
+		key, j := key, j
 		eg.Go(func() error {
 			var step *domain.Step
 			for _, s := range wf.Steps {
@@ -615,15 +780,16 @@ func (w *Worker) ListStepStatuses(ctx context.Context, workflowID string) ([]*Wo
 					step = s
 				}
 			}
+			// 他のワークフローのジョブ
 			if step == nil {
-				return errors.New("unknown error. step is nil...")
+				return nil
 			}
-			pending, err := w.JobStore.IsPending(ctx, j.StepID())
+			pending, err := w.JobStore.IsPending(ctx, key)
 			if err != nil {
 				return err
 			}
 			if pending {
-				statuses = append(statuses, &WorkerStepStatus{
+				appendStatus(&WorkerStepStatus{
 					Step:       step,
 					IsPending:  true,
 					IsDeployed: false,
@@ -631,7 +797,10 @@ func (w *Worker) ListStepStatuses(ctx context.Context, workflowID string) ([]*Wo
 				})
 				return nil
 			}
-			ready, err := w.JobStore.IsReady(ctx, j.StepID())
+			ready, err := w.JobStore.IsReady(ctx, key)
+			if err != nil {
+				return err
+			}
 			if ready {
 				s := &WorkerStepStatus{
 					Step:       step,
@@ -639,12 +808,12 @@ func (w *Worker) ListStepStatuses(ctx context.Context, workflowID string) ([]*Wo
 					IsDeployed: true,
 					IsRunning:  false,
 				}
-				running, err := w.JobStore.IsRunning(ctx, j.StepID())
+				running, err := w.JobStore.IsRunning(ctx, key)
 				if err != nil {
 					return err
 				}
 				s.IsRunning = running
-				statuses = append(statuses, s)
+				appendStatus(s)
 			}
 			return nil
 		})
@@ -656,22 +825,33 @@ func (w *Worker) ListStepStatuses(ctx context.Context, workflowID string) ([]*Wo
 }
 
 func (w *Worker) FailJob(ctx context.Context, workflowID, stepID, jobID string, body []byte) error {
+	rj, err := w.JobStore.GetRunningJob(ctx, jobID)
+	if err != nil {
+		return err
+	}
 	if err := w.JobStore.DeleteRunningJob(ctx, jobID); err != nil {
 		return err
 	}
+	w.undeployJobIfOutdated(ctx, workflowID, stepID, rj.JobKey)
 	
 	w.AddError(errors.New(string(body)))
-	wf, err := w.WorkflowStore.Get(ctx, workflowID)
-	if err != nil {
-		return err
-	}
-	failureStep := wf.GetFailureStepByFailedStepID(stepID)
-	return w.requestDoStep(ctx, workflowID, failureStep, body)
+	return w.handleStepFailure(ctx, workflowID, stepID, rj, domain.RetryOnFail, body)
 }
 
 func (w *Worker) FinishJob(ctx context.Context, workflowID, stepID, jobID string) error {
+	rj, err := w.JobStore.GetRunningJob(ctx, jobID)
+	if err != nil {
+		return err
+	}
 	if err := w.JobStore.DeleteRunningJob(ctx, jobID); err != nil {
 		return err
 	}
+	w.undeployJobIfOutdated(ctx, workflowID, stepID, rj.JobKey)
+	w.reportRunEvent(rj.Run, &domain.RunEvent{
+		Type:       domain.RunEventStepSucceeded,
+		WorkflowID: workflowID,
+		StepID:     stepID,
+		Attempt:    rj.Attempt,
+	})
 	return nil
 }
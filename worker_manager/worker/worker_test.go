@@ -0,0 +1,63 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/worker_manager/store"
+)
+
+func TestListStepStatuses(t *testing.T) {
+	ctx := context.Background()
+	w := &Worker{
+		JobStore:      store.NewJob(),
+		WorkflowStore: store.NewWorkflow(),
+	}
+	wf := &domain.Workflow{ID: "wf", Version: 1}
+	for i := 0; i < 16; i++ {
+		wf.Steps = append(wf.Steps, &domain.Step{
+			ID:  fmt.Sprintf("s%d", i),
+			Job: &domain.Job{Name: fmt.Sprintf("job%d", i)},
+		})
+	}
+	if err := w.WorkflowStore.Set(ctx, wf.ID, wf); err != nil {
+		t.Fatal(err)
+	}
+	// 半分はデプロイ済み、半分はデプロイ中にする
+	for i, s := range wf.Steps {
+		if err := w.JobStore.SetPending(ctx, store.JobKey(s.ID, s.Job), &fakeJob{stepID: s.ID}); err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 0 {
+			if err := w.JobStore.SetReadyFromPending(ctx, s.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// 他のワークフローのジョブは含めない
+	if err := w.JobStore.SetPending(ctx, "other", &fakeJob{stepID: "other"}); err != nil {
+		t.Fatal(err)
+	}
+
+	statuses, err := w.ListStepStatuses(ctx, wf.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != len(wf.Steps) {
+		t.Fatalf("want %d statuses, got %d", len(wf.Steps), len(statuses))
+	}
+	deployed, pending := 0, 0
+	for _, s := range statuses {
+		if s.IsDeployed {
+			deployed++
+		}
+		if s.IsPending {
+			pending++
+		}
+	}
+	if deployed != len(wf.Steps)/2 || pending != len(wf.Steps)/2 {
+		t.Errorf("want %d deployed and %d pending, got %d and %d", len(wf.Steps)/2, len(wf.Steps)/2, deployed, pending)
+	}
+}
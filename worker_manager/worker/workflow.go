@@ -0,0 +1,86 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/worker_manager/job"
+	"github.com/mobmob912/takuhai/worker_manager/store"
+)
+
+// masterから受け取ったワークフローで置き換える
+// ジョブの中身が変わったステップは、実行中のジョブが終わってから古いジョブをアンデプロイする
+func (w *Worker) UpdateWorkflows(ctx context.Context, wfs []*domain.Workflow) error {
+	for _, wf := range wfs {
+		prev, err := w.WorkflowStore.Get(ctx, wf.ID)
+		if err != nil {
+			return err
+		}
+		if prev == nil || prev.Version == wf.Version {
+			continue
+		}
+		for _, ps := range prev.Steps {
+			s := wf.StepByID(ps.ID)
+			if s != nil && reflect.DeepEqual(s.Job, ps.Job) {
+				continue
+			}
+			go w.undeployJobWhenIdle(context.Background(), store.JobKey(ps.ID, ps.Job))
+		}
+	}
+	return w.WorkflowStore.UpdateAll(ctx, wfs)
+}
+
+// masterがワーカーをdrainする時に呼ぶ. デプロイ済みとデプロイ中のジョブを、実行中なら終わってから全てアンデプロイする
+func (w *Worker) UndeployAllJobs(ctx context.Context) error {
+	jobs, err := w.JobStore.ListAll(ctx)
+	if err != nil {
+		return err
+	}
+	for key := range jobs {
+		w.undeployJobWhenIdle(ctx, key)
+	}
+	return nil
+}
+
+// 古いバージョンのrunがデプロイし直したジョブは、実行が終わったらアンデプロイする
+// 今のワークフローと同じジョブならそのまま使う
+func (w *Worker) undeployJobIfOutdated(ctx context.Context, workflowID, stepID, key string) {
+	wf, err := w.WorkflowStore.Get(ctx, workflowID)
+	if err != nil {
+		w.AddError(err)
+		return
+	}
+	if wf != nil {
+		if s := wf.StepByID(stepID); s != nil && store.JobKey(s.ID, s.Job) == key {
+			return
+		}
+	}
+	go w.undeployJobWhenIdle(context.Background(), key)
+}
+
+func (w *Worker) undeployJobWhenIdle(ctx context.Context, key string) {
+	var j job.Job
+	for {
+		var err error
+		j, err = w.JobStore.DeleteReadyIfIdle(ctx, key)
+		if err == store.ErrNotFound {
+			return
+		}
+		if err == nil {
+			break
+		}
+		// デプロイ中のジョブは、デプロイが終わってからアンデプロイする
+		if err != store.ErrRunning && err != store.ErrPending {
+			w.AddError(err)
+			return
+		}
+		time.Sleep(1 * time.Second)
+	}
+	log.Printf("undeploy job. step id: %s, key: %s", j.StepID(), key)
+	if err := j.Undeploy(ctx); err != nil {
+		w.AddError(err)
+	}
+}
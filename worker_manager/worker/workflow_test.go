@@ -0,0 +1,148 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mobmob912/takuhai/domain"
+	"github.com/mobmob912/takuhai/worker_manager/store"
+)
+
+type fakeJob struct {
+	stepID     string
+	mutex      sync.Mutex
+	undeployed int
+}
+
+func (j *fakeJob) StepID() string {
+	return j.stepID
+}
+
+func (j *fakeJob) Name() string {
+	return j.stepID
+}
+
+func (j *fakeJob) Do(ctx context.Context, jobID string, body []byte) error {
+	return nil
+}
+
+func (j *fakeJob) Deploy(ctx context.Context) error {
+	return nil
+}
+
+func (j *fakeJob) Undeploy(ctx context.Context) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	j.undeployed++
+	return nil
+}
+
+func (j *fakeJob) undeployedCount() int {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	return j.undeployed
+}
+
+func TestUndeployAllJobs(t *testing.T) {
+	ctx := context.Background()
+	w := &Worker{JobStore: store.NewJob()}
+	jobs := make([]*fakeJob, 0)
+	for _, id := range []string{"a", "b", "c", "d"} {
+		j := &fakeJob{stepID: id}
+		jobs = append(jobs, j)
+		if err := w.JobStore.SetPending(ctx, id, j); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.JobStore.SetReadyFromPending(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// デプロイ中のジョブは、デプロイが終わってからアンデプロイされる
+	pending := &fakeJob{stepID: "e"}
+	jobs = append(jobs, pending)
+	if err := w.JobStore.SetPending(ctx, "e", pending); err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(100*time.Millisecond, func() {
+		if err := w.JobStore.SetReadyFromPending(ctx, "e"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	if err := w.UndeployAllJobs(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, j := range jobs {
+		if got := j.undeployedCount(); got != 1 {
+			t.Errorf("job %s: want undeployed once, got %d", j.stepID, got)
+		}
+	}
+	left, err := w.JobStore.ListAll(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(left) != 0 {
+		t.Errorf("want no jobs left, got %d", len(left))
+	}
+}
+
+func TestUndeployJobIfOutdated(t *testing.T) {
+	ctx := context.Background()
+	oldJob := &domain.Job{Name: "job", Images: []*domain.Image{{Image: "job:1"}}}
+	newJob := &domain.Job{Name: "job", Images: []*domain.Image{{Image: "job:2"}}}
+	oldKey := store.JobKey("s", oldJob)
+	newKey := store.JobKey("s", newJob)
+	if oldKey == newKey {
+		t.Fatalf("want different keys for different jobs, got %s", oldKey)
+	}
+
+	w := &Worker{
+		JobStore:      store.NewJob(),
+		WorkflowStore: store.NewWorkflow(),
+	}
+	wf := &domain.Workflow{
+		ID:      "wf",
+		Version: 2,
+		Steps:   []*domain.Step{{ID: "s", Job: newJob}},
+	}
+	if err := w.WorkflowStore.Set(ctx, wf.ID, wf); err != nil {
+		t.Fatal(err)
+	}
+	deployed := map[string]*fakeJob{
+		oldKey: {stepID: "s"},
+		newKey: {stepID: "s"},
+	}
+	for key, j := range deployed {
+		if err := w.JobStore.SetPending(ctx, key, j); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.JobStore.SetReadyFromPending(ctx, "s"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 今のワークフローのジョブは残す
+	w.undeployJobIfOutdated(ctx, wf.ID, "s", newKey)
+	// 古いバージョンのrunのジョブはアンデプロイする
+	w.undeployJobIfOutdated(ctx, wf.ID, "s", oldKey)
+
+	deadline := time.Now().Add(3 * time.Second)
+	for deployed[oldKey].undeployedCount() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := deployed[oldKey].undeployedCount(); got != 1 {
+		t.Errorf("old job: want undeployed once, got %d", got)
+	}
+	if got := deployed[newKey].undeployedCount(); got != 0 {
+		t.Errorf("new job: want not undeployed, got %d", got)
+	}
+	if _, err := w.JobStore.GetFromReady(ctx, oldKey); err != store.ErrNotFound {
+		t.Errorf("old job: want %v, got %v", store.ErrNotFound, err)
+	}
+	if _, err := w.JobStore.GetFromReady(ctx, newKey); err != nil {
+		t.Errorf("new job: want ready, got %v", err)
+	}
+}